@@ -0,0 +1,129 @@
+package afero
+
+import (
+	"io"
+	"os"
+)
+
+// AtomicWriter replaces the contents of a file all at once: everything
+// written to it goes to a temporary file in the target's directory, which
+// Close syncs and renames over the target. Until then, and if anything fails,
+// readers of the target keep seeing its previous contents.
+//
+// The guarantee holds wherever Rename replaces its target in one step:
+//   - OsFs on POSIX systems, where rename(2) is atomic. A crash may leave
+//     the temporary file behind, but never a half-written target.
+//   - MemMapFs, which renames under its lock. Handles opened on the old
+//     file before the rename keep reading the old data.
+//   - BasePathFs, as long as its source Fs provides the guarantee.
+//   - CopyOnWriteFs, where the temporary file and thus the result are
+//     written to the layer, shadowing the file in the base.
+type AtomicWriter struct {
+	fs     Fs
+	name   string
+	perm   os.FileMode
+	tmp    File
+	closed bool
+}
+
+// NewAtomicWriter starts an atomic replacement of filename. If the file
+// exists, its mode is preserved; otherwise the new file is given perm. The
+// mode is set with Chmod, so unlike with WriteFile no umask applies.
+// Call Close to commit the contents or Abort to discard them.
+func NewAtomicWriter(fs Fs, filename string, perm os.FileMode) (*AtomicWriter, error) {
+	if fi, err := fs.Stat(filename); err == nil {
+		if fi.IsDir() {
+			return nil, &os.PathError{Op: "open", Path: filename, Err: ErrIsDir}
+		}
+		perm = fi.Mode() & chmodBits
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	dir, base := pathsOf(fs).split(filename)
+	if dir == "" {
+		dir = "."
+	}
+	tmp, err := TempFile(fs, dir, "."+base+".*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	return &AtomicWriter{fs: fs, name: filename, perm: perm, tmp: tmp}, nil
+}
+
+// Name returns the name of the file being replaced.
+func (w *AtomicWriter) Name() string {
+	return w.name
+}
+
+func (w *AtomicWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, ErrFileClosed
+	}
+	return w.tmp.Write(p)
+}
+
+func (w *AtomicWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// Close commits the written contents: the temporary file is synced, closed,
+// given the target's mode and renamed over the target. On error the
+// temporary file is removed and the target is left untouched.
+func (w *AtomicWriter) Close() error {
+	if w.closed {
+		return ErrFileClosed
+	}
+	w.closed = true
+
+	tmpName := w.tmp.Name()
+	err := w.tmp.Sync()
+	if err1 := w.tmp.Close(); err == nil {
+		err = err1
+	}
+	if err == nil {
+		err = w.fs.Chmod(tmpName, w.perm)
+	}
+	if err == nil {
+		err = w.fs.Rename(tmpName, w.name)
+	}
+	if err != nil {
+		w.fs.Remove(tmpName)
+	}
+	return err
+}
+
+// Abort discards the written contents. It is a no-op after Close, so it is
+// safe to defer right after NewAtomicWriter.
+func (w *AtomicWriter) Abort() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	w.tmp.Close()
+	return w.fs.Remove(w.tmp.Name())
+}
+
+// WriteFileAtomic is like WriteFile but replaces filename atomically using
+// an AtomicWriter, so readers see either the old or the new contents and
+// never a partial write. An existing file keeps its mode.
+func (a Afero) WriteFileAtomic(filename string, data []byte, perm os.FileMode) error {
+	return WriteFileAtomic(a.Fs, filename, data, perm)
+}
+
+func WriteFileAtomic(fs Fs, filename string, data []byte, perm os.FileMode) error {
+	w, err := NewAtomicWriter(fs, filename, perm)
+	if err != nil {
+		return err
+	}
+	defer w.Abort()
+
+	n, err := w.Write(data)
+	if err == nil && n < len(data) {
+		err = io.ErrShortWrite
+	}
+	if err != nil {
+		return err
+	}
+	return w.Close()
+}
@@ -0,0 +1,109 @@
+package afero
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	osDir, err := TempDir(NewOsFs(), "", "afero-atomic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(osDir)
+
+	base := NewMemMapFs()
+	base.MkdirAll("/dir", 0755)
+	if err := WriteFile(base, "/dir/cow.txt", []byte("old"), 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		fs   Fs
+		file string
+	}{
+		{"OsFs", NewOsFs(), filepath.Join(osDir, "os.txt")},
+		{"MemMapFs", NewMemMapFs(), "/dir/mem.txt"},
+		{"BasePathFs", NewBasePathFs(NewMemMapFs(), "/base"), "/dir/base.txt"},
+		{"CopyOnWriteFs", NewCopyOnWriteFs(NewReadOnlyFs(base), NewMemMapFs()), "/dir/cow.txt"},
+	}
+	for _, test := range tests {
+		fs, file := test.fs, test.file
+		if test.name != "CopyOnWriteFs" {
+			fs.MkdirAll(filepath.Dir(file), 0755)
+			if err := WriteFile(fs, file, []byte("old"), 0640); err != nil {
+				t.Fatal(test.name, err)
+			}
+		}
+		reader, err := fs.Open(file)
+		if err != nil {
+			t.Fatal(test.name, err)
+		}
+
+		w, err := NewAtomicWriter(fs, file, 0600)
+		if err != nil {
+			t.Fatal(test.name, err)
+		}
+		if _, err := w.WriteString("new contents"); err != nil {
+			t.Fatal(test.name, err)
+		}
+		if data, _ := ReadFile(fs, file); string(data) != "old" {
+			t.Errorf("%s: target changed before Close: %q", test.name, data)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(test.name, err)
+		}
+
+		if data, _ := ReadFile(fs, file); string(data) != "new contents" {
+			t.Errorf("%s: unexpected contents after Close: %q", test.name, data)
+		}
+		if data, _ := ReadAll(reader); string(data) != "old" {
+			t.Errorf("%s: open handle saw %q instead of the old contents", test.name, data)
+		}
+		reader.Close()
+		if fi, err := fs.Stat(file); err != nil || fi.Mode().Perm() != 0640 {
+			t.Errorf("%s: expected mode 0640 to be preserved, got %v, %v", test.name, fi.Mode(), err)
+		}
+		if names, _ := readDirNames(fs, filepath.Dir(file)); len(names) != 1 {
+			t.Errorf("%s: expected only the target in its directory, got %v", test.name, names)
+		}
+
+		// aborting leaves the target alone
+		w, err = NewAtomicWriter(fs, file, 0600)
+		if err != nil {
+			t.Fatal(test.name, err)
+		}
+		w.WriteString("discarded")
+		if err := w.Abort(); err != nil {
+			t.Error(test.name, err)
+		}
+		if data, _ := ReadFile(fs, file); string(data) != "new contents" {
+			t.Errorf("%s: unexpected contents after Abort: %q", test.name, data)
+		}
+		if names, _ := readDirNames(fs, filepath.Dir(file)); len(names) != 1 {
+			t.Errorf("%s: expected temporary file to be removed, got %v", test.name, names)
+		}
+	}
+}
+
+func TestWriteFileAtomicNewFile(t *testing.T) {
+	fs := NewMemMapFs()
+	if err := fs.Mkdir("/dir", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteFileAtomic(fs, "/dir/new.txt", []byte("data"), 0604); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := fs.Stat("/dir/new.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode() != 0604 || fi.Size() != 4 {
+		t.Errorf("unexpected file: mode %v, size %d", fi.Mode(), fi.Size())
+	}
+	if err := WriteFileAtomic(fs, "/dir", nil, 0644); !IsDirErr(err) {
+		t.Errorf("expected EISDIR replacing a directory, got %v", err)
+	}
+}
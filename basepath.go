@@ -1,26 +1,52 @@
 package afero
 
 import (
+	"errors"
 	"os"
 	"path/filepath"
 	"runtime"
 	"strings"
+	"syscall"
 	"time"
 )
 
 var _ Lstater = (*BasePathFs)(nil)
+var _ Locker = (*BasePathFs)(nil)
+var _ XAttr = (*BasePathFs)(nil)
+var _ StatFS = (*BasePathFs)(nil)
+var _ Cloner = (*BasePathFs)(nil)
+var _ SlashPather = (*BasePathFs)(nil)
+var _ Symlinker = (*BasePathFs)(nil)
+
+// ErrEscapesBase is the error a secure BasePathFs wraps in an os.PathError or
+// os.LinkError when a symbolic link leads outside its base path.
+var ErrEscapesBase = errors.New("path escapes from base path")
+
+// errNoBeneath is returned by openBeneath when the caller must resolve the
+// path itself.
+var errNoBeneath = errors.New("openat2 not supported")
+
+// maxSymlinks is the number of symbolic links followed in a path before
+// failing with ELOOP, as on Linux.
+const maxSymlinks = 40
 
 // The BasePathFs restricts all operations to a given path within an Fs.
 // The given file name to the operations on this Fs will be prepended with
 // the base path before calling the base Fs.
 // Any file name (after filepath.Clean()) outside this base path will be
-// treated as non existing file.
+// treated as non existing file. On a source using slash paths, names are
+// slash paths too, handled with package path.
 //
 // Note that it does not clean the error messages on return, so you may
 // reveal the real path on errors.
+//
+// The base path is only enforced on the names given: a symbolic link in the
+// source can still point outside it. Use NewSecureBasePathFs to also check
+// the symbolic links followed.
 type BasePathFs struct {
 	source Fs
 	path   string
+	secure bool
 }
 
 type BasePathFile struct {
@@ -30,29 +56,151 @@ type BasePathFile struct {
 
 func (f *BasePathFile) Name() string {
 	sourcename := f.File.Name()
-	return strings.TrimPrefix(sourcename, filepath.Clean(f.path))
+	return strings.TrimPrefix(sourcename, f.path)
 }
 
 func NewBasePathFs(source Fs, path string) Fs {
 	return &BasePathFs{source: source, path: path}
 }
 
+// NewSecureBasePathFs returns a BasePathFs that also keeps symbolic links
+// from leading outside path. It resolves names one element at a time,
+// following symbolic links itself if the source supports them, and fails
+// with ErrEscapesBase when a link points outside path. The symbolic links it
+// creates point to relative paths, which must stay inside path too.
+//
+// On Linux 5.6 and later, files of an OsFs are opened with openat2(2) and
+// RESOLVE_BENEATH, so the kernel enforces the base path even while the tree
+// changes. Otherwise a symbolic link swapped in between resolving a name and
+// using it can still escape, so the source must not be shared with
+// untrusted writers.
+func NewSecureBasePathFs(source Fs, path string) Fs {
+	return &BasePathFs{source: source, path: path, secure: true}
+}
+
 // on a file outside the base path it returns the given file name and an error,
 // else the given file with the base path prepended
 func (b *BasePathFs) RealPath(name string) (path string, err error) {
-	if err := validateBasePathName(name); err != nil {
-		return name, err
+	p := pathsOf(b.source)
+	if p == hostPaths {
+		if err := validateBasePathName(name); err != nil {
+			return name, err
+		}
 	}
 
-	bpath := filepath.Clean(b.path)
-	path = filepath.Clean(filepath.Join(bpath, name))
-	if !strings.HasPrefix(path, bpath) {
+	bpath := p.clean(b.path)
+	path = p.clean(p.join(bpath, name))
+	if !within(p, path, bpath) {
 		return name, os.ErrNotExist
 	}
 
 	return path, nil
 }
 
+// within reports whether path is base or below it.
+func within(p *pathFuncs, path, base string) bool {
+	return path == base || strings.HasPrefix(path, strings.TrimSuffix(base, p.separator)+p.separator)
+}
+
+// resolve returns the path in the source of name, like RealPath. If b is
+// secure, it follows the symbolic links along the path, and the last
+// element too if follow is set, making sure none leads outside the base
+// path. Elements that do not exist are kept as given.
+func (b *BasePathFs) resolve(name string, follow bool) (string, error) {
+	path, err := b.RealPath(name)
+	if err != nil || !b.secure {
+		return path, err
+	}
+	lstater, ok := b.source.(Lstater)
+	if !ok {
+		return path, nil
+	}
+	reader, ok := b.source.(LinkReader)
+	if !ok {
+		return path, nil
+	}
+
+	p := pathsOf(b.source)
+	base := p.clean(b.path)
+	current, rest := base, splitPath(p, path[len(base):])
+	for links := 0; len(rest) > 0; {
+		elem := rest[0]
+		rest = rest[1:]
+		switch elem {
+		case ".":
+			continue
+		case "..":
+			if current == base {
+				return name, ErrEscapesBase
+			}
+			current = p.dir(current)
+			continue
+		}
+		next := p.join(current, elem)
+		if len(rest) == 0 && !follow {
+			return next, nil
+		}
+		fi, lstatCalled, err := lstater.LstatIfPossible(next)
+		if os.IsNotExist(err) || err == nil && (!lstatCalled || fi.Mode()&os.ModeSymlink == 0) {
+			current = next
+			continue
+		} else if err != nil {
+			return name, err
+		}
+
+		if links++; links > maxSymlinks {
+			return name, syscall.ELOOP
+		}
+		target, err := reader.ReadlinkIfPossible(next)
+		if err != nil {
+			return name, err
+		}
+		if p.isAbs(target) {
+			if target = p.clean(target); !within(p, target, base) {
+				return name, ErrEscapesBase
+			}
+			current, target = base, target[len(base):]
+		}
+		rest = append(splitPath(p, target), rest...)
+	}
+	return current, nil
+}
+
+// splitPath returns the elements of path.
+func splitPath(p *pathFuncs, path string) []string {
+	var elems []string
+	for _, elem := range strings.Split(path, p.separator) {
+		if elem != "" {
+			elems = append(elems, elem)
+		}
+	}
+	return elems
+}
+
+// openBeneath opens name like OpenFile with openat2(2) if b is secure and its
+// source an OsFs, or returns errNoBeneath, leaving the error of an invalid
+// name to the caller.
+func (b *BasePathFs) openBeneath(name string, flag int, mode os.FileMode) (File, error) {
+	if !b.secure {
+		return nil, errNoBeneath
+	}
+	switch b.source.(type) {
+	case *OsFs, OsFs:
+	default:
+		return nil, errNoBeneath
+	}
+	path, err := b.RealPath(name)
+	if err != nil {
+		return nil, errNoBeneath
+	}
+	base := filepath.Clean(b.path)
+	f, err := openBeneath(base, strings.TrimPrefix(path[len(base):], FilePathSeparator), flag, mode)
+	if err != nil {
+		return nil, err
+	}
+	return &BasePathFile{File: f, path: base}, nil
+}
+
 func validateBasePathName(name string) error {
 	if runtime.GOOS != "windows" {
 		// Not much to do here;
@@ -70,14 +218,14 @@ func validateBasePathName(name string) error {
 }
 
 func (b *BasePathFs) Chtimes(name string, atime, mtime time.Time) (err error) {
-	if name, err = b.RealPath(name); err != nil {
+	if name, err = b.resolve(name, true); err != nil {
 		return &os.PathError{Op: "chtimes", Path: name, Err: err}
 	}
 	return b.source.Chtimes(name, atime, mtime)
 }
 
 func (b *BasePathFs) Chmod(name string, mode os.FileMode) (err error) {
-	if name, err = b.RealPath(name); err != nil {
+	if name, err = b.resolve(name, true); err != nil {
 		return &os.PathError{Op: "chmod", Path: name, Err: err}
 	}
 	return b.source.Chmod(name, mode)
@@ -87,86 +235,99 @@ func (b *BasePathFs) Name() string {
 	return "BasePathFs"
 }
 
+func (b *BasePathFs) SlashPaths() bool {
+	return UsesSlashPaths(b.source)
+}
+
 func (b *BasePathFs) Stat(name string) (fi os.FileInfo, err error) {
-	if name, err = b.RealPath(name); err != nil {
+	if name, err = b.resolve(name, true); err != nil {
 		return nil, &os.PathError{Op: "stat", Path: name, Err: err}
 	}
 	return b.source.Stat(name)
 }
 
 func (b *BasePathFs) Rename(oldname, newname string) (err error) {
-	if oldname, err = b.RealPath(oldname); err != nil {
+	if oldname, err = b.resolve(oldname, false); err != nil {
 		return &os.PathError{Op: "rename", Path: oldname, Err: err}
 	}
-	if newname, err = b.RealPath(newname); err != nil {
+	if newname, err = b.resolve(newname, false); err != nil {
 		return &os.PathError{Op: "rename", Path: newname, Err: err}
 	}
 	return b.source.Rename(oldname, newname)
 }
 
 func (b *BasePathFs) RemoveAll(name string) (err error) {
-	if name, err = b.RealPath(name); err != nil {
+	if name, err = b.resolve(name, false); err != nil {
 		return &os.PathError{Op: "remove_all", Path: name, Err: err}
 	}
 	return b.source.RemoveAll(name)
 }
 
 func (b *BasePathFs) Remove(name string) (err error) {
-	if name, err = b.RealPath(name); err != nil {
+	if name, err = b.resolve(name, false); err != nil {
 		return &os.PathError{Op: "remove", Path: name, Err: err}
 	}
 	return b.source.Remove(name)
 }
 
 func (b *BasePathFs) OpenFile(name string, flag int, mode os.FileMode) (f File, err error) {
-	if name, err = b.RealPath(name); err != nil {
+	if f, err := b.openBeneath(name, flag, mode); err != errNoBeneath {
+		return f, err
+	}
+	if name, err = b.resolve(name, true); err != nil {
 		return nil, &os.PathError{Op: "openfile", Path: name, Err: err}
 	}
 	sourcef, err := b.source.OpenFile(name, flag, mode)
 	if err != nil {
 		return nil, err
 	}
-	return &BasePathFile{sourcef, b.path}, nil
+	return &BasePathFile{sourcef, pathsOf(b.source).clean(b.path)}, nil
 }
 
 func (b *BasePathFs) Open(name string) (f File, err error) {
-	if name, err = b.RealPath(name); err != nil {
+	if f, err := b.openBeneath(name, os.O_RDONLY, 0); err != errNoBeneath {
+		return f, err
+	}
+	if name, err = b.resolve(name, true); err != nil {
 		return nil, &os.PathError{Op: "open", Path: name, Err: err}
 	}
 	sourcef, err := b.source.Open(name)
 	if err != nil {
 		return nil, err
 	}
-	return &BasePathFile{File: sourcef, path: b.path}, nil
+	return &BasePathFile{File: sourcef, path: pathsOf(b.source).clean(b.path)}, nil
 }
 
 func (b *BasePathFs) Mkdir(name string, mode os.FileMode) (err error) {
-	if name, err = b.RealPath(name); err != nil {
+	if name, err = b.resolve(name, false); err != nil {
 		return &os.PathError{Op: "mkdir", Path: name, Err: err}
 	}
 	return b.source.Mkdir(name, mode)
 }
 
 func (b *BasePathFs) MkdirAll(name string, mode os.FileMode) (err error) {
-	if name, err = b.RealPath(name); err != nil {
+	if name, err = b.resolve(name, true); err != nil {
 		return &os.PathError{Op: "mkdir", Path: name, Err: err}
 	}
 	return b.source.MkdirAll(name, mode)
 }
 
 func (b *BasePathFs) Create(name string) (f File, err error) {
-	if name, err = b.RealPath(name); err != nil {
+	if f, err := b.openBeneath(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666); err != errNoBeneath {
+		return f, err
+	}
+	if name, err = b.resolve(name, true); err != nil {
 		return nil, &os.PathError{Op: "create", Path: name, Err: err}
 	}
 	sourcef, err := b.source.Create(name)
 	if err != nil {
 		return nil, err
 	}
-	return &BasePathFile{File: sourcef, path: b.path}, nil
+	return &BasePathFile{File: sourcef, path: pathsOf(b.source).clean(b.path)}, nil
 }
 
 func (b *BasePathFs) LstatIfPossible(name string) (os.FileInfo, bool, error) {
-	name, err := b.RealPath(name)
+	name, err := b.resolve(name, false)
 	if err != nil {
 		return nil, false, &os.PathError{Op: "lstat", Path: name, Err: err}
 	}
@@ -178,6 +339,9 @@ func (b *BasePathFs) LstatIfPossible(name string) (os.FileInfo, bool, error) {
 }
 
 func (b *BasePathFs) SymlinkIfPossible(oldname, newname string) error {
+	if b.secure {
+		return b.secureSymlink(oldname, newname)
+	}
 	oldname, err := b.RealPath(oldname)
 	if err != nil {
 		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: err}
@@ -192,8 +356,38 @@ func (b *BasePathFs) SymlinkIfPossible(oldname, newname string) error {
 	return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: ErrNoSymlink}
 }
 
+// secureSymlink creates newname pointing to the relative path of oldname from
+// the directory of newname, failing with ErrEscapesBase if oldname is outside
+// the base path. A relative oldname is taken relative to that directory.
+func (b *BasePathFs) secureSymlink(oldname, newname string) error {
+	linkError := func(err error) error {
+		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: err}
+	}
+	path, err := b.resolve(newname, false)
+	if err != nil {
+		return linkError(err)
+	}
+	p := pathsOf(b.source)
+	dir, base := p.dir(path), p.clean(b.path)
+	target := oldname
+	if p.isAbs(oldname) || strings.HasPrefix(oldname, p.separator) {
+		if target, err = b.RealPath(oldname); err != nil {
+			return linkError(err)
+		}
+	} else if target = p.join(dir, oldname); !within(p, target, base) {
+		return linkError(ErrEscapesBase)
+	}
+	if target, err = p.rel(dir, target); err != nil {
+		return linkError(err)
+	}
+	if linker, ok := b.source.(Linker); ok {
+		return linker.SymlinkIfPossible(target, path)
+	}
+	return linkError(ErrNoSymlink)
+}
+
 func (b *BasePathFs) ReadlinkIfPossible(name string) (string, error) {
-	name, err := b.RealPath(name)
+	name, err := b.resolve(name, false)
 	if err != nil {
 		return "", &os.PathError{Op: "readlink", Path: name, Err: err}
 	}
@@ -203,4 +397,80 @@ func (b *BasePathFs) ReadlinkIfPossible(name string) (string, error) {
 	return "", &os.PathError{Op: "readlink", Path: name, Err: ErrNoReadlink}
 }
 
+func (b *BasePathFs) LockIfPossible(name string, typ LockType, wait bool) (Unlocker, error) {
+	name, err := b.resolve(name, true)
+	if err != nil {
+		return nil, &os.PathError{Op: "lock", Path: name, Err: err}
+	}
+	return LockFile(b.source, name, typ, wait)
+}
+
+func (b *BasePathFs) GetXAttr(name, attr string) ([]byte, error) {
+	name, err := b.resolve(name, true)
+	if err != nil {
+		return nil, &os.PathError{Op: "getxattr", Path: name, Err: err}
+	}
+	if x, ok := b.source.(XAttr); ok {
+		return x.GetXAttr(name, attr)
+	}
+	return nil, &os.PathError{Op: "getxattr", Path: name, Err: ErrNoXAttr}
+}
+
+func (b *BasePathFs) SetXAttr(name, attr string, value []byte) error {
+	name, err := b.resolve(name, true)
+	if err != nil {
+		return &os.PathError{Op: "setxattr", Path: name, Err: err}
+	}
+	if x, ok := b.source.(XAttr); ok {
+		return x.SetXAttr(name, attr, value)
+	}
+	return &os.PathError{Op: "setxattr", Path: name, Err: ErrNoXAttr}
+}
+
+func (b *BasePathFs) ListXAttrs(name string) ([]string, error) {
+	name, err := b.resolve(name, true)
+	if err != nil {
+		return nil, &os.PathError{Op: "listxattr", Path: name, Err: err}
+	}
+	if x, ok := b.source.(XAttr); ok {
+		return x.ListXAttrs(name)
+	}
+	return nil, &os.PathError{Op: "listxattr", Path: name, Err: ErrNoXAttr}
+}
+
+func (b *BasePathFs) RemoveXAttr(name, attr string) error {
+	name, err := b.resolve(name, true)
+	if err != nil {
+		return &os.PathError{Op: "removexattr", Path: name, Err: err}
+	}
+	if x, ok := b.source.(XAttr); ok {
+		return x.RemoveXAttr(name, attr)
+	}
+	return &os.PathError{Op: "removexattr", Path: name, Err: ErrNoXAttr}
+}
+
+func (b *BasePathFs) StatFS(name string) (*FsStats, error) {
+	name, err := b.resolve(name, true)
+	if err != nil {
+		return nil, &os.PathError{Op: "statfs", Path: name, Err: err}
+	}
+	if s, ok := b.source.(StatFS); ok {
+		return s.StatFS(name)
+	}
+	return nil, &os.PathError{Op: "statfs", Path: name, Err: ErrNoStatFS}
+}
+
+func (b *BasePathFs) CloneFile(oldname, newname string) (err error) {
+	if oldname, err = b.resolve(oldname, true); err != nil {
+		return &os.PathError{Op: "clone", Path: oldname, Err: err}
+	}
+	if newname, err = b.resolve(newname, true); err != nil {
+		return &os.PathError{Op: "clone", Path: newname, Err: err}
+	}
+	if c, ok := b.source.(Cloner); ok {
+		return c.CloneFile(oldname, newname)
+	}
+	return &os.LinkError{Op: "clone", Old: oldname, New: newname, Err: ErrNoClone}
+}
+
 // vim: ts=4 sw=4 noexpandtab nolist syn=go
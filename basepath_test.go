@@ -4,6 +4,7 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"syscall"
 	"testing"
 )
 
@@ -188,3 +189,114 @@ func TestBasePathTempFile(t *testing.T) {
 		t.Fatalf("TempFile realpath leaked: expected %s, got %s", expected, actual)
 	}
 }
+
+func TestBasePathPrefix(t *testing.T) {
+	bp := NewBasePathFs(&MemMapFs{}, "/base").(*BasePathFs)
+	if _, err := bp.RealPath("../basement/file"); err != os.ErrNotExist {
+		t.Errorf("expected a sibling sharing the prefix of the base path to be outside, got %v", err)
+	}
+}
+
+func TestSecureBasePath(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks need privileges on Windows")
+	}
+	fs := NewOsFs()
+	baseDir, err := TempDir(fs, "", "base")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.RemoveAll(baseDir)
+	outside, err := TempDir(fs, "", "outside")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.RemoveAll(outside)
+	if err := WriteFile(fs, filepath.Join(outside, "secret"), []byte("secret"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.MkdirAll(filepath.Join(baseDir, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteFile(fs, filepath.Join(baseDir, "sub", "file"), []byte("file"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	rel, err := filepath.Rel(baseDir, outside)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for link, target := range map[string]string{
+		"abs":    outside,
+		"rel":    rel,
+		"inside": filepath.Join(baseDir, "sub"),
+		"loop1":  "loop2",
+		"loop2":  "loop1",
+	} {
+		if err := os.Symlink(target, filepath.Join(baseDir, link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if data, err := ReadFile(NewBasePathFs(fs, baseDir), "/abs/secret"); err != nil || string(data) != "secret" {
+		t.Fatalf("expected a plain BasePathFs to follow links out, got %q, %v", data, err)
+	}
+
+	bp := NewSecureBasePathFs(fs, baseDir)
+	escapes := func(err error) bool {
+		switch err := err.(type) {
+		case *os.PathError:
+			return err.Err == ErrEscapesBase
+		case *os.LinkError:
+			return err.Err == ErrEscapesBase
+		}
+		return false
+	}
+	for _, name := range []string{"/abs/secret", "rel/secret", "/sub/../abs/secret"} {
+		if _, err := ReadFile(bp, name); !escapes(err) {
+			t.Errorf("%s: expected ErrEscapesBase, got %v", name, err)
+		}
+		if _, err := bp.Stat(name); !escapes(err) {
+			t.Errorf("%s: expected Stat to fail with ErrEscapesBase, got %v", name, err)
+		}
+	}
+	if _, err := bp.Create("/abs/new"); !escapes(err) {
+		t.Errorf("expected creating through a link out to fail with ErrEscapesBase, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(outside, "new")); !os.IsNotExist(err) {
+		t.Errorf("expected no file created outside, got %v", err)
+	}
+	if err := bp.Chmod("/abs/secret", 0666); !escapes(err) {
+		t.Errorf("expected Chmod to fail with ErrEscapesBase, got %v", err)
+	}
+	if _, err := bp.Stat("/loop1"); !os.IsNotExist(err) && underlyingError(err) != syscall.ELOOP {
+		t.Errorf("expected ELOOP, got %v", err)
+	}
+
+	if data, err := ReadFile(bp, "/inside/file"); err != nil || string(data) != "file" {
+		t.Errorf("expected to follow a link inside the base path, got %q, %v", data, err)
+	}
+	if fi, _, err := bp.(Lstater).LstatIfPossible("/abs"); err != nil || fi.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("expected to lstat a link out, got %v", err)
+	}
+
+	linker := bp.(Symlinker)
+	if err := linker.SymlinkIfPossible("/sub/file", "/sub/link"); err != nil {
+		t.Fatal(err)
+	}
+	if target, err := linker.ReadlinkIfPossible("/sub/link"); err != nil || target != "file" {
+		t.Errorf("expected a relative target, got %q, %v", target, err)
+	}
+	if data, err := ReadFile(bp, "/sub/link"); err != nil || string(data) != "file" {
+		t.Errorf("expected to read through the new link, got %q, %v", data, err)
+	}
+	if err := linker.SymlinkIfPossible("../../etc", "/sub/bad"); !escapes(err) {
+		t.Errorf("expected a link out to fail with ErrEscapesBase, got %v", err)
+	}
+
+	if err := bp.Remove("/abs"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(outside, "secret")); err != nil {
+		t.Errorf("expected Remove not to follow the link, got %v", err)
+	}
+}
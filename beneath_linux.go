@@ -0,0 +1,85 @@
+package afero
+
+import (
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	resolveNoMagiclinks = 0x02
+	resolveBeneath      = 0x08
+)
+
+// openHow is struct open_how of openat2(2).
+type openHow struct {
+	flags   uint64
+	mode    uint64
+	resolve uint64
+}
+
+// maxOpenat2Retries bounds how often openBeneath retries openat2(2) after it
+// failed with EAGAIN because of a concurrent rename or mount, before leaving
+// the lookup to the caller.
+const maxOpenat2Retries = 8
+
+// noOpenat2 is set once the kernel turns out not to have openat2(2), which
+// appeared in Linux 5.6.
+var noOpenat2 uint32
+
+// openBeneath opens name relative to the directory root with openat2(2),
+// which refuses to leave root through ".." or symbolic links, atomically. It
+// returns errNoBeneath if the kernel lacks openat2, or if name leads outside
+// root or through a symbolic link to an absolute path, which openat2 refuses
+// altogether, or if it keeps racing with renames; the caller must then
+// resolve name itself.
+func openBeneath(root, name string, flag int, perm os.FileMode) (*os.File, error) {
+	if atomic.LoadUint32(&noOpenat2) != 0 {
+		return nil, errNoBeneath
+	}
+	if name == "" {
+		name = "."
+	}
+	path := filepath.Join(root, name)
+	p, err := syscall.BytePtrFromString(name)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: path, Err: err}
+	}
+	dir, err := syscall.Open(root, syscall.O_RDONLY|syscall.O_DIRECTORY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: root, Err: err}
+	}
+	defer syscall.Close(dir)
+
+	how := openHow{
+		flags:   uint64(flag | syscall.O_CLOEXEC | syscall.O_LARGEFILE),
+		resolve: resolveBeneath | resolveNoMagiclinks,
+	}
+	if flag&os.O_CREATE != 0 {
+		how.mode = uint64(perm.Perm())
+	}
+	for retries := 0; ; {
+		fd, _, errno := syscall.Syscall6(sysOpenat2, uintptr(dir), uintptr(unsafe.Pointer(p)),
+			uintptr(unsafe.Pointer(&how)), unsafe.Sizeof(how), 0, 0)
+		switch errno {
+		case 0:
+			return os.NewFile(fd, path), nil
+		case syscall.EINTR:
+			continue
+		case syscall.EAGAIN:
+			// raced with a rename or mount somewhere
+			if retries++; retries < maxOpenat2Retries {
+				continue
+			}
+			return nil, errNoBeneath
+		case syscall.ENOSYS:
+			atomic.StoreUint32(&noOpenat2, 1)
+			return nil, errNoBeneath
+		case syscall.EXDEV:
+			return nil, errNoBeneath
+		}
+		return nil, &os.PathError{Op: "open", Path: path, Err: errno}
+	}
+}
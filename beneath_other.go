@@ -0,0 +1,11 @@
+// +build !linux
+
+package afero
+
+import (
+	"os"
+)
+
+func openBeneath(root, name string, flag int, perm os.FileMode) (*os.File, error) {
+	return nil, errNoBeneath
+}
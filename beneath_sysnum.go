@@ -0,0 +1,8 @@
+// +build linux
+// +build !mips,!mipsle,!mips64,!mips64le
+
+package afero
+
+// sysOpenat2 is the number of openat2(2), the same on all architectures
+// but MIPS, whose ABIs number their system calls from their own bases.
+const sysOpenat2 = 437
@@ -0,0 +1,8 @@
+// +build linux
+// +build mips64 mips64le
+
+package afero
+
+// sysOpenat2 is the number of openat2(2) in the MIPS n64 ABI. Go does not
+// support the n32 ABI, which numbers it 6437.
+const sysOpenat2 = 5437
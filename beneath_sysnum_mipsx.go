@@ -0,0 +1,7 @@
+// +build linux
+// +build mips mipsle
+
+package afero
+
+// sysOpenat2 is the number of openat2(2) in the MIPS o32 ABI.
+const sysOpenat2 = 4437
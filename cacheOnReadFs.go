@@ -2,7 +2,6 @@ package afero
 
 import (
 	"os"
-	"path/filepath"
 	"syscall"
 	"time"
 )
@@ -24,12 +23,27 @@ type CacheOnReadFs struct {
 	base      Fs
 	layer     Fs
 	cacheTime time.Duration
+	clock     Clock
 }
 
 func NewCacheOnReadFs(base Fs, layer Fs, cacheTime time.Duration) Fs {
 	return &CacheOnReadFs{base: base, layer: layer, cacheTime: cacheTime}
 }
 
+// SetClock sets the clock against which the cache time of files in the
+// layer expires. It should be the clock that stamps files in the layer, such
+// as the one given to MemMapFs.SetClock. A nil clock means the system clock.
+func (u *CacheOnReadFs) SetClock(clock Clock) {
+	u.clock = clock
+}
+
+func (u *CacheOnReadFs) now() time.Time {
+	if u.clock == nil {
+		return time.Now()
+	}
+	return u.clock.Now()
+}
+
 type cacheState int
 
 const (
@@ -53,7 +67,7 @@ func (u *CacheOnReadFs) cacheStatus(name string) (state cacheState, fi os.FileIn
 		if u.cacheTime == 0 {
 			return cacheHit, lfi, nil
 		}
-		if lfi.ModTime().Add(u.cacheTime).Before(time.Now()) {
+		if lfi.ModTime().Add(u.cacheTime).Before(u.now()) {
 			bfi, err = u.base.Stat(name)
 			if err != nil {
 				return cacheLocal, lfi, nil
@@ -255,6 +269,11 @@ func (u *CacheOnReadFs) Open(name string) (File, error) {
 	return &UnionFile{Base: bfile, Layer: lfile}, nil
 }
 
+// LockIfPossible locks the file in the base, which all writes go through.
+func (u *CacheOnReadFs) LockIfPossible(name string, typ LockType, wait bool) (Unlocker, error) {
+	return LockFile(u.base, name, typ, wait)
+}
+
 func (u *CacheOnReadFs) Mkdir(name string, perm os.FileMode) error {
 	err := u.base.Mkdir(name, perm)
 	if err != nil {
@@ -267,6 +286,12 @@ func (u *CacheOnReadFs) Name() string {
 	return "CacheOnReadFs"
 }
 
+// SlashPaths reports whether the layer uses slash paths, which the base
+// should use too.
+func (u *CacheOnReadFs) SlashPaths() bool {
+	return UsesSlashPaths(u.layer)
+}
+
 func (u *CacheOnReadFs) MkdirAll(name string, perm os.FileMode) error {
 	err := u.base.MkdirAll(name, perm)
 	if err != nil {
@@ -282,7 +307,8 @@ func (u *CacheOnReadFs) Create(name string) (File, error) {
 	}
 
 	// since base succeeded, ensure directory exists in layer too
-	err = u.MkdirAll(filepath.Dir(normalizePath(name)), 0700)
+	p := pathsOf(u.layer)
+	err = u.MkdirAll(p.dir(p.normalize(name)), 0700)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,84 @@
+package afero
+
+import (
+	"errors"
+	"io"
+	"os"
+	"reflect"
+	"syscall"
+)
+
+// Cloner is an optional interface in Afero. It is only implemented by the
+// filesystems saying so.
+// It copies a file cheaply, sharing its storage with the original until
+// either is changed where the filesystem can, like reflinks on Linux.
+type Cloner interface {
+	// CloneFile copies the contents of the regular file oldname to
+	// newname, which is created with the permissions of oldname or
+	// truncated if it exists.
+	CloneFile(oldname, newname string) error
+}
+
+// ErrNoClone is the error wrapped in an os.LinkError if a file system does
+// not support cloning files either directly or through its delegated
+// filesystem.
+var ErrNoClone = errors.New("cloning files not supported")
+
+func (a Afero) CopyFile(dst string, srcFs Fs, src string) error {
+	return CopyFile(a.Fs, dst, srcFs, src)
+}
+
+// CopyFile copies the contents of the regular file src on srcFs to dst on
+// dstFs, creating dst with the permissions of src or truncating it if it
+// exists. If both are the same Fs and it is a Cloner, the file is cloned.
+func CopyFile(dstFs Fs, dst string, srcFs Fs, src string) error {
+	if c, ok := srcFs.(Cloner); ok && sameFs(srcFs, dstFs) {
+		err := c.CloneFile(src, dst)
+		if !isNoClone(err) {
+			return err
+		}
+	}
+	return copyFile(dstFs, dst, srcFs, src)
+}
+
+// sameFs reports whether a and b are the same Fs, without panicking on
+// uncomparable types.
+func sameFs(a, b Fs) bool {
+	return reflect.TypeOf(a) == reflect.TypeOf(b) && reflect.TypeOf(a).Comparable() && a == b
+}
+
+func isNoClone(err error) bool {
+	if linkErr, ok := err.(*os.LinkError); ok {
+		err = linkErr.Err
+	}
+	return err == ErrNoClone || err == syscall.ENOTSUP || err == syscall.EXDEV
+}
+
+// copyFile copies src to dst by reading and writing.
+func copyFile(dstFs Fs, dst string, srcFs Fs, src string) error {
+	in, err := srcFs.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	fi, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	if fi.IsDir() {
+		return &os.PathError{Op: "copy", Path: src, Err: ErrIsDir}
+	}
+	if dfi, err := dstFs.Stat(dst); err == nil && SameFile(fi, dfi) {
+		// truncating dst would lose the contents to copy
+		return nil
+	}
+	out, err := dstFs.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, fi.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,8 @@
+// +build linux
+// +build mips mipsle mips64 mips64le ppc64 ppc64le sparc64
+
+package afero
+
+// ficlone is the FICLONE ioctl, _IOW(0x94, 9, int), where the direction
+// bits of ioctl numbers differ from the generic encoding.
+const ficlone = 0x80049409
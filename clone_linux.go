@@ -0,0 +1,93 @@
+package afero
+
+import (
+	"io"
+	"os"
+	"runtime"
+	"syscall"
+)
+
+// sysCopyFileRange is the number of copy_file_range(2), which package syscall
+// lacks on most architectures, or zero where it is not known.
+var sysCopyFileRange = map[string]uintptr{
+	"386":      377,
+	"amd64":    326,
+	"arm":      391,
+	"arm64":    285,
+	"loong64":  285,
+	"mips":     4360,
+	"mipsle":   4360,
+	"mips64":   5320,
+	"mips64le": 5320,
+	"ppc64":    379,
+	"ppc64le":  379,
+	"riscv64":  285,
+	"s390x":    375,
+}[runtime.GOARCH]
+
+// cloneOsFile clones oldname with the FICLONE ioctl where the filesystem
+// supports reflinks, such as Btrfs and XFS, and otherwise copies it with
+// copy_file_range(2), which lets the kernel or a network filesystem copy
+// without going through user space, or with io.Copy where that is not
+// available.
+func cloneOsFile(oldname, newname string) error {
+	src, err := os.Open(oldname)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	fi, err := src.Stat()
+	if err != nil {
+		return err
+	}
+	if fi.IsDir() {
+		return &os.LinkError{Op: "clone", Old: oldname, New: newname, Err: syscall.EISDIR}
+	}
+	if dfi, err := os.Stat(newname); err == nil && os.SameFile(fi, dfi) {
+		// truncating newname would lose the contents to clone
+		return nil
+	}
+	dst, err := os.OpenFile(newname, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, fi.Mode().Perm())
+	if err != nil {
+		return err
+	}
+
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, dst.Fd(), ficlone, src.Fd())
+	switch errno {
+	case 0:
+	case syscall.EOPNOTSUPP, syscall.EXDEV, syscall.EINVAL, syscall.ENOTTY, syscall.ENOSYS:
+		// no reflinks here, or across filesystems
+		if err := copyFileRange(dst, src); err != nil {
+			dst.Close()
+			return err
+		}
+	default:
+		dst.Close()
+		return &os.LinkError{Op: "clone", Old: oldname, New: newname, Err: errno}
+	}
+	return dst.Close()
+}
+
+// copyFileRange copies the rest of src to dst with copy_file_range(2), or
+// with io.Copy if the kernel or filesystems cannot.
+func copyFileRange(dst, src *os.File) error {
+	for sysCopyFileRange != 0 {
+		n, _, errno := syscall.Syscall6(sysCopyFileRange, src.Fd(), 0, dst.Fd(), 0, 1<<30, 0)
+		if errno == syscall.EINTR {
+			continue
+		}
+		if errno == syscall.ENOSYS || errno == syscall.EXDEV || errno == syscall.EOPNOTSUPP {
+			// the offsets of both files are where the copy stopped,
+			// so io.Copy picks up from there
+			break
+		}
+		if errno != 0 {
+			return &os.LinkError{Op: "copy_file_range", Old: src.Name(), New: dst.Name(), Err: errno}
+		}
+		if n == 0 {
+			return nil
+		}
+	}
+	_, err := io.Copy(dst, src)
+	return err
+}
@@ -0,0 +1,11 @@
+// +build !linux
+
+package afero
+
+import (
+	"os"
+)
+
+func cloneOsFile(oldname, newname string) error {
+	return &os.LinkError{Op: "clone", Old: oldname, New: newname, Err: ErrNoClone}
+}
@@ -0,0 +1,164 @@
+package afero
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func testCloneFile(t *testing.T, fs Fs, dir string) {
+	data := bytes.Repeat([]byte("0123456789abcdef"), 20000)
+	src, dst := filepath.Join(dir, "src"), filepath.Join(dir, "dst")
+	if err := WriteFile(fs, src, data, 0640); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.(Cloner).CloneFile(src, dst); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := ReadFile(fs, dst); err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("expected the clone to have the same contents, got %d bytes, %v", len(got), err)
+	}
+	if fi, err := fs.Stat(dst); err != nil || fi.Mode().Perm() != 0640 {
+		t.Errorf("expected the clone to have mode 0640, got %v, %v", fi.Mode(), err)
+	}
+
+	// changing either file leaves the other alone
+	f, err := fs.OpenFile(dst, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteAt([]byte("changed"), 100000); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if err := WriteFile(fs, src, []byte("short"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	expected := append([]byte{}, data...)
+	copy(expected[100000:], "changed")
+	if got, err := ReadFile(fs, dst); err != nil || !bytes.Equal(got, expected) {
+		t.Errorf("expected the clone to keep its own changes only, got %v", err)
+	}
+	if got, err := ReadFile(fs, src); err != nil || string(got) != "short" {
+		t.Errorf("expected the original to keep its own changes only, got %q, %v", got, err)
+	}
+
+	// cloning over an existing file truncates it
+	if err := fs.(Cloner).CloneFile(src, dst); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := ReadFile(fs, dst); err != nil || string(got) != "short" {
+		t.Errorf("expected the clone to be replaced, got %q, %v", got, err)
+	}
+	// cloning a file onto itself leaves it alone
+	if err := fs.(Cloner).CloneFile(src, src); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := ReadFile(fs, src); err != nil || string(got) != "short" {
+		t.Errorf("expected cloning a file onto itself to keep it, got %q, %v", got, err)
+	}
+	if err := fs.(Cloner).CloneFile(dir, filepath.Join(dir, "dir")); err == nil {
+		t.Error("expected cloning a directory to fail")
+	}
+	if err := fs.(Cloner).CloneFile(filepath.Join(dir, "missing"), dst); !os.IsNotExist(err) {
+		t.Errorf("expected cloning a missing file to fail with ErrNotExist, got %v", err)
+	}
+}
+
+func TestMemMapFsCloneFile(t *testing.T) {
+	fs := NewMemMapFs()
+	if err := fs.Mkdir("/dir", 0755); err != nil {
+		t.Fatal(err)
+	}
+	testCloneFile(t, fs, "/dir")
+}
+
+func TestOsFsCloneFile(t *testing.T) {
+	osfs := NewOsFs()
+	dir, err := TempDir(osfs, "", "afero-clone")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer osfs.RemoveAll(dir)
+	if err := osfs.(Cloner).CloneFile(dir, filepath.Join(dir, "probe")); isNoClone(err) {
+		t.Skip("cloning not supported here")
+	}
+	testCloneFile(t, osfs, dir)
+}
+
+func TestBasePathFsCloneFile(t *testing.T) {
+	fs := NewMemMapFs()
+	if err := fs.MkdirAll("/base/dir", 0755); err != nil {
+		t.Fatal(err)
+	}
+	testCloneFile(t, NewBasePathFs(fs, "/base"), "/dir")
+	if _, err := fs.Stat("/base/dir/dst"); err != nil {
+		t.Errorf("expected the clone in the source, got %v", err)
+	}
+}
+
+func TestCopyFile(t *testing.T) {
+	src, dst := NewMemMapFs(), NewMemMapFs()
+	if err := WriteFile(src, "/file", []byte("data"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	// between file systems, and within one by cloning
+	for _, fs := range []Fs{dst, src} {
+		if err := CopyFile(fs, "/copy", src, "/file"); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := ReadFile(fs, "/copy"); err != nil || string(got) != "data" {
+			t.Errorf("expected data, got %q, %v", got, err)
+		}
+		if fi, err := fs.Stat("/copy"); err != nil || fi.Mode().Perm() != 0600 {
+			t.Errorf("expected mode 0600, got %v, %v", fi.Mode(), err)
+		}
+	}
+	if err := CopyFile(dst, "/dir", src, "/"); err == nil {
+		t.Error("expected copying a directory to fail")
+	}
+	if err := CopyFile(NewReadOnlyFs(src), "/copy", NewReadOnlyFs(src), "/file"); err != syscall.EPERM {
+		t.Errorf("expected EPERM copying within a ReadOnlyFs, got %v", err)
+	}
+}
+
+func TestCopyFileSameFile(t *testing.T) {
+	osfs := NewOsFs()
+	dir, err := TempDir(osfs, "", "afero-copy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer osfs.RemoveAll(dir)
+	name, link := filepath.Join(dir, "file"), filepath.Join(dir, "link")
+	if err := WriteFile(osfs, name, []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Link(name, link); err != nil {
+		t.Skipf("hard links not supported here: %v", err)
+	}
+
+	// embedding only the Fs interface hides cloning, to test the copy
+	for _, fs := range []Fs{osfs, struct{ Fs }{osfs}} {
+		for _, dst := range []string{name, link} {
+			if err := CopyFile(fs, dst, fs, name); err != nil {
+				t.Fatal(err)
+			}
+			if got, err := ReadFile(fs, name); err != nil || string(got) != "data" {
+				t.Errorf("expected copying %s onto itself to keep it, got %q, %v", dst, got, err)
+			}
+		}
+	}
+
+	mem := NewMemMapFs()
+	if err := WriteFile(mem, "/file", []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := CopyFile(struct{ Fs }{mem}, "/file", mem, "/file"); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := ReadFile(mem, "/file"); err != nil || string(got) != "data" {
+		t.Errorf("expected copying a file onto itself to keep it, got %q, %v", got, err)
+	}
+}
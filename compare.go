@@ -0,0 +1,558 @@
+package afero
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// maxDiffSize is the largest file, in bytes, for which Compare renders a
+// unified content diff. Larger or binary files are only reported as changed.
+const maxDiffSize = 1 << 20
+
+// maxDiffEdits is the largest number of inserted and deleted lines for which
+// Compare renders a unified content diff. Files further apart are only
+// reported as changed, which bounds the time and memory spent on the diff.
+const maxDiffEdits = 1000
+
+// CompareOptions controls what Compare considers a difference.
+// The zero value compares types, contents, modes and modification times.
+type CompareOptions struct {
+	// IgnoreModTimes skips comparing modification times.
+	IgnoreModTimes bool
+
+	// ModTimePrecision truncates modification times before comparing them,
+	// which helps when one side only stores seconds (or two seconds, on FAT).
+	ModTimePrecision time.Duration
+
+	// IgnoreModes skips comparing permission bits.
+	IgnoreModes bool
+
+	// Ignore lists patterns, in the syntax of filepath.Match, of paths to
+	// leave out. A pattern is matched against the path relative to the
+	// compared root and against its base name. An ignored directory is
+	// skipped with all of its contents. When both file systems use slash
+	// paths, relative paths are slash paths, and patterns use the syntax
+	// of path.Match.
+	Ignore []string
+
+	// SkipContentDiff reports changed contents without rendering a unified
+	// diff for them.
+	SkipContentDiff bool
+
+	// Context is the number of unchanged lines shown around each change in
+	// a unified diff. Zero means 3.
+	Context int
+}
+
+// ChangeType describes how a path differs between two trees.
+type ChangeType int
+
+const (
+	// FileAdded means the path only exists in the second tree.
+	FileAdded ChangeType = iota + 1
+	// FileRemoved means the path only exists in the first tree.
+	FileRemoved
+	// FileModified means the path exists in both trees but differs.
+	FileModified
+)
+
+func (c ChangeType) String() string {
+	switch c {
+	case FileAdded:
+		return "A"
+	case FileRemoved:
+		return "D"
+	case FileModified:
+		return "M"
+	}
+	return "?"
+}
+
+// FileChange describes a single path that differs between two trees.
+type FileChange struct {
+	// Path is relative to the compared roots.
+	Path string
+	Type ChangeType
+
+	// A and B describe the path in the first and second tree; either is nil
+	// when the path does not exist on that side.
+	A, B os.FileInfo
+
+	TypeChanged    bool
+	ContentChanged bool
+	ModeChanged    bool
+	ModTimeChanged bool
+
+	// UnifiedDiff holds the content diff in unified format, if one could be
+	// rendered.
+	UnifiedDiff string
+}
+
+// TreeDiff is the result of Compare, with changes sorted by path.
+type TreeDiff struct {
+	Changes []FileChange
+}
+
+// Equal reports whether the compared trees had no differences.
+func (d *TreeDiff) Equal() bool {
+	return len(d.Changes) == 0
+}
+
+// String renders a human readable report of all changes, one path per line
+// followed by mode and time mismatches and the unified content diff.
+func (d *TreeDiff) String() string {
+	var buf bytes.Buffer
+	for _, c := range d.Changes {
+		fmt.Fprintf(&buf, "%s  %s\n", c.Type, c.Path)
+		if c.TypeChanged {
+			fmt.Fprintf(&buf, "   type: %s -> %s\n", fileKind(c.A), fileKind(c.B))
+		}
+		if c.ModeChanged {
+			fmt.Fprintf(&buf, "   mode: %s -> %s\n", c.A.Mode(), c.B.Mode())
+		}
+		if c.ModTimeChanged {
+			fmt.Fprintf(&buf, "   mtime: %s -> %s\n",
+				c.A.ModTime().Format(time.RFC3339Nano), c.B.ModTime().Format(time.RFC3339Nano))
+		}
+		if c.ContentChanged && c.UnifiedDiff == "" && c.Type == FileModified {
+			buf.WriteString("   contents differ\n")
+		}
+		buf.WriteString(c.UnifiedDiff)
+	}
+	return buf.String()
+}
+
+// Compare reports the differences between root on a and otherRoot on other.
+// See the Compare function.
+func (a Afero) Compare(root string, other Fs, otherRoot string, opts *CompareOptions) (*TreeDiff, error) {
+	return Compare(a.Fs, root, other, otherRoot, opts)
+}
+
+// Compare walks aRoot on a and bRoot on b and reports every path that was
+// added, removed or changed going from the first tree to the second.
+// The two file systems may be of any type, e.g. a MemMapFs holding generated
+// output and an OsFs holding the expected result. A nil opts compares
+// everything.
+//
+// Symlinks are not followed; if both file systems implement LinkReader,
+// their targets are compared as contents.
+func Compare(a Fs, aRoot string, b Fs, bRoot string, opts *CompareOptions) (*TreeDiff, error) {
+	if opts == nil {
+		opts = &CompareOptions{}
+	}
+	paths := commonPaths(a, b)
+	aInfos, err := collectTree(a, aRoot, paths, opts)
+	if err != nil {
+		return nil, err
+	}
+	bInfos, err := collectTree(b, bRoot, paths, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	rels := make([]string, 0, len(aInfos)+len(bInfos))
+	for p := range aInfos {
+		rels = append(rels, p)
+	}
+	for p := range bInfos {
+		if _, ok := aInfos[p]; !ok {
+			rels = append(rels, p)
+		}
+	}
+	sort.Strings(rels)
+
+	pa, pb := pathsOf(a), pathsOf(b)
+	diff := &TreeDiff{}
+	for _, p := range rels {
+		afi, bfi := aInfos[p], bInfos[p]
+		c := FileChange{Path: p, A: afi, B: bfi}
+		aName, bName := pa.join(aRoot, paths.convert(p, pa)), pb.join(bRoot, paths.convert(p, pb))
+		switch {
+		case afi == nil:
+			c.Type = FileAdded
+			if err := c.renderDiff(nil, "", b, bName, opts); err != nil {
+				return nil, err
+			}
+		case bfi == nil:
+			c.Type = FileRemoved
+			if err := c.renderDiff(a, aName, nil, "", opts); err != nil {
+				return nil, err
+			}
+		default:
+			changed, err := c.compare(a, aName, b, bName, opts)
+			if err != nil {
+				return nil, err
+			}
+			if !changed {
+				continue
+			}
+			c.Type = FileModified
+		}
+		diff.Changes = append(diff.Changes, c)
+	}
+	return diff, nil
+}
+
+// collectTree returns the FileInfo of every path below root, keyed by its
+// path relative to root, in the syntax of paths.
+func collectTree(fs Fs, root string, paths *pathFuncs, opts *CompareOptions) (map[string]os.FileInfo, error) {
+	infos := make(map[string]os.FileInfo)
+	err := Walk(fs, root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		p := pathsOf(fs)
+		rel, err := p.rel(root, path)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		rel = p.convert(rel, paths)
+		if matchesAny(paths, opts.Ignore, rel) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		infos[rel] = info
+		return nil
+	})
+	return infos, err
+}
+
+// matchesAny reports whether the relative path rel or its base name matches
+// any of the patterns, given in the syntax of the match function of paths.
+func matchesAny(paths *pathFuncs, patterns []string, rel string) bool {
+	base := paths.base(rel)
+	for _, pattern := range patterns {
+		pattern = paths.fromSlash(pattern)
+		if ok, _ := paths.match(pattern, rel); ok {
+			return true
+		}
+		if ok, _ := paths.match(pattern, base); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// compare fills in the mismatch flags of c and reports whether any is set.
+func (c *FileChange) compare(a Fs, aName string, b Fs, bName string, opts *CompareOptions) (bool, error) {
+	afi, bfi := c.A, c.B
+	if fileKind(afi) != fileKind(bfi) {
+		c.TypeChanged = true
+		return true, nil
+	}
+
+	if !opts.IgnoreModes && afi.Mode() != bfi.Mode() {
+		c.ModeChanged = true
+	}
+
+	switch {
+	case afi.IsDir():
+		// directory times change whenever an entry does, so only
+		// the entries themselves are compared
+	case afi.Mode()&os.ModeSymlink != 0:
+		aTarget, aErr := readlinkIfPossible(a, aName)
+		bTarget, bErr := readlinkIfPossible(b, bName)
+		if aErr == nil && bErr == nil && aTarget != bTarget {
+			c.ContentChanged = true
+			if !opts.SkipContentDiff {
+				c.UnifiedDiff = unifiedDiff(c.Path, aTarget+"\n", bTarget+"\n", opts.context())
+			}
+		}
+	default:
+		if !opts.IgnoreModTimes {
+			at, bt := afi.ModTime(), bfi.ModTime()
+			if opts.ModTimePrecision > 0 {
+				at, bt = at.Truncate(opts.ModTimePrecision), bt.Truncate(opts.ModTimePrecision)
+			}
+			c.ModTimeChanged = !at.Equal(bt)
+		}
+		same, err := sameContents(a, aName, b, bName, afi.Size(), bfi.Size())
+		if err != nil {
+			return false, err
+		}
+		if !same {
+			c.ContentChanged = true
+			if err := c.renderDiff(a, aName, b, bName, opts); err != nil {
+				return false, err
+			}
+		}
+	}
+	return c.ModeChanged || c.ModTimeChanged || c.ContentChanged, nil
+}
+
+// renderDiff sets c.UnifiedDiff for regular files, where a nil Fs stands for
+// a side on which the file does not exist.
+func (c *FileChange) renderDiff(a Fs, aName string, b Fs, bName string, opts *CompareOptions) error {
+	if opts.SkipContentDiff {
+		return nil
+	}
+	for _, fi := range []os.FileInfo{c.A, c.B} {
+		if fi != nil && (!fi.Mode().IsRegular() || fi.Size() > maxDiffSize) {
+			return nil
+		}
+	}
+	var aData, bData []byte
+	var err error
+	if a != nil {
+		if aData, err = ReadFile(a, aName); err != nil {
+			return err
+		}
+	}
+	if b != nil {
+		if bData, err = ReadFile(b, bName); err != nil {
+			return err
+		}
+	}
+	if bytes.IndexByte(aData, 0) >= 0 || bytes.IndexByte(bData, 0) >= 0 {
+		return nil
+	}
+	c.UnifiedDiff = unifiedDiff(c.Path, string(aData), string(bData), opts.context())
+	return nil
+}
+
+func (o *CompareOptions) context() int {
+	if o.Context > 0 {
+		return o.Context
+	}
+	return 3
+}
+
+func fileKind(fi os.FileInfo) string {
+	switch {
+	case fi == nil:
+		return "missing"
+	case fi.IsDir():
+		return "directory"
+	case fi.Mode()&os.ModeSymlink != 0:
+		return "symlink"
+	case fi.Mode().IsRegular():
+		return "file"
+	}
+	return "special"
+}
+
+func readlinkIfPossible(fs Fs, name string) (string, error) {
+	if r, ok := fs.(LinkReader); ok {
+		return r.ReadlinkIfPossible(name)
+	}
+	return "", &os.PathError{Op: "readlink", Path: name, Err: ErrNoReadlink}
+}
+
+// sameContents reports whether both files hold the same bytes, reading them
+// in lockstep, one chunk of each at a time, so large files are never loaded
+// whole.
+func sameContents(a Fs, aName string, b Fs, bName string, aSize, bSize int64) (bool, error) {
+	if aSize != bSize {
+		return false, nil
+	}
+	af, err := a.Open(aName)
+	if err != nil {
+		return false, err
+	}
+	defer af.Close()
+	bf, err := b.Open(bName)
+	if err != nil {
+		return false, err
+	}
+	defer bf.Close()
+
+	abuf, bbuf := make([]byte, 32*1024), make([]byte, 32*1024)
+	for {
+		an, aErr := io.ReadFull(af, abuf)
+		bn, bErr := io.ReadFull(bf, bbuf)
+		if !bytes.Equal(abuf[:an], bbuf[:bn]) {
+			return false, nil
+		}
+		aDone := aErr == io.EOF || aErr == io.ErrUnexpectedEOF
+		bDone := bErr == io.EOF || bErr == io.ErrUnexpectedEOF
+		if aErr != nil && !aDone {
+			return false, aErr
+		}
+		if bErr != nil && !bDone {
+			return false, bErr
+		}
+		if aDone || bDone {
+			return aDone && bDone, nil
+		}
+	}
+}
+
+// diffOp is one line of an edit script: ' ' keeps, '-' deletes and '+'
+// inserts a line.
+type diffOp struct {
+	kind byte
+	line string
+}
+
+// unifiedDiff renders the difference between two texts in unified format,
+// with name used for both file headers. A missing side is given as the
+// empty string and shown as /dev/null when the other side has content.
+// It returns the empty string when the texts are more than maxDiffEdits
+// lines apart.
+func unifiedDiff(name, a, b string, context int) string {
+	ops, ok := diffLines(splitLines(a), splitLines(b), maxDiffEdits)
+	if !ok {
+		return ""
+	}
+
+	var buf bytes.Buffer
+	aName, bName := "a/"+filepath.ToSlash(name), "b/"+filepath.ToSlash(name)
+	if a == "" {
+		aName = "/dev/null"
+	}
+	if b == "" {
+		bName = "/dev/null"
+	}
+	fmt.Fprintf(&buf, "--- %s\n+++ %s\n", aName, bName)
+
+	// aLine[i] and bLine[i] count the lines of each side before ops[i]
+	aLine, bLine := make([]int, len(ops)+1), make([]int, len(ops)+1)
+	for i, op := range ops {
+		aLine[i+1], bLine[i+1] = aLine[i], bLine[i]
+		if op.kind != '+' {
+			aLine[i+1]++
+		}
+		if op.kind != '-' {
+			bLine[i+1]++
+		}
+	}
+
+	for i := 0; i < len(ops); {
+		if ops[i].kind == ' ' {
+			i++
+			continue
+		}
+		// extend the hunk while the next change is close enough that
+		// the contexts would overlap
+		last := i
+		for j := i + 1; j < len(ops) && j <= last+2*context+1; j++ {
+			if ops[j].kind != ' ' {
+				last = j
+			}
+		}
+		start, end := i-context, last+1+context
+		if start < 0 {
+			start = 0
+		}
+		if end > len(ops) {
+			end = len(ops)
+		}
+
+		aStart, aCount := aLine[start]+1, aLine[end]-aLine[start]
+		bStart, bCount := bLine[start]+1, bLine[end]-bLine[start]
+		if aCount == 0 {
+			aStart--
+		}
+		if bCount == 0 {
+			bStart--
+		}
+		fmt.Fprintf(&buf, "@@ -%d,%d +%d,%d @@\n", aStart, aCount, bStart, bCount)
+		for _, op := range ops[start:end] {
+			buf.WriteByte(op.kind)
+			buf.WriteString(op.line)
+			if !strings.HasSuffix(op.line, "\n") {
+				buf.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+		i = end
+	}
+	return buf.String()
+}
+
+// splitLines splits s after each newline, keeping the newlines.
+func splitLines(s string) []string {
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// diffLines computes a shortest edit script from a to b with the Myers
+// algorithm. It gives up, returning false, when the script needs more than
+// maxEdits insertions and deletions, so that the trace it keeps stays within
+// O(maxEdits²).
+func diffLines(a, b []string, maxEdits int) ([]diffOp, bool) {
+	n, m := len(a), len(b)
+	max := n + m
+	if max > maxEdits {
+		max = maxEdits
+	}
+	offset := max + 1
+	v := make([]int, 2*max+3)
+	// trace[d] holds v[offset-d-1 : offset+d+2] before step d, which is
+	// all the backtracking below reads from it
+	var trace [][]int
+
+	found := false
+search:
+	for d := 0; d <= max; d++ {
+		trace = append(trace, append([]int(nil), v[offset-d-1:offset+d+2]...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				found = true
+				break search
+			}
+		}
+	}
+	if !found {
+		return nil, false
+	}
+
+	// walk the trace backwards from the end to recover the script
+	var ops []diffOp
+	x, y := n, m
+	for d := len(trace) - 1; d >= 0; d-- {
+		v, off := trace[d], d+1
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && v[off+k-1] < v[off+k+1]) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := v[off+prevK]
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			x--
+			y--
+			ops = append(ops, diffOp{' ', a[x]})
+		}
+		if d > 0 {
+			if x == prevX {
+				y--
+				ops = append(ops, diffOp{'+', b[y]})
+			} else {
+				x--
+				ops = append(ops, diffOp{'-', a[x]})
+			}
+		}
+	}
+	for i, j := 0, len(ops)-1; i < j; i, j = i+1, j-1 {
+		ops[i], ops[j] = ops[j], ops[i]
+	}
+	return ops, true
+}
@@ -0,0 +1,169 @@
+package afero
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func setupCompareTrees(t *testing.T) (Fs, Fs) {
+	a, b := NewMemMapFs(), NewMemMapFs()
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	for _, fs := range []Fs{a, b} {
+		if err := fs.MkdirAll("/root/sub", 0755); err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range []string{"/root/same.txt", "/root/sub/changed.txt", "/root/removed.txt"} {
+			if err := WriteFile(fs, name, []byte("one\ntwo\nthree\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := fs.Chtimes(name, mtime, mtime); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	return a, b
+}
+
+func TestCompareEqual(t *testing.T) {
+	a, b := setupCompareTrees(t)
+	diff, err := Compare(a, "/root", b, "/root", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !diff.Equal() {
+		t.Errorf("expected equal trees, got:\n%s", diff)
+	}
+}
+
+func TestCompareChanges(t *testing.T) {
+	a, b := setupCompareTrees(t)
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := b.Remove("/root/removed.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteFile(b, "/root/added.txt", []byte("new\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteFile(b, "/root/sub/changed.txt", []byte("one\n2\nthree\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Chtimes("/root/sub/changed.txt", mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Chmod("/root/same.txt", 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	diff, err := Compare(a, "/root", b, "/root", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []struct {
+		path string
+		typ  ChangeType
+	}{
+		{"added.txt", FileAdded},
+		{"removed.txt", FileRemoved},
+		{"same.txt", FileModified},
+		{"sub/changed.txt", FileModified},
+	}
+	if len(diff.Changes) != len(expected) {
+		t.Fatalf("expected %d changes, got:\n%s", len(expected), diff)
+	}
+	for i, e := range expected {
+		c := diff.Changes[i]
+		if c.Path != normalizeSlashes(e.path) || c.Type != e.typ {
+			t.Errorf("change %d: expected %s %s, got %s %s", i, e.typ, e.path, c.Type, c.Path)
+		}
+	}
+	if c := diff.Changes[2]; !c.ModeChanged || c.ContentChanged || c.ModTimeChanged {
+		t.Errorf("expected only a mode change for same.txt, got %+v", c)
+	}
+
+	const wantDiff = "--- a/sub/changed.txt\n+++ b/sub/changed.txt\n" +
+		"@@ -1,3 +1,3 @@\n one\n-two\n+2\n three\n"
+	if c := diff.Changes[3]; !c.ContentChanged || c.UnifiedDiff != wantDiff {
+		t.Errorf("unexpected diff for changed.txt:\n%s", c.UnifiedDiff)
+	}
+	if !strings.Contains(diff.String(), "+++ /dev/null") {
+		t.Errorf("expected removed file to be diffed against /dev/null:\n%s", diff)
+	}
+}
+
+func TestCompareOptions(t *testing.T) {
+	a, b := setupCompareTrees(t)
+	if err := b.Chtimes("/root/same.txt", time.Now(), time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Chmod("/root/removed.txt", 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteFile(b, "/root/sub/changed.txt", []byte("other"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	diff, err := Compare(a, "/root", b, "/root", &CompareOptions{
+		IgnoreModTimes: true,
+		IgnoreModes:    true,
+		Ignore:         []string{"sub"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !diff.Equal() {
+		t.Errorf("expected ignored differences not to be reported, got:\n%s", diff)
+	}
+}
+
+func TestUnifiedDiffHunks(t *testing.T) {
+	var a, b []string
+	for i := 1; i <= 20; i++ {
+		line := strings.Repeat("x", i)
+		a = append(a, line+"\n")
+		if i == 2 || i == 18 {
+			line = "changed"
+		}
+		b = append(b, line+"\n")
+	}
+	diff := unifiedDiff("f", strings.Join(a, ""), strings.Join(b, ""), 3)
+	if n := strings.Count(diff, "@@ -"); n != 2 {
+		t.Errorf("expected 2 hunks, got %d:\n%s", n, diff)
+	}
+	if !strings.Contains(diff, "@@ -1,5 +1,5 @@\n") || !strings.Contains(diff, "@@ -15,6 +15,6 @@\n") {
+		t.Errorf("unexpected hunk headers:\n%s", diff)
+	}
+
+	diff = unifiedDiff("f", "a", "b", 3)
+	if !strings.Contains(diff, "-a\n\\ No newline at end of file\n+b\n") {
+		t.Errorf("expected missing newline marker:\n%s", diff)
+	}
+}
+
+func TestUnifiedDiffTooManyEdits(t *testing.T) {
+	var a, b bytes.Buffer
+	for i := 0; i < 10000; i++ {
+		fmt.Fprintf(&a, "a%d\n", i)
+		fmt.Fprintf(&b, "b%d\n", i)
+	}
+	if diff := unifiedDiff("f", a.String(), b.String(), 3); diff != "" {
+		t.Errorf("expected no diff beyond maxDiffEdits, got %d bytes", len(diff))
+	}
+
+	fs := NewMemMapFs()
+	WriteReader(fs, "/a/f", &a)
+	WriteReader(fs, "/b/f", &b)
+	diff, err := Compare(fs, "/a", fs, "/b", &CompareOptions{IgnoreModTimes: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := diff.String(); !strings.Contains(s, "contents differ") {
+		t.Errorf("expected the change to be reported without a diff, got:\n%.200s", s)
+	}
+}
+
+func normalizeSlashes(p string) string {
+	return strings.Replace(p, "/", FilePathSeparator, -1)
+}
@@ -340,9 +340,13 @@ func TestUnionCacheWrite(t *testing.T) {
 }
 
 func TestUnionCacheExpire(t *testing.T) {
+	clock := NewManualClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
 	base := &MemMapFs{}
 	layer := &MemMapFs{}
+	base.SetClock(clock)
+	layer.SetClock(clock)
 	ufs := &CacheOnReadFs{base: base, layer: layer, cacheTime: 1 * time.Second}
+	ufs.SetClock(clock)
 
 	base.Mkdir("/data", 0777)
 
@@ -357,12 +361,18 @@ func TestUnionCacheExpire(t *testing.T) {
 	fh.Close()
 
 	fh, _ = base.Create("/data/file.txt")
-	// sleep some time, so we really get a different time.Now() on write...
-	time.Sleep(2 * time.Second)
 	fh.WriteString("Another test")
 	fh.Close()
 
 	data, _ := ReadFile(ufs, "/data/file.txt")
+	if string(data) != "This is a test" {
+		t.Errorf("cached file expired early: <%s>", data)
+	}
+
+	clock.Advance(2 * time.Second)
+	WriteFile(base, "/data/file.txt", []byte("Another test"), 0644)
+
+	data, _ = ReadFile(ufs, "/data/file.txt")
 	if string(data) != "Another test" {
 		t.Errorf("cache time failed: <%s>", data)
 	}
@@ -20,3 +20,6 @@ import (
 )
 
 const BADFD = syscall.EBADF
+
+// ENOATTR is returned when reading an extended attribute that is not set.
+const ENOATTR = syscall.ENOATTR
@@ -24,3 +24,6 @@ import (
 )
 
 const BADFD = syscall.EBADFD
+
+// ENOATTR is returned when reading an extended attribute that is not set.
+const ENOATTR = syscall.ENODATA
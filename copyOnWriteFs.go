@@ -3,12 +3,15 @@ package afero
 import (
 	"fmt"
 	"os"
-	"path/filepath"
 	"syscall"
 	"time"
 )
 
 var _ Lstater = (*CopyOnWriteFs)(nil)
+var _ Locker = (*CopyOnWriteFs)(nil)
+var _ XAttr = (*CopyOnWriteFs)(nil)
+var _ StatFS = (*CopyOnWriteFs)(nil)
+var _ SlashPather = (*CopyOnWriteFs)(nil)
 
 // The CopyOnWriteFs is a union filesystem: a read only base file system with
 // a possibly writeable layer on top. Changes to the file system will only
@@ -137,6 +140,104 @@ func (u *CopyOnWriteFs) ReadlinkIfPossible(name string) (string, error) {
 	return "", &os.PathError{Op: "readlink", Path: name, Err: ErrNoReadlink}
 }
 
+// LockIfPossible locks the file in the overlay, copying it there first if it
+// is only present in the base.
+func (u *CopyOnWriteFs) LockIfPossible(name string, typ LockType, wait bool) (Unlocker, error) {
+	b, err := u.isBaseFile(name)
+	if err != nil {
+		return nil, err
+	}
+	if b {
+		if err := u.copyToLayer(name); err != nil {
+			return nil, err
+		}
+	}
+	return LockFile(u.layer, name, typ, wait)
+}
+
+func (u *CopyOnWriteFs) GetXAttr(name, attr string) ([]byte, error) {
+	b, err := u.isBaseFile(name)
+	if err != nil {
+		return nil, err
+	}
+	fs := u.layer
+	if b {
+		fs = u.base
+	}
+	if x, ok := fs.(XAttr); ok {
+		return x.GetXAttr(name, attr)
+	}
+	return nil, &os.PathError{Op: "getxattr", Path: name, Err: ErrNoXAttr}
+}
+
+// SetXAttr sets the attribute in the overlay, copying the file there first
+// if it is only present in the base.
+func (u *CopyOnWriteFs) SetXAttr(name, attr string, value []byte) error {
+	b, err := u.isBaseFile(name)
+	if err != nil {
+		return err
+	}
+	if b {
+		if err := u.copyToLayer(name); err != nil {
+			return err
+		}
+	}
+	if x, ok := u.layer.(XAttr); ok {
+		return x.SetXAttr(name, attr, value)
+	}
+	return &os.PathError{Op: "setxattr", Path: name, Err: ErrNoXAttr}
+}
+
+func (u *CopyOnWriteFs) ListXAttrs(name string) ([]string, error) {
+	b, err := u.isBaseFile(name)
+	if err != nil {
+		return nil, err
+	}
+	fs := u.layer
+	if b {
+		fs = u.base
+	}
+	if x, ok := fs.(XAttr); ok {
+		return x.ListXAttrs(name)
+	}
+	return nil, &os.PathError{Op: "listxattr", Path: name, Err: ErrNoXAttr}
+}
+
+// RemoveXAttr removes the attribute in the overlay, copying the file there
+// first if it is only present in the base.
+func (u *CopyOnWriteFs) RemoveXAttr(name, attr string) error {
+	b, err := u.isBaseFile(name)
+	if err != nil {
+		return err
+	}
+	if b {
+		if err := u.copyToLayer(name); err != nil {
+			return err
+		}
+	}
+	if x, ok := u.layer.(XAttr); ok {
+		return x.RemoveXAttr(name, attr)
+	}
+	return &os.PathError{Op: "removexattr", Path: name, Err: ErrNoXAttr}
+}
+
+// StatFS reports the statistics of the overlay, where all writes go. The
+// named file does not need to exist in it yet; its closest existing parent
+// is used instead.
+func (u *CopyOnWriteFs) StatFS(name string) (*FsStats, error) {
+	if s, ok := u.layer.(StatFS); ok {
+		dir := name
+		for {
+			parent := pathsOf(u.layer).dir(dir)
+			if _, err := u.layer.Stat(dir); err == nil || parent == dir {
+				return s.StatFS(dir)
+			}
+			dir = parent
+		}
+	}
+	return nil, &os.PathError{Op: "statfs", Path: name, Err: ErrNoStatFS}
+}
+
 func (u *CopyOnWriteFs) isNotExist(err error) bool {
 	if e, ok := err.(*os.PathError); ok {
 		err = e.Err
@@ -204,7 +305,7 @@ func (u *CopyOnWriteFs) OpenFile(name string, flag int, perm os.FileMode) (File,
 			return u.layer.OpenFile(name, flag, perm)
 		}
 
-		dir := filepath.Dir(name)
+		dir := pathsOf(u.layer).dir(name)
 		isaDir, err := IsDir(u.base, dir)
 		if err != nil && !os.IsNotExist(err) {
 			return nil, err
@@ -291,7 +392,8 @@ func (u *CopyOnWriteFs) Mkdir(name string, perm os.FileMode) error {
 	}
 
 	// ensure parent exists and is a directory
-	parentPath := filepath.Dir(normalizePath(name))
+	p := pathsOf(u.layer)
+	parentPath := p.dir(p.normalize(name))
 	baseDir, _ := IsDir(u.base, parentPath)
 	layerDir, layerErr := IsDir(u.layer, parentPath)
 
@@ -315,6 +417,12 @@ func (u *CopyOnWriteFs) Name() string {
 	return "CopyOnWriteFs"
 }
 
+// SlashPaths reports whether the layer uses slash paths, which the base
+// should use too.
+func (u *CopyOnWriteFs) SlashPaths() bool {
+	return UsesSlashPaths(u.layer)
+}
+
 func (u *CopyOnWriteFs) MkdirAll(name string, perm os.FileMode) error {
 	dir, err := IsDir(u.base, name)
 	if err != nil {
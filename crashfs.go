@@ -0,0 +1,593 @@
+package afero
+
+import (
+	"io"
+	mathrand "math/rand"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+var _ SlashPather = (*CrashFs)(nil)
+
+// CrashPolicy selects what survives of the state that was not made durable
+// when a CrashFs crashes.
+type CrashPolicy int
+
+const (
+	// CrashDropUnsynced loses everything that was not synced.
+	CrashDropUnsynced CrashPolicy = iota
+	// CrashKeepPrefix keeps unsynced operations in the order they were
+	// made, up to a random point. A write at that point may be torn.
+	CrashKeepPrefix
+	// CrashReorder keeps each unsynced operation or not independently of
+	// the others, as a disk reordering writes would. Kept writes may be
+	// torn.
+	CrashReorder
+)
+
+// CrashOptions configures CrashFs.Crash.
+type CrashOptions struct {
+	Policy CrashPolicy
+
+	// Seed seeds the random choice of the unsynced operations that
+	// survive and of where writes tear, so crashes are reproducible.
+	Seed int64
+
+	// SectorSize is the unit in which writes tear, 512 if zero. A torn
+	// write keeps a prefix of whole sectors.
+	SectorSize int
+}
+
+// CrashFs simulates losing power to the Fs it wraps. It passes all
+// operations on to the base Fs, which holds the live state, and logs them
+// to work out what was made durable:
+//   - the contents and attributes of a file or directory, by syncing
+//     a File opened on it;
+//   - the entries of a directory, that is files and directories created,
+//     removed or renamed in it, by syncing a File opened on the directory.
+//     A rename is only durable once both directories involved are synced.
+//
+// This is the guarantee POSIX gives for fsync(2); many real file systems
+// persist more. Crash then returns the state after a crash, in which the
+// durable operations were applied and the others dropped or torn according
+// to the CrashOptions, much like ALICE or CrashMonkey do for real file
+// systems.
+//
+// Only changes made through the CrashFs are tracked, so the base Fs must not
+// be changed directly while wrapped. Symlinks are not supported.
+type CrashFs struct {
+	mu    sync.Mutex
+	base  Fs
+	paths *pathFuncs // of base
+	// live names the files in base, durable those in the durable state,
+	// which reflects all operations logged before the first unsynced one.
+	live, durableNames *crashNames
+	durable            *MemMapFs
+	lastID             uint64
+	log                []*crashOp
+}
+
+// NewCrashFs wraps base, taking its current contents to be durable. The
+// contents are copied into memory, so base should be small, such as a
+// MemMapFs prepared for a test.
+func NewCrashFs(base Fs) (*CrashFs, error) {
+	paths := pathsOf(base)
+	c := &CrashFs{base: base, paths: paths, live: newCrashNames(paths.separator)}
+	c.durable = c.newMemMapFs()
+	if _, err := Sync(c.durable, paths.separator, base, paths.separator, nil); err != nil {
+		return nil, err
+	}
+	err := Walk(base, paths.separator, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		c.lastID++
+		c.live.add(path, c.lastID)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	c.durableNames = c.live.clone()
+	return c, nil
+}
+
+type crashOpKind int
+
+const (
+	crashCreate crashOpKind = iota
+	crashMkdir
+	crashRemove
+	crashRename
+	crashWrite
+	crashTruncate
+	crashChmod
+	crashChtimes
+)
+
+// crashOp is a logged operation. Creating, removing and renaming change the
+// entries of dir and, for renames, newDir; the other operations change the
+// file id. Files are identified by id rather than name, so that syncs and
+// renames find the right operations.
+type crashOp struct {
+	kind            crashOpKind
+	id              uint64
+	dir, newDir     uint64
+	name, newName   string
+	off, size       int64
+	data            []byte
+	mode            os.FileMode
+	atime, mtime    time.Time
+	synced, newSync bool
+}
+
+func (op *crashOp) isEntryOp() bool {
+	return op.kind <= crashRename
+}
+
+func (op *crashOp) durable() bool {
+	if op.kind == crashRename {
+		return op.synced && op.newSync
+	}
+	return op.synced
+}
+
+// crashNames maps names to file ids and back.
+type crashNames struct {
+	ids       map[string]uint64
+	names     map[uint64]string
+	separator string
+}
+
+func newCrashNames(separator string) *crashNames {
+	return &crashNames{ids: make(map[string]uint64), names: make(map[uint64]string), separator: separator}
+}
+
+func (n *crashNames) clone() *crashNames {
+	c := newCrashNames(n.separator)
+	for name, id := range n.ids {
+		c.add(name, id)
+	}
+	return c
+}
+
+func (n *crashNames) add(name string, id uint64) {
+	n.ids[name] = id
+	n.names[id] = name
+}
+
+// subtree returns name and all names below it.
+func (n *crashNames) subtree(name string) []string {
+	var names []string
+	for sub := range n.ids {
+		if sub == name || strings.HasPrefix(sub, name+n.separator) {
+			names = append(names, sub)
+		}
+	}
+	return names
+}
+
+func (n *crashNames) remove(name string) {
+	for _, sub := range n.subtree(name) {
+		delete(n.names, n.ids[sub])
+		delete(n.ids, sub)
+	}
+}
+
+func (n *crashNames) rename(oldname, newname string) {
+	n.remove(newname)
+	for _, sub := range n.subtree(oldname) {
+		id := n.ids[sub]
+		delete(n.ids, sub)
+		n.add(newname+strings.TrimPrefix(sub, oldname), id)
+	}
+}
+
+// newMemMapFs returns an empty MemMapFs using the same paths as the base.
+func (c *CrashFs) newMemMapFs() *MemMapFs {
+	return &MemMapFs{slash: c.paths == slashPaths}
+}
+
+func (c *CrashFs) newID(name string) uint64 {
+	c.lastID++
+	c.live.add(name, c.lastID)
+	return c.lastID
+}
+
+func (c *CrashFs) record(op *crashOp) {
+	c.log = append(c.log, op)
+}
+
+// recordEntry records an operation on the entry for name in its directory.
+func (c *CrashFs) recordEntry(kind crashOpKind, name string, id uint64, mode os.FileMode) {
+	dir, base := c.paths.split(name)
+	c.record(&crashOp{kind: kind, id: id, dir: c.live.ids[c.paths.clean(dir)], name: base, mode: mode})
+}
+
+// recordFile records an operation on the file with the given id, unless the
+// file was not created through c.
+func (c *CrashFs) recordFile(op *crashOp) {
+	if op.id != 0 {
+		c.record(op)
+	}
+}
+
+func (c *CrashFs) Name() string { return "CrashFs" }
+
+func (c *CrashFs) SlashPaths() bool { return c.paths == slashPaths }
+
+func (c *CrashFs) Create(name string) (File, error) {
+	return c.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (c *CrashFs) Open(name string) (File, error) {
+	return c.OpenFile(name, os.O_RDONLY, 0)
+}
+
+func (c *CrashFs) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	name = c.paths.clean(name)
+
+	f, err := c.base.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	id, ok := c.live.ids[name]
+	switch {
+	case !ok:
+		id = c.newID(name)
+		if fi, err := f.Stat(); err == nil {
+			perm = fi.Mode()
+		}
+		c.recordEntry(crashCreate, name, id, perm)
+	case flag&os.O_TRUNC != 0 && flag&(os.O_WRONLY|os.O_RDWR) != 0:
+		c.recordFile(&crashOp{kind: crashTruncate, id: id})
+	}
+	return &crashFile{File: f, fs: c, id: id, append: flag&os.O_APPEND != 0}, nil
+}
+
+func (c *CrashFs) Mkdir(name string, perm os.FileMode) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	name = c.paths.clean(name)
+
+	if err := c.base.Mkdir(name, perm); err != nil {
+		return err
+	}
+	c.recordEntry(crashMkdir, name, c.newID(name), perm)
+	return nil
+}
+
+func (c *CrashFs) MkdirAll(path string, perm os.FileMode) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	path = c.paths.clean(path)
+
+	var missing []string
+	for dir := path; ; dir = c.paths.dir(dir) {
+		if _, ok := c.live.ids[dir]; ok {
+			break
+		}
+		missing = append(missing, dir)
+		if dir == c.paths.dir(dir) {
+			break
+		}
+	}
+	err := c.base.MkdirAll(path, perm)
+	// record whatever was created, even if MkdirAll failed halfway
+	for i := len(missing) - 1; i >= 0; i-- {
+		if fi, err := c.base.Stat(missing[i]); err == nil && fi.IsDir() {
+			c.recordEntry(crashMkdir, missing[i], c.newID(missing[i]), perm)
+		}
+	}
+	return err
+}
+
+func (c *CrashFs) Remove(name string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	name = c.paths.clean(name)
+
+	if err := c.base.Remove(name); err != nil {
+		return err
+	}
+	c.removed(name)
+	return nil
+}
+
+func (c *CrashFs) RemoveAll(path string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	path = c.paths.clean(path)
+
+	if _, err := c.base.Stat(path); err != nil {
+		return c.base.RemoveAll(path)
+	}
+	if err := c.base.RemoveAll(path); err != nil {
+		return err
+	}
+	c.removed(path)
+	return nil
+}
+
+func (c *CrashFs) removed(name string) {
+	c.recordEntry(crashRemove, name, 0, 0)
+	c.live.remove(name)
+}
+
+func (c *CrashFs) Rename(oldname, newname string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	oldname, newname = c.paths.clean(oldname), c.paths.clean(newname)
+
+	if err := c.base.Rename(oldname, newname); err != nil {
+		return err
+	}
+	if oldname == newname {
+		return nil
+	}
+	oldDir, oldBase := c.paths.split(oldname)
+	newDir, newBase := c.paths.split(newname)
+	c.record(&crashOp{
+		kind:    crashRename,
+		dir:     c.live.ids[c.paths.clean(oldDir)],
+		name:    oldBase,
+		newDir:  c.live.ids[c.paths.clean(newDir)],
+		newName: newBase,
+	})
+	c.live.rename(oldname, newname)
+	return nil
+}
+
+func (c *CrashFs) Stat(name string) (os.FileInfo, error) {
+	return c.base.Stat(name)
+}
+
+func (c *CrashFs) Chmod(name string, mode os.FileMode) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	name = c.paths.clean(name)
+
+	if err := c.base.Chmod(name, mode); err != nil {
+		return err
+	}
+	c.recordFile(&crashOp{kind: crashChmod, id: c.live.ids[name], mode: mode})
+	return nil
+}
+
+func (c *CrashFs) Chtimes(name string, atime, mtime time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	name = c.paths.clean(name)
+
+	if err := c.base.Chtimes(name, atime, mtime); err != nil {
+		return err
+	}
+	c.recordFile(&crashOp{kind: crashChtimes, id: c.live.ids[name], atime: atime, mtime: mtime})
+	return nil
+}
+
+// synced makes the logged changes to the file or directory id durable.
+func (c *CrashFs) synced(id uint64) {
+	for _, op := range c.log {
+		switch {
+		case !op.isEntryOp():
+			op.synced = op.synced || op.id == id
+		case op.kind == crashRename && op.newDir == id:
+			op.newSync = true
+			fallthrough
+		default:
+			op.synced = op.synced || op.dir == id
+		}
+	}
+
+	// fold the durable start of the log into the durable state
+	n := 0
+	for n < len(c.log) && c.log[n].durable() {
+		applyCrashOp(c.durable, c.durableNames, c.log[n], -1)
+		n++
+	}
+	c.log = append(c.log[:0], c.log[n:]...)
+}
+
+// Crash returns the state after a crash at this point, in a new MemMapFs.
+// The CrashFs itself is left unchanged, so Crash can be called repeatedly to
+// try different outcomes. Pass nil to drop all unsynced state.
+func (c *CrashFs) Crash(opts *CrashOptions) (Fs, error) {
+	if opts == nil {
+		opts = &CrashOptions{}
+	}
+	rnd := mathrand.New(mathrand.NewSource(opts.Seed))
+	sectorSize := opts.SectorSize
+	if sectorSize <= 0 {
+		sectorSize = 512
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	fs := c.newMemMapFs()
+	if _, err := Sync(fs, c.paths.separator, c.durable, c.paths.separator, nil); err != nil {
+		return nil, err
+	}
+	names := c.durableNames.clone()
+
+	unsynced := 0
+	for _, op := range c.log {
+		if !op.durable() {
+			unsynced++
+		}
+	}
+	cut := rnd.Intn(unsynced + 1)
+
+	i := 0
+	for _, op := range c.log {
+		keep := -1
+		if !op.durable() {
+			switch opts.Policy {
+			case CrashDropUnsynced:
+				continue
+			case CrashKeepPrefix:
+				if i > cut {
+					continue
+				}
+				if i == cut {
+					if op.kind != crashWrite {
+						continue
+					}
+					keep = tornLength(rnd, len(op.data), sectorSize)
+				}
+			case CrashReorder:
+				if rnd.Intn(2) == 0 {
+					continue
+				}
+				if rnd.Intn(2) == 0 {
+					keep = tornLength(rnd, len(op.data), sectorSize)
+				}
+			}
+			i++
+		}
+		applyCrashOp(fs, names, op, keep)
+	}
+	return fs, nil
+}
+
+// tornLength returns how much of a write of n bytes survives tearing, some
+// number of whole sectors short of n.
+func tornLength(rnd *mathrand.Rand, n, sectorSize int) int {
+	sectors := (n + sectorSize - 1) / sectorSize
+	if sectors == 0 {
+		return 0
+	}
+	return rnd.Intn(sectors) * sectorSize
+}
+
+// applyCrashOp replays op on fs, keeping only keep bytes of a write unless
+// keep is negative. Operations on files whose creation was dropped are
+// skipped, as are those failing because an earlier operation was dropped.
+func applyCrashOp(fs Fs, names *crashNames, op *crashOp, keep int) {
+	entry := func(dir uint64, name string) (string, bool) {
+		dirName, ok := names.names[dir]
+		return pathsOf(fs).join(dirName, name), ok
+	}
+	name, ok := names.names[op.id]
+	if op.isEntryOp() {
+		name, ok = entry(op.dir, op.name)
+	}
+	if !ok {
+		return
+	}
+
+	switch op.kind {
+	case crashCreate:
+		f, err := fs.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, op.mode)
+		if err == nil {
+			f.Close()
+			names.add(name, op.id)
+		}
+	case crashMkdir:
+		if fs.Mkdir(name, op.mode) == nil {
+			names.add(name, op.id)
+		}
+	case crashRemove:
+		if fs.RemoveAll(name) == nil {
+			names.remove(name)
+		}
+	case crashRename:
+		if newname, ok := entry(op.newDir, op.newName); ok && fs.Rename(name, newname) == nil {
+			names.rename(name, newname)
+		}
+	case crashWrite, crashTruncate:
+		f, err := fs.OpenFile(name, os.O_WRONLY, 0)
+		if err != nil {
+			return
+		}
+		defer f.Close()
+		if op.kind == crashTruncate {
+			f.Truncate(op.size)
+		} else if keep < 0 {
+			f.WriteAt(op.data, op.off)
+		} else {
+			f.WriteAt(op.data[:keep], op.off)
+		}
+	case crashChmod:
+		fs.Chmod(name, op.mode)
+	case crashChtimes:
+		fs.Chtimes(name, op.atime, op.mtime)
+	}
+}
+
+type crashFile struct {
+	File
+	fs     *CrashFs
+	id     uint64
+	append bool
+}
+
+func (f *crashFile) Write(p []byte) (int, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+
+	var off int64
+	if f.append {
+		fi, err := f.File.Stat()
+		if err != nil {
+			return 0, err
+		}
+		off = fi.Size()
+	} else {
+		var err error
+		if off, err = f.File.Seek(0, io.SeekCurrent); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.File.Write(p)
+	f.wrote(p[:n], off)
+	return n, err
+}
+
+func (f *crashFile) WriteAt(p []byte, off int64) (int, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+
+	n, err := f.File.WriteAt(p, off)
+	f.wrote(p[:n], off)
+	return n, err
+}
+
+func (f *crashFile) WriteString(s string) (int, error) {
+	return f.Write([]byte(s))
+}
+
+func (f *crashFile) wrote(p []byte, off int64) {
+	if len(p) > 0 {
+		f.fs.recordFile(&crashOp{kind: crashWrite, id: f.id, off: off, data: append([]byte{}, p...)})
+	}
+}
+
+func (f *crashFile) Truncate(size int64) error {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+
+	if err := f.File.Truncate(size); err != nil {
+		return err
+	}
+	f.fs.recordFile(&crashOp{kind: crashTruncate, id: f.id, size: size})
+	return nil
+}
+
+// Sync makes the changes to the file durable, or, for a directory, its
+// entries.
+func (f *crashFile) Sync() error {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+
+	if err := f.File.Sync(); err != nil {
+		return err
+	}
+	f.fs.synced(f.id)
+	return nil
+}
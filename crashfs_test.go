@@ -0,0 +1,169 @@
+package afero
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+func newTestCrashFs(t *testing.T) *CrashFs {
+	base := NewMemMapFs()
+	if err := base.Mkdir("/dir", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteFile(base, "/dir/file", []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewCrashFs(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func syncName(t *testing.T, fs Fs, name string) {
+	t.Helper()
+	f, err := fs.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := f.Sync(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func checkCrashed(t *testing.T, fs Fs, name, expected string) {
+	t.Helper()
+	data, err := ReadFile(fs, name)
+	if expected == "" {
+		if !os.IsNotExist(err) {
+			t.Errorf("expected %s not to survive the crash, got %q, %v", name, data, err)
+		}
+		return
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != expected {
+		t.Errorf("expected %s to contain %q after the crash, got %q", name, expected, data)
+	}
+}
+
+func TestCrashFsDurability(t *testing.T) {
+	c := newTestCrashFs(t)
+
+	f, err := c.Create("/dir/new")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("synced")
+	if err := f.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(" unsynced")
+	f.Close()
+
+	// the contents are durable but the directory entry is not
+	crashed, err := c.Crash(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkCrashed(t, crashed, "/dir/new", "")
+	checkCrashed(t, crashed, "/dir/file", "old")
+
+	syncName(t, c, "/dir")
+	crashed, err = c.Crash(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkCrashed(t, crashed, "/dir/new", "synced")
+	checkCrashed(t, c, "/dir/new", "synced unsynced")
+
+	crashed, err = c.Crash(&CrashOptions{Policy: CrashKeepPrefix})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := ReadFile(crashed, "/dir/new"); !bytes.HasPrefix([]byte("synced unsynced"), data) || len(data) < 6 {
+		t.Errorf("expected a prefix of the contents, got %q", data)
+	}
+}
+
+func TestCrashFsRename(t *testing.T) {
+	c := newTestCrashFs(t)
+	if err := c.Mkdir("/other", 0755); err != nil {
+		t.Fatal(err)
+	}
+	syncName(t, c, "/")
+
+	// the usual atomic replacement, missing the final directory sync
+	if err := WriteFile(c, "/dir/tmp", []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	syncName(t, c, "/dir/tmp")
+	if err := c.Rename("/dir/tmp", "/dir/file"); err != nil {
+		t.Fatal(err)
+	}
+	crashed, err := c.Crash(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkCrashed(t, crashed, "/dir/file", "old")
+
+	syncName(t, c, "/dir")
+	crashed, err = c.Crash(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkCrashed(t, crashed, "/dir/file", "new")
+	checkCrashed(t, crashed, "/dir/tmp", "")
+
+	// a rename between directories needs both synced
+	if err := c.Rename("/dir/file", "/other/file"); err != nil {
+		t.Fatal(err)
+	}
+	syncName(t, c, "/other")
+	crashed, _ = c.Crash(nil)
+	checkCrashed(t, crashed, "/dir/file", "new")
+	syncName(t, c, "/dir")
+	crashed, _ = c.Crash(nil)
+	checkCrashed(t, crashed, "/other/file", "new")
+	checkCrashed(t, crashed, "/dir/file", "")
+}
+
+func TestCrashFsTornWrites(t *testing.T) {
+	c := newTestCrashFs(t)
+	data := bytes.Repeat([]byte("0123456789abcdef"), 256)
+
+	f, err := c.OpenFile("/dir/file", os.O_WRONLY|os.O_TRUNC, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	outcomes := make(map[int]bool)
+	for seed := int64(0); seed < 50; seed++ {
+		opts := &CrashOptions{Policy: CrashReorder, Seed: seed}
+		crashed, err := c.Crash(opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := ReadFile(crashed, "/dir/file")
+		if err != nil {
+			t.Fatal(err)
+		}
+		outcomes[len(got)] = true
+		switch {
+		case string(got) == "old", bytes.Equal(got, data):
+		case len(got)%512 == 0 && bytes.HasPrefix(data, got):
+		default:
+			t.Fatalf("unexpected outcome of %d bytes", len(got))
+		}
+	}
+	if len(outcomes) < 3 {
+		t.Errorf("expected writes to be dropped, kept and torn, got lengths %v", outcomes)
+	}
+}
@@ -19,7 +19,6 @@ import (
 	"bytes"
 	"io"
 	"os"
-	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
@@ -160,13 +159,22 @@ func nextRandom() string {
 	return strconv.Itoa(int(1e9 + r%1e9))[1:]
 }
 
+// tempDir returns the default directory for temporary files on fs.
+func tempDir(fs Fs) string {
+	if UsesSlashPaths(fs) {
+		return "/tmp"
+	}
+	return os.TempDir()
+}
+
 // TempFile creates a new temporary file in the directory dir,
 // opens the file for reading and writing, and returns the resulting *os.File.
 // The filename is generated by taking pattern and adding a random
 // string to the end. If pattern includes a "*", the random string
 // replaces the last "*".
 // If dir is the empty string, TempFile uses the default directory
-// for temporary files (see os.TempDir).
+// for temporary files (see os.TempDir), or /tmp on filesystems using
+// slash paths.
 // Multiple programs calling TempFile simultaneously
 // will not choose the same file. The caller can use f.Name()
 // to find the pathname of the file. It is the caller's responsibility
@@ -177,7 +185,7 @@ func (a Afero) TempFile(dir, pattern string) (f File, err error) {
 
 func TempFile(fs Fs, dir, pattern string) (f File, err error) {
 	if dir == "" {
-		dir = os.TempDir()
+		dir = tempDir(fs)
 	}
 	err = fs.MkdirAll(dir, 0700) // temp dir on some systems is several directories deep, but may not exist in 'fs' yet
 	if err != nil {
@@ -193,7 +201,7 @@ func TempFile(fs Fs, dir, pattern string) (f File, err error) {
 
 	nconflict := 0
 	for i := 0; i < 10000; i++ {
-		name := filepath.Join(dir, prefix+nextRandom()+suffix)
+		name := pathsOf(fs).join(dir, prefix+nextRandom()+suffix)
 		f, err = fs.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
 		if os.IsExist(err) {
 			if nconflict++; nconflict > 10 {
@@ -211,7 +219,8 @@ func TempFile(fs Fs, dir, pattern string) (f File, err error) {
 // TempDir creates a new temporary directory in the directory dir
 // with a name beginning with prefix and returns the path of the
 // new directory.  If dir is the empty string, TempDir uses the
-// default directory for temporary files (see os.TempDir).
+// default directory for temporary files (see os.TempDir), or /tmp on
+// filesystems using slash paths.
 // Multiple programs calling TempDir simultaneously
 // will not choose the same directory.  It is the caller's responsibility
 // to remove the directory when no longer needed.
@@ -220,7 +229,7 @@ func (a Afero) TempDir(dir, prefix string) (name string, err error) {
 }
 func TempDir(fs Fs, dir, prefix string) (name string, err error) {
 	if dir == "" {
-		dir = os.TempDir()
+		dir = tempDir(fs)
 	}
 	err = fs.MkdirAll(dir, 0700) // temp dir on some systems is several directories deep, but may not exist in 'fs' yet
 	if err != nil {
@@ -229,7 +238,7 @@ func TempDir(fs Fs, dir, prefix string) (name string, err error) {
 
 	nconflict := 0
 	for i := 0; i < 10000; i++ {
-		try := filepath.Join(dir, prefix+nextRandom())
+		try := pathsOf(fs).join(dir, prefix+nextRandom())
 		err = fs.Mkdir(try, 0700)
 		if os.IsExist(err) {
 			if nconflict++; nconflict > 10 {
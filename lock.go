@@ -0,0 +1,165 @@
+package afero
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"time"
+)
+
+// LockType selects between shared and exclusive advisory locks.
+type LockType int
+
+const (
+	// LockShared may be held by any number of holders at once, as long
+	// as nobody holds an exclusive lock.
+	LockShared LockType = iota + 1
+	// LockExclusive may only be held by a single holder.
+	LockExclusive
+)
+
+// Unlocker releases a lock obtained from a Locker.
+type Unlocker interface {
+	Unlock() error
+}
+
+// Locker is an optional interface in Afero. It is only implemented by the
+// filesystems saying so.
+// It acquires an advisory lock on the named file, creating the file if it
+// does not exist. If wait is false and the lock is held elsewhere, it fails
+// right away with an os.PathError wrapping ErrLocked; otherwise it blocks
+// until the lock can be acquired.
+// OsFs uses flock(2), or fcntl(2) where flock is not supported, so it
+// coordinates with other processes. MemMapFs locks in-process.
+type Locker interface {
+	LockIfPossible(name string, typ LockType, wait bool) (Unlocker, error)
+}
+
+// ErrLocked is the error wrapped in an os.PathError when a non-blocking
+// lock could not be acquired because it is held elsewhere.
+var ErrLocked = errors.New("file is locked")
+
+// ErrNoLock is the error wrapped in an os.PathError if a file system does
+// not support locking either directly or through its delegated filesystem.
+var ErrNoLock = errors.New("locking not supported")
+
+// lockPollInterval is how often LockFile retries a held lock file.
+const lockPollInterval = 10 * time.Millisecond
+
+// LockFile acquires an advisory lock on name like Locker does. If fs does
+// not support locking, it falls back to a lock file named name+".lock",
+// created with O_EXCL and removed on Unlock. Lock files cannot be shared,
+// so the fallback treats LockShared like LockExclusive, and a lock file
+// left behind by a crashed process has to be removed by hand.
+func LockFile(fs Fs, name string, typ LockType, wait bool) (Unlocker, error) {
+	if locker, ok := fs.(Locker); ok {
+		u, err := locker.LockIfPossible(name, typ, wait)
+		if pathErr, ok := err.(*os.PathError); !ok || pathErr.Err != ErrNoLock {
+			return u, err
+		}
+	}
+	return lockWithFile(fs, name, wait)
+}
+
+func lockWithFile(fs Fs, name string, wait bool) (Unlocker, error) {
+	lockName := name + ".lock"
+	for {
+		f, err := fs.OpenFile(lockName, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+		if err == nil {
+			f.Close()
+			return &lockFile{fs: fs, name: lockName}, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+		if !wait {
+			return nil, &os.PathError{Op: "lock", Path: name, Err: ErrLocked}
+		}
+		time.Sleep(lockPollInterval)
+	}
+}
+
+type lockFile struct {
+	fs   Fs
+	name string
+}
+
+func (l *lockFile) Unlock() error {
+	return l.fs.Remove(l.name)
+}
+
+// lockTable keeps in-process advisory locks, keyed by any comparable value
+// identifying a file.
+type lockTable struct {
+	mu    sync.Mutex
+	cond  *sync.Cond
+	locks map[interface{}]*lockState
+}
+
+type lockState struct {
+	shared    int
+	exclusive bool
+}
+
+// lock acquires a lock on key and reports whether it succeeded, which can
+// only fail if wait is false.
+func (t *lockTable) lock(key interface{}, typ LockType, wait bool) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.locks == nil {
+		t.locks = make(map[interface{}]*lockState)
+		t.cond = sync.NewCond(&t.mu)
+	}
+	for {
+		s := t.locks[key]
+		if s == nil {
+			s = &lockState{}
+			t.locks[key] = s
+		}
+		if typ == LockExclusive && !s.exclusive && s.shared == 0 {
+			s.exclusive = true
+			return true
+		}
+		if typ == LockShared && !s.exclusive {
+			s.shared++
+			return true
+		}
+		if !wait {
+			return false
+		}
+		t.cond.Wait()
+	}
+}
+
+func (t *lockTable) unlock(key interface{}, typ LockType) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s := t.locks[key]
+	if typ == LockExclusive {
+		s.exclusive = false
+	} else {
+		s.shared--
+	}
+	if !s.exclusive && s.shared == 0 {
+		delete(t.locks, key)
+	}
+	t.cond.Broadcast()
+}
+
+// tableLock is the Unlocker of a lockTable entry.
+type tableLock struct {
+	table *lockTable
+	key   interface{}
+	typ   LockType
+	name  string
+	once  sync.Once
+}
+
+func (l *tableLock) Unlock() error {
+	err := error(&os.PathError{Op: "unlock", Path: l.name, Err: os.ErrClosed})
+	l.once.Do(func() {
+		l.table.unlock(l.key, l.typ)
+		err = nil
+	})
+	return err
+}
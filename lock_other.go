@@ -0,0 +1,11 @@
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package afero
+
+import (
+	"os"
+)
+
+func lockOsFile(name string, typ LockType, wait bool) (Unlocker, error) {
+	return nil, &os.PathError{Op: "lock", Path: name, Err: ErrNoLock}
+}
@@ -0,0 +1,121 @@
+package afero
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func checkLocked(t *testing.T, err error) {
+	t.Helper()
+	if pathErr, ok := err.(*os.PathError); !ok || pathErr.Err != ErrLocked {
+		t.Errorf("expected ErrLocked, got %v", err)
+	}
+}
+
+// testLocking checks lock contention on name; lock files cannot be shared,
+// so shared locks are only checked for compatibility if shared is true.
+func testLocking(t *testing.T, fs Fs, name string, shared bool) {
+	excl, err := LockFile(fs, name, LockExclusive, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = LockFile(fs, name, LockExclusive, false)
+	checkLocked(t, err)
+	_, err = LockFile(fs, name, LockShared, false)
+	checkLocked(t, err)
+
+	acquired := make(chan Unlocker)
+	go func() {
+		u, err := LockFile(fs, name, LockShared, true)
+		if err != nil {
+			t.Error(err)
+		}
+		acquired <- u
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("blocking lock acquired while an exclusive lock is held")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if err := excl.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	shared1 := <-acquired
+	if shared {
+		shared2, err := LockFile(fs, name, LockShared, false)
+		if err != nil {
+			t.Fatalf("expected shared locks to be compatible, got %v", err)
+		}
+		_, err = LockFile(fs, name, LockExclusive, false)
+		checkLocked(t, err)
+		shared2.Unlock()
+	}
+	_, err = LockFile(fs, name, LockExclusive, false)
+	checkLocked(t, err)
+	shared1.Unlock()
+
+	excl, err = LockFile(fs, name, LockExclusive, false)
+	if err != nil {
+		t.Fatalf("expected lock to be free, got %v", err)
+	}
+	excl.Unlock()
+}
+
+func TestMemMapFsLocking(t *testing.T) {
+	testLocking(t, NewMemMapFs(), "/lock", true)
+}
+
+func TestOsFsLocking(t *testing.T) {
+	osfs := NewOsFs()
+	u, err := osfs.(Locker).LockIfPossible(os.DevNull, LockShared, false)
+	if pathErr, ok := err.(*os.PathError); ok && pathErr.Err == ErrNoLock {
+		t.Skip("locking not supported on this platform")
+	}
+	if err == nil {
+		u.Unlock()
+	}
+	dir, err := TempDir(osfs, "", "afero-lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer osfs.RemoveAll(dir)
+	testLocking(t, osfs, filepath.Join(dir, "lock"), true)
+}
+
+func TestBasePathFsLocking(t *testing.T) {
+	fs := NewMemMapFs()
+	bp := NewBasePathFs(fs, "/base")
+	fs.MkdirAll("/base/dir", 0755)
+
+	u, err := LockFile(bp, "/dir/lock", LockExclusive, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer u.Unlock()
+	_, err = LockFile(fs, "/base/dir/lock", LockExclusive, false)
+	checkLocked(t, err)
+}
+
+func TestLockFileFallback(t *testing.T) {
+	// embedding only the Fs interface hides MemMapFs's LockIfPossible
+	fs := struct{ Fs }{NewMemMapFs()}
+	testLocking(t, fs, "/lock", false)
+	if ok, _ := Exists(fs, "/lock.lock"); ok {
+		t.Error("lock file was not removed")
+	}
+}
+
+func TestReadOnlyFsLocking(t *testing.T) {
+	fs := NewMemMapFs()
+	ro := NewReadOnlyFs(fs)
+	if _, err := ro.(Locker).LockIfPossible("/missing", LockShared, false); !os.IsNotExist(err) {
+		t.Errorf("expected ErrNotExist locking a missing file, got %v", err)
+	}
+	if ok, _ := Exists(fs, "/missing"); ok {
+		t.Error("locking through a ReadOnlyFs created the file")
+	}
+	WriteFile(fs, "/lock", nil, 0644)
+	testLocking(t, ro, "/lock", true)
+}
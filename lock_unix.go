@@ -0,0 +1,125 @@
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+package afero
+
+import (
+	"io"
+	"os"
+	"syscall"
+)
+
+func lockOsFile(name string, typ LockType, wait bool) (Unlocker, error) {
+	f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		// directories and read-only files can still be locked,
+		// at least for reading
+		var err1 error
+		if f, err1 = os.Open(name); err1 != nil {
+			return nil, err
+		}
+	}
+
+	how := syscall.LOCK_SH
+	if typ == LockExclusive {
+		how = syscall.LOCK_EX
+	}
+	if !wait {
+		how |= syscall.LOCK_NB
+	}
+	err = retryEINTR(func() error { return syscall.Flock(int(f.Fd()), how) })
+	if err == syscall.ENOTSUP || err == syscall.EOPNOTSUPP || err == syscall.ENOLCK {
+		// some network filesystems only support POSIX record locks
+		var release func()
+		if release, err = fcntlLock(f, typ, wait); err == nil {
+			return &osLock{f: f, unlock: func() error { return fcntlUnlock(f) }, release: release}, nil
+		}
+	}
+	if err != nil {
+		f.Close()
+		if err == syscall.EWOULDBLOCK || err == syscall.EAGAIN || err == syscall.EACCES {
+			err = ErrLocked
+		}
+		return nil, &os.PathError{Op: "lock", Path: name, Err: err}
+	}
+	return &osLock{f: f, unlock: func() error {
+		return syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+	}}, nil
+}
+
+// fcntlLocks serializes the fcntl(2) locks of this process. Those belong to
+// the process rather than to an open file, so they do not exclude other
+// goroutines, and closing any descriptor of a file drops all of them. Each
+// file is therefore locked by one holder at a time within the process, even
+// for shared locks, which still exclude writers in other processes.
+var fcntlLocks lockTable
+
+// fileKey identifies a file by its device and inode.
+type fileKey struct {
+	dev, ino uint64
+}
+
+// fcntlLock locks f with fcntl(2) and returns the function releasing its
+// place in fcntlLocks, to be called once f is closed.
+func fcntlLock(f *os.File, typ LockType, wait bool) (func(), error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil, ErrNoLock
+	}
+	key := fileKey{dev: uint64(st.Dev), ino: uint64(st.Ino)}
+	if !fcntlLocks.lock(key, LockExclusive, wait) {
+		return nil, ErrLocked
+	}
+
+	lk := syscall.Flock_t{Type: syscall.F_RDLCK, Whence: io.SeekStart}
+	if typ == LockExclusive {
+		lk.Type = syscall.F_WRLCK
+	}
+	cmd := syscall.F_SETLK
+	if wait {
+		cmd = syscall.F_SETLKW
+	}
+	if err := retryEINTR(func() error { return syscall.FcntlFlock(f.Fd(), cmd, &lk) }); err != nil {
+		fcntlLocks.unlock(key, LockExclusive)
+		return nil, err
+	}
+	return func() { fcntlLocks.unlock(key, LockExclusive) }, nil
+}
+
+func fcntlUnlock(f *os.File) error {
+	lk := syscall.Flock_t{Type: syscall.F_UNLCK, Whence: io.SeekStart}
+	return syscall.FcntlFlock(f.Fd(), syscall.F_SETLK, &lk)
+}
+
+func retryEINTR(fn func() error) error {
+	for {
+		err := fn()
+		if err != syscall.EINTR {
+			return err
+		}
+	}
+}
+
+type osLock struct {
+	f       *os.File
+	unlock  func() error
+	release func() // if not nil, called after closing f
+}
+
+func (l *osLock) Unlock() error {
+	err := l.unlock()
+	if err1 := l.f.Close(); err == nil {
+		err = err1
+	}
+	if l.release != nil {
+		l.release()
+		l.release = nil
+	}
+	if err != nil {
+		return &os.PathError{Op: "unlock", Path: l.f.Name(), Err: err}
+	}
+	return nil
+}
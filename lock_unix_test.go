@@ -0,0 +1,57 @@
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+package afero
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFcntlLockingInProcess(t *testing.T) {
+	dir, err := TempDir(NewOsFs(), "", "afero-fcntl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "lock")
+	open := func() *os.File {
+		f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE, 0666)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return f
+	}
+
+	f1, f2 := open(), open()
+	release, err := fcntlLock(f1, LockExclusive, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fcntlLock(f2, LockShared, false); err != ErrLocked {
+		t.Errorf("expected a second descriptor in the same process to be locked out, got %v", err)
+	}
+
+	l1 := &osLock{f: f1, unlock: func() error { return fcntlUnlock(f1) }, release: release}
+	acquired := make(chan func())
+	go func() {
+		release, err := fcntlLock(f2, LockExclusive, true)
+		if err != nil {
+			t.Error(err)
+		}
+		acquired <- release
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("blocking lock acquired while another goroutine holds it")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if err := l1.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	l2 := &osLock{f: f2, unlock: func() error { return fcntlUnlock(f2) }, release: <-acquired}
+	if err := l2.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -15,7 +15,6 @@
 package afero
 
 import (
-	"path/filepath"
 	"sort"
 	"strings"
 )
@@ -25,6 +24,9 @@ import (
 // as in Match. The pattern may describe hierarchical names such as
 // /usr/*/bin/ed (assuming the Separator is '/').
 //
+// On filesystems using slash paths, patterns and matches are slash paths,
+// with the syntax of path.Match.
+//
 // Glob ignores file system errors such as I/O errors reading directories.
 // The only possible returned error is ErrBadPattern, when pattern
 // is malformed.
@@ -40,11 +42,12 @@ func Glob(fs Fs, pattern string) (matches []string, err error) {
 		return []string{pattern}, nil
 	}
 
-	dir, file := filepath.Split(pattern)
+	p := pathsOf(fs)
+	dir, file := p.split(pattern)
 	switch dir {
 	case "":
 		dir = "."
-	case string(filepath.Separator):
+	case p.separator:
 	// nothing
 	default:
 		dir = dir[0 : len(dir)-1] // chop off trailing separator
@@ -90,13 +93,14 @@ func glob(fs Fs, dir, pattern string, matches []string) (m []string, e error) {
 	names, _ := d.Readdirnames(-1)
 	sort.Strings(names)
 
+	p := pathsOf(fs)
 	for _, n := range names {
-		matched, err := filepath.Match(pattern, n)
+		matched, err := p.match(pattern, n)
 		if err != nil {
 			return m, err
 		}
 		if matched {
-			m = append(m, filepath.Join(dir, n))
+			m = append(m, p.join(dir, n))
 		}
 	}
 	return
@@ -0,0 +1,8 @@
+// +build !plan9
+
+package mem
+
+import "syscall"
+
+// ENXIO is returned when seeking to data or a hole past the end of a file.
+const ENXIO = syscall.ENXIO
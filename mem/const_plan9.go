@@ -0,0 +1,7 @@
+package mem
+
+import "errors"
+
+// ENXIO is returned when seeking to data or a hole past the end of a file.
+// Plan 9 has no errno values, so it is an error of its own there.
+var ENXIO = errors.New("no such device or address")
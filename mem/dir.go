@@ -26,12 +26,76 @@ type Dir interface {
 	Remove(*FileData)
 }
 
+// RemoveFromMemDir removes the entry of f from dir, dropping the link from
+// dir to f and, if f is a directory, the link from f's ".." entry to dir.
+// The caller must hold the lock of dir.
 func RemoveFromMemDir(dir *FileData, f *FileData) {
+	f.Lock()
+	removeFromMemDir(dir, f)
+	f.Unlock()
+}
+
+// RemoveEmptyFromMemDir is like RemoveFromMemDir, but leaves a directory f
+// that has entries in place. Checking and removing f happen under the lock
+// of f, so no entry can be added to f in between. It reports whether f was
+// removed. The caller must hold the lock of dir.
+func RemoveEmptyFromMemDir(dir *FileData, f *FileData) bool {
+	f.Lock()
+	defer f.Unlock()
+	if f.dir && f.memDir.Len() > 0 {
+		return false
+	}
+	removeFromMemDir(dir, f)
+	return true
+}
+
+func removeFromMemDir(dir *FileData, f *FileData) {
 	dir.memDir.Remove(f)
+	f.nlink--
+	f.unlinked = true
+	changed(f)
+	if f.dir {
+		dir.nlink--
+	}
 }
 
+// AddToMemDir adds an entry for f to dir, maintaining link counts like
+// RemoveFromMemDir. The caller must hold the lock of dir.
 func AddToMemDir(dir *FileData, f *FileData) {
 	dir.memDir.Add(f)
+	f.Lock()
+	f.nlink++
+	f.unlinked = false
+	changed(f)
+	f.Unlock()
+	if f.dir {
+		dir.nlink++
+	}
+}
+
+// Unlinked reports whether f has been removed from its directory and not
+// added to one since. No entries should be added to an unlinked directory,
+// as they could not be reached. The caller must hold the lock of f.
+func Unlinked(f *FileData) bool {
+	return f.unlinked
+}
+
+// FindInDir returns the entry of dir with the given base name, if any. The
+// caller must hold the lock of dir.
+func FindInDir(dir *FileData, name string) (*FileData, bool) {
+	switch d := dir.memDir.(type) {
+	case nil:
+		return nil, false
+	case *dirIndex:
+		f, ok := d.entries[name]
+		return f, ok
+	}
+	for _, f := range dir.memDir.Files() {
+		if f.baseName() == name {
+			return f, true
+		}
+	}
+	return nil, false
 }
 
 func ReadMemDir(dir *FileData) ([]os.FileInfo, error) {
@@ -51,6 +115,6 @@ func ReadMemDir(dir *FileData) ([]os.FileInfo, error) {
 func InitializeDir(d *FileData) {
 	if d.memDir == nil {
 		d.dir = true
-		d.memDir = &DirMap{}
+		d.memDir = newDirIndex()
 	}
 }
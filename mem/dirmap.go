@@ -13,7 +13,9 @@
 
 package mem
 
-import "sort"
+import (
+	"sort"
+)
 
 type DirMap map[string]*FileData
 
@@ -41,3 +43,50 @@ func (m DirMap) Names() (names []string) {
 	}
 	return names
 }
+
+// dirIndex is the Dir of the directories created by CreateDir. It keeps its
+// entries by base name, so entries stay valid when the directory is renamed,
+// and remembers the sorted list of its files until an entry is added or
+// removed, so reading a large directory repeatedly does not sort it again.
+type dirIndex struct {
+	entries map[string]*FileData
+	sorted  []*FileData
+}
+
+func newDirIndex() *dirIndex {
+	return &dirIndex{entries: make(map[string]*FileData)}
+}
+
+func (d *dirIndex) Len() int { return len(d.entries) }
+
+func (d *dirIndex) Add(f *FileData) {
+	d.entries[f.baseName()] = f
+	d.sorted = nil
+}
+
+func (d *dirIndex) Remove(f *FileData) {
+	delete(d.entries, f.baseName())
+	d.sorted = nil
+}
+
+// Files returns the files sorted by name. The result is shared and must not
+// be modified.
+func (d *dirIndex) Files() []*FileData {
+	if d.sorted == nil && len(d.entries) > 0 {
+		files := make([]*FileData, 0, len(d.entries))
+		for _, f := range d.entries {
+			files = append(files, f)
+		}
+		sort.Sort(filesSorter(files))
+		d.sorted = files
+	}
+	return d.sorted
+}
+
+func (d *dirIndex) Names() []string {
+	names := make([]string, 0, len(d.entries))
+	for _, f := range d.entries {
+		names = append(names, f.name)
+	}
+	return names
+}
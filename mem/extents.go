@@ -0,0 +1,253 @@
+package mem
+
+import "sort"
+
+// extent is a run of file contents starting at off. Its data is shared with
+// a clone of the file or held in blob if shared is set, and must then be
+// copied to change it.
+type extent struct {
+	off    int64
+	data   []byte
+	shared bool
+	blob   *blob
+}
+
+func (e *extent) end() int64 {
+	return e.off + int64(len(e.data))
+}
+
+// chunkSize bounds the length of an extent, so that writing, reading and
+// truncating only ever copy within one chunk, however large the file.
+const chunkSize = 64 << 10
+
+// extents holds the contents of a file of the given size as a sorted list of
+// extents that do not overlap, each within one chunk of chunkSize bytes.
+// Extents only touch at chunk boundaries. The ranges between them, and after
+// the last one, are holes that read as zeros and take no memory.
+type extents struct {
+	size int64
+	list []extent
+}
+
+func newExtents(data []byte) extents {
+	e := extents{size: int64(len(data))}
+	if len(data) > 0 {
+		e.list = []extent{{data: data}}
+	}
+	return e
+}
+
+// find returns the index of the first extent ending at or after off.
+func (e *extents) find(off int64) int {
+	return sort.Search(len(e.list), func(i int) bool { return e.list[i].end() >= off })
+}
+
+// readAt copies the contents at off into p, up to the end of the file, and
+// returns the number of bytes copied.
+func (e *extents) readAt(p []byte, off int64) int {
+	if off >= e.size {
+		return 0
+	}
+	if rest := e.size - off; int64(len(p)) > rest {
+		p = p[:rest]
+	}
+	end := off + int64(len(p))
+	pos := off
+	for i := e.find(off); i < len(e.list) && e.list[i].off < end; i++ {
+		x := &e.list[i]
+		if x.off > pos {
+			zero(p[pos-off : x.off-off])
+			pos = x.off
+		}
+		pos += int64(copy(p[pos-off:], x.data[pos-x.off:]))
+	}
+	zero(p[pos-off:])
+	return len(p)
+}
+
+func zero(p []byte) {
+	for i := range p {
+		p[i] = 0
+	}
+}
+
+// writeAt writes p at off, growing the file if needed.
+func (e *extents) writeAt(p []byte, off int64) {
+	if end := off + int64(len(p)); end > e.size {
+		e.size = end
+	}
+	for len(p) > 0 {
+		n := chunkSize - off%chunkSize
+		if n > int64(len(p)) {
+			n = int64(len(p))
+		}
+		e.writeChunk(p[:n], off)
+		p, off = p[n:], off+n
+	}
+}
+
+// writeChunk writes p, which lies within one chunk, at off. The extents in
+// the chunk that p overlaps or touches are merged with it into one.
+func (e *extents) writeChunk(p []byte, off int64) {
+	end := off + int64(len(p))
+	first := e.find(off)
+	if first < len(e.list) && e.list[first].end() == off && off%chunkSize == 0 {
+		// ends the previous chunk
+		first++
+	}
+	last := first
+	for last < len(e.list) && (e.list[last].off < end || e.list[last].off == end && end%chunkSize != 0) {
+		last++
+	}
+	if first == last {
+		// all in a hole
+		x := extent{off: off, data: append([]byte{}, p...)}
+		e.list = append(e.list, extent{})
+		copy(e.list[first+1:], e.list[first:])
+		e.list[first] = x
+		return
+	}
+
+	f, l := e.list[first], e.list[last-1]
+	if f.off <= off && end <= f.end() && !f.shared {
+		copy(f.data[off-f.off:], p)
+		return
+	}
+	start, stop := off, end
+	if f.off < start {
+		start = f.off
+	}
+	if l.end() > stop {
+		stop = l.end()
+	}
+	data := f.data
+	if f.off != start || int64(cap(data)) < stop-start || f.shared {
+		// grow geometrically, but not past the end of the chunk
+		size := 2 * int64(cap(data))
+		if size < stop-start {
+			size = stop - start
+		}
+		if limit := chunkSize - start%chunkSize; size > limit {
+			size = limit
+		}
+		data = make([]byte, 0, size)
+		if f.off < off {
+			data = append(data, f.data[:off-f.off]...)
+		}
+	} else {
+		data = data[:off-f.off]
+	}
+	data = append(data, p...)
+	if l.end() > end {
+		data = append(data, l.data[end-l.off:]...)
+	}
+	e.releaseRange(first, last)
+	e.list[first] = extent{off: start, data: data}
+	e.list = append(e.list[:first+1], e.list[last:]...)
+}
+
+// clone returns a copy of e sharing its data. Both e and the copy copy an
+// extent before writing to it, so each chunk is copied at most once by each.
+func (e *extents) clone() extents {
+	for i := range e.list {
+		e.list[i].shared = true
+		if b := e.list[i].blob; b != nil {
+			b.retain()
+		}
+	}
+	return extents{size: e.size, list: append([]extent(nil), e.list...)}
+}
+
+// release drops the references of e to blobs, when e is no longer used.
+func (e *extents) release() {
+	e.releaseRange(0, len(e.list))
+}
+
+// releaseRange drops the references to blobs of the extents in [i, j),
+// which are being replaced or removed.
+func (e *extents) releaseRange(i, j int) {
+	for ; i < j; i++ {
+		if b := e.list[i].blob; b != nil {
+			b.release()
+			e.list[i].blob = nil
+		}
+	}
+}
+
+// truncate changes the size of the file, dropping the contents beyond size
+// or adding a hole up to it.
+func (e *extents) truncate(size int64) {
+	e.size = size
+	i := e.find(size)
+	if i < len(e.list) && e.list[i].off < size {
+		e.list[i].data = e.list[i].data[:size-e.list[i].off]
+		i++
+	}
+	e.releaseRange(i, len(e.list))
+	e.list = e.list[:i]
+}
+
+// allocate fills the holes in [off, off+length) with zeros, growing the file
+// if needed, so the range takes memory like it would take disk space.
+func (e *extents) allocate(off, length int64) {
+	end := off + length
+	if end > e.size {
+		e.size = end
+	}
+	for pos := off; pos < end; {
+		hole := e.seekHole(pos)
+		if hole < 0 || hole >= end {
+			break
+		}
+		data := e.seekData(hole)
+		if data < 0 || data > end {
+			data = end
+		}
+		e.writeAt(make([]byte, data-hole), hole)
+		pos = data
+	}
+}
+
+// seekData returns the start of the first data at or after off, or -1 if
+// there is none.
+func (e *extents) seekData(off int64) int64 {
+	if off >= e.size {
+		return -1
+	}
+	for i := e.find(off); i < len(e.list); i++ {
+		x := &e.list[i]
+		if x.end() > off {
+			if x.off > off {
+				return x.off
+			}
+			return off
+		}
+	}
+	return -1
+}
+
+// seekHole returns the start of the first hole at or after off, counting the
+// end of the file as one, or -1 if off is past the end.
+func (e *extents) seekHole(off int64) int64 {
+	if off >= e.size {
+		return -1
+	}
+	i := sort.Search(len(e.list), func(i int) bool { return e.list[i].end() > off })
+	if i == len(e.list) || e.list[i].off > off {
+		return off
+	}
+	// skip extents continuing into the next chunk
+	for i+1 < len(e.list) && e.list[i+1].off == e.list[i].end() {
+		i++
+	}
+	return e.list[i].end()
+}
+
+// allocated returns the number of bytes taken by data.
+func (e *extents) allocated() int64 {
+	var n int64
+	for i := range e.list {
+		n += int64(len(e.list[i].data))
+	}
+	return n
+}
@@ -15,11 +15,12 @@
 package mem
 
 import (
-	"bytes"
 	"errors"
 	"io"
 	"os"
+	"path"
 	"path/filepath"
+	"sort"
 	"sync"
 	"sync/atomic"
 	"syscall"
@@ -28,21 +29,34 @@ import (
 
 const FilePathSeparator = string(filepath.Separator)
 
+// File is an open handle on a FileData. Like a file descriptor refers to an
+// inode, it refers to the FileData itself rather than to its name: once the
+// FileData is renamed, removed or replaced by a rename, the handle keeps
+// reading and writing the same data, and keeps the name it was opened with.
 type File struct {
 	// atomic requires 64-bit alignment for struct field access
 	at           int64
 	readDirCount int64
 	closed       bool
 	readOnly     bool
+	append       bool
+	name         string
 	fileData     *FileData
 }
 
 func NewFileHandle(data *FileData) *File {
-	return &File{fileData: data}
+	return &File{fileData: data, name: data.Name()}
 }
 
 func NewReadOnlyFileHandle(data *FileData) *File {
-	return &File{fileData: data, readOnly: true}
+	return &File{fileData: data, name: data.Name(), readOnly: true}
+}
+
+// SetAppend makes every Write on f append to the end of the file, like
+// O_APPEND: the end is found and written to under the lock of the FileData,
+// so writes through different handles never overwrite each other.
+func (f *File) SetAppend() {
+	f.append = true
 }
 
 func (f File) Data() *FileData {
@@ -52,11 +66,76 @@ func (f File) Data() *FileData {
 type FileData struct {
 	sync.Mutex
 	name    string
-	data    []byte
+	data    extents
 	memDir  Dir
 	dir     bool
 	mode    os.FileMode
 	modtime time.Time
+	atime   time.Time
+	ctime   time.Time
+	btime   time.Time
+	xattrs  map[string][]byte
+	dev     uint64
+	ino     uint64
+	nlink   uint64
+	// unlinked is set when the file is removed from its directory.
+	unlinked bool
+	// slashPaths is set when name is a slash path.
+	slashPaths bool
+
+	atimePolicy AtimePolicy
+	clock       Clock
+	pool        *BlobPool
+	// holdsBlobs is set once a finalizer releases the blobs held.
+	holdsBlobs bool
+}
+
+// Clock tells a FileData the time, for timestamps.
+type Clock interface {
+	Now() time.Time
+}
+
+// now returns the time according to the clock of f, or the system clock
+// if it has none.
+func (f *FileData) now() time.Time {
+	if f.clock == nil {
+		return time.Now()
+	}
+	return f.clock.Now()
+}
+
+// AtimePolicy selects when reading a file updates its access time, like
+// the noatime, relatime and strictatime mount options.
+type AtimePolicy int
+
+const (
+	// NoAtime never updates the access time on reads.
+	NoAtime AtimePolicy = iota
+	// RelAtime updates the access time on reads if it is not newer than
+	// the modification or change time, or more than a day old.
+	RelAtime
+	// StrictAtime updates the access time on every read.
+	StrictAtime
+)
+
+// lastIno is the inode number most recently handed out. Inode numbers are
+// unique across all FileData, so they stay unique when a FileData is shared
+// between file systems.
+var lastIno uint64
+
+func nextIno() uint64 {
+	return atomic.AddUint64(&lastIno, 1)
+}
+
+// baseName returns the last element of the name of f, which is empty for the
+// root.
+func (f *FileData) baseName() string {
+	if f.slashPaths {
+		_, name := path.Split(f.name)
+		return name
+	}
+	_, name := filepath.Split(f.name)
+	return name
 }
 
 func (d *FileData) Name() string {
@@ -65,34 +144,197 @@ func (d *FileData) Name() string {
 	return d.name
 }
 
+// Size returns the size of the file, including holes.
+func (d *FileData) Size() int64 {
+	d.Lock()
+	defer d.Unlock()
+	return d.data.size
+}
+
 func CreateFile(name string) *FileData {
-	return &FileData{name: name, mode: os.ModeTemporary, modtime: time.Now()}
+	return CreateFileWithClock(name, nil)
+}
+
+// CreateFileWithClock creates a file that takes its timestamps from clock.
+// A nil clock means the system clock.
+func CreateFileWithClock(name string, clock Clock) *FileData {
+	f := &FileData{name: name, mode: os.ModeTemporary, ino: nextIno(), clock: clock}
+	f.modtime = f.now()
+	f.atime, f.ctime, f.btime = f.modtime, f.modtime, f.modtime
+	return f
 }
 
+// CreateDir creates a directory with a link count of one, for its "." entry.
+// Adding it to a parent directory adds the link from the parent.
 func CreateDir(name string) *FileData {
-	return &FileData{name: name, memDir: &DirMap{}, dir: true, modtime: time.Now()}
+	return CreateDirWithClock(name, nil)
+}
+
+// CreateDirWithClock is like CreateDir, taking timestamps from clock.
+func CreateDirWithClock(name string, clock Clock) *FileData {
+	d := CreateFileWithClock(name, clock)
+	d.mode = 0
+	d.memDir = newDirIndex()
+	d.dir = true
+	d.nlink = 1
+	return d
+}
+
+// SetClock sets the clock f takes its timestamps from. A nil clock means the
+// system clock.
+func SetClock(f *FileData, clock Clock) {
+	f.Lock()
+	f.clock = clock
+	f.Unlock()
+}
+
+// SetAtimePolicy sets when reading f updates its access time.
+func SetAtimePolicy(f *FileData, policy AtimePolicy) {
+	f.Lock()
+	f.atimePolicy = policy
+	f.Unlock()
+}
+
+// SetDev sets the device number reported for f, which identifies the file
+// system it belongs to.
+func SetDev(f *FileData, dev uint64) {
+	f.Lock()
+	f.dev = dev
+	f.Unlock()
+}
+
+// SetSlashPaths makes f take its name as a path separated by forward slashes
+// only, whatever the OS.
+func SetSlashPaths(f *FileData) {
+	f.Lock()
+	f.slashPaths = true
+	f.Unlock()
+}
+
+// AddLink adds n, which may be negative, to the link count of f.
+func AddLink(f *FileData, n int) {
+	f.Lock()
+	f.nlink += uint64(n)
+	changed(f)
+	f.Unlock()
 }
 
 func ChangeFileName(f *FileData, newname string) {
 	f.Lock()
 	f.name = newname
+	changed(f)
 	f.Unlock()
 }
 
 func SetMode(f *FileData, mode os.FileMode) {
 	f.Lock()
 	f.mode = mode
+	changed(f)
 	f.Unlock()
 }
 
 func SetModTime(f *FileData, mtime time.Time) {
 	f.Lock()
-	setModTime(f, mtime)
+	f.modtime = mtime
+	changed(f)
+	f.Unlock()
+}
+
+// SetTimes sets the access and modification times of f, like Chtimes.
+func SetTimes(f *FileData, atime, mtime time.Time) {
+	f.Lock()
+	f.atime = atime
+	f.modtime = mtime
+	changed(f)
 	f.Unlock()
 }
 
+// CloneData replaces the contents of dst with those of src. The two share
+// memory until either is written to, when the chunks written are copied.
+func CloneData(dst, src *FileData) {
+	src.Lock()
+	data := src.data.clone()
+	src.Unlock()
+	dst.Lock()
+	dst.data.release()
+	dst.data = data
+	setModTime(dst, dst.now())
+	holdBlobs(dst)
+	dst.Unlock()
+}
+
+// setModTime records a change of the contents of f at mtime.
 func setModTime(f *FileData, mtime time.Time) {
 	f.modtime = mtime
+	f.ctime = mtime
+}
+
+// changed records a change of the metadata of f.
+func changed(f *FileData) {
+	f.ctime = f.now()
+}
+
+// accessed records a read of f according to its AtimePolicy.
+func accessed(f *FileData) {
+	switch f.atimePolicy {
+	case NoAtime:
+		return
+	case RelAtime:
+		now := f.now()
+		if f.atime.After(f.modtime) && f.atime.After(f.ctime) && now.Sub(f.atime) < 24*time.Hour {
+			return
+		}
+		f.atime = now
+	default:
+		f.atime = f.now()
+	}
+}
+
+// GetXAttr returns a copy of the value of the extended attribute attr and
+// whether it is set.
+func GetXAttr(f *FileData, attr string) ([]byte, bool) {
+	f.Lock()
+	defer f.Unlock()
+	value, ok := f.xattrs[attr]
+	if !ok {
+		return nil, false
+	}
+	return append([]byte{}, value...), true
+}
+
+func SetXAttr(f *FileData, attr string, value []byte) {
+	f.Lock()
+	if f.xattrs == nil {
+		f.xattrs = make(map[string][]byte)
+	}
+	f.xattrs[attr] = append([]byte{}, value...)
+	changed(f)
+	f.Unlock()
+}
+
+// RemoveXAttr removes the extended attribute attr and reports whether it
+// was set.
+func RemoveXAttr(f *FileData, attr string) bool {
+	f.Lock()
+	defer f.Unlock()
+	_, ok := f.xattrs[attr]
+	if ok {
+		delete(f.xattrs, attr)
+		changed(f)
+	}
+	return ok
+}
+
+// ListXAttrs returns the names of all extended attributes, sorted.
+func ListXAttrs(f *FileData) []string {
+	f.Lock()
+	names := make([]string, 0, len(f.xattrs))
+	for name := range f.xattrs {
+		names = append(names, name)
+	}
+	f.Unlock()
+	sort.Strings(names)
+	return names
 }
 
 func GetFileInfo(f *FileData) *FileInfo {
@@ -115,21 +357,29 @@ func (f *File) Close() error {
 	f.fileData.Lock()
 	f.closed = true
 	if !f.readOnly {
-		setModTime(f.fileData, time.Now())
+		setModTime(f.fileData, f.fileData.now())
 	}
 	f.fileData.Unlock()
+	if !f.readOnly {
+		intern(f.fileData)
+	}
 	return nil
 }
 
+// Name returns the name the file was opened with, like os.File.Name.
 func (f *File) Name() string {
-	return f.fileData.Name()
+	return f.name
 }
 
 func (f *File) Stat() (os.FileInfo, error) {
 	return &FileInfo{f.fileData}, nil
 }
 
+// Sync adds the contents written to the BlobPool of the file, if any.
 func (f *File) Sync() error {
+	if !f.readOnly {
+		intern(f.fileData)
+	}
 	return nil
 }
 
@@ -140,7 +390,12 @@ func (f *File) Readdir(count int) (res []os.FileInfo, err error) {
 	var outLength int64
 
 	f.fileData.Lock()
-	files := f.fileData.memDir.Files()[f.readDirCount:]
+	files := f.fileData.memDir.Files()
+	if f.readDirCount < int64(len(files)) {
+		files = files[f.readDirCount:]
+	} else {
+		files = nil
+	}
 	if count > 0 {
 		if len(files) < count {
 			outLength = int64(len(files))
@@ -154,6 +409,7 @@ func (f *File) Readdir(count int) (res []os.FileInfo, err error) {
 		outLength = int64(len(files))
 	}
 	f.readDirCount += outLength
+	accessed(f.fileData)
 	f.fileData.Unlock()
 
 	res = make([]os.FileInfo, outLength)
@@ -168,7 +424,7 @@ func (f *File) Readdirnames(n int) (names []string, err error) {
 	fi, err := f.Readdir(n)
 	names = make([]string, len(fi))
 	for i, f := range fi {
-		_, names[i] = filepath.Split(f.Name())
+		names[i] = f.Name()
 	}
 	return names, err
 }
@@ -179,19 +435,18 @@ func (f *File) Read(b []byte) (n int, err error) {
 	if f.closed == true {
 		return 0, ErrFileClosed
 	}
-	if len(b) > 0 && int(f.at) == len(f.fileData.data) {
+	at, size := atomic.LoadInt64(&f.at), f.fileData.data.size
+	if len(b) > 0 && at == size {
 		return 0, io.EOF
 	}
-	if int(f.at) > len(f.fileData.data) {
+	if at > size {
 		return 0, io.ErrUnexpectedEOF
 	}
-	if len(f.fileData.data)-int(f.at) >= len(b) {
-		n = len(b)
-	} else {
-		n = len(f.fileData.data) - int(f.at)
-	}
-	copy(b, f.fileData.data[f.at:f.at+int64(n)])
+	n = f.fileData.data.readAt(b, at)
 	atomic.AddInt64(&f.at, int64(n))
+	if n > 0 {
+		accessed(f.fileData)
+	}
 	return
 }
 
@@ -205,7 +460,7 @@ func (f *File) ReadAt(b []byte, off int64) (n int, err error) {
 	atomic.StoreInt64(&f.at, prev)
 	if n < len(b) && err == nil {
 		// ReadAt must return an error if n < len(b). See io.ReaderAt
-		if int(off)+n != len(f.fileData.data) {
+		if off+int64(n) != f.fileData.Size() {
 			panic("Nil error returned from Read while buffer is not EOF and not filled")
 		}
 		err = io.EOF
@@ -223,13 +478,10 @@ func (f *File) Truncate(size int64) error {
 	if size < 0 {
 		return &os.PathError{Op: "truncate", Path: f.fileData.name, Err: syscall.EINVAL}
 	}
-	if size > int64(len(f.fileData.data)) {
-		diff := size - int64(len(f.fileData.data))
-		f.fileData.data = append(f.fileData.data, bytes.Repeat([]byte{00}, int(diff))...)
-	} else {
-		f.fileData.data = f.fileData.data[0:size]
-	}
-	setModTime(f.fileData, time.Now())
+	f.fileData.Lock()
+	defer f.fileData.Unlock()
+	f.fileData.data.truncate(size)
+	setModTime(f.fileData, f.fileData.now())
 	return nil
 }
 
@@ -243,11 +495,62 @@ func (f *File) Seek(offset int64, whence int) (int64, error) {
 	case 1:
 		atomic.AddInt64(&f.at, int64(offset))
 	case 2:
-		atomic.StoreInt64(&f.at, int64(len(f.fileData.data))+offset)
+		atomic.StoreInt64(&f.at, f.fileData.Size()+offset)
 	}
 	return f.at, nil
 }
 
+// SeekData moves the offset to the start of the first data at or after
+// offset, like lseek(2) with SEEK_DATA, and returns it. It fails with ENXIO
+// if there is no more data.
+func (f *File) SeekData(offset int64) (int64, error) {
+	return f.seekExtent(offset, (*extents).seekData)
+}
+
+// SeekHole moves the offset to the start of the first hole at or after
+// offset, like lseek(2) with SEEK_HOLE, and returns it. The end of the file
+// counts as a hole. It fails with ENXIO if offset is past the end.
+func (f *File) SeekHole(offset int64) (int64, error) {
+	return f.seekExtent(offset, (*extents).seekHole)
+}
+
+func (f *File) seekExtent(offset int64, seek func(*extents, int64) int64) (int64, error) {
+	if f.closed == true {
+		return 0, ErrFileClosed
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	f.fileData.Lock()
+	defer f.fileData.Unlock()
+	pos := seek(&f.fileData.data, offset)
+	if pos < 0 {
+		return 0, &os.PathError{Op: "seek", Path: f.fileData.name, Err: ENXIO}
+	}
+	atomic.StoreInt64(&f.at, pos)
+	return pos, nil
+}
+
+// Allocate makes sure the range of length bytes at offset takes memory,
+// filling holes in it with zeros, like fallocate(2) in its default mode. The
+// file grows if the range extends past its end.
+func (f *File) Allocate(offset, length int64) error {
+	if f.closed == true {
+		return ErrFileClosed
+	}
+	if f.readOnly {
+		return &os.PathError{Op: "allocate", Path: f.fileData.name, Err: errors.New("file handle is read only")}
+	}
+	if offset < 0 || length <= 0 {
+		return &os.PathError{Op: "allocate", Path: f.fileData.name, Err: syscall.EINVAL}
+	}
+	f.fileData.Lock()
+	defer f.fileData.Unlock()
+	f.fileData.data.allocate(offset, length)
+	changed(f.fileData)
+	return nil
+}
+
 func (f *File) Write(b []byte) (n int, err error) {
 	if f.closed == true {
 		return 0, ErrFileClosed
@@ -256,24 +559,16 @@ func (f *File) Write(b []byte) (n int, err error) {
 		return 0, &os.PathError{Op: "write", Path: f.fileData.name, Err: errors.New("file handle is read only")}
 	}
 	n = len(b)
-	cur := atomic.LoadInt64(&f.at)
 	f.fileData.Lock()
 	defer f.fileData.Unlock()
-	diff := cur - int64(len(f.fileData.data))
-	var tail []byte
-	if n+int(cur) < len(f.fileData.data) {
-		tail = f.fileData.data[n+int(cur):]
-	}
-	if diff > 0 {
-		f.fileData.data = append(bytes.Repeat([]byte{00}, int(diff)), b...)
-		f.fileData.data = append(f.fileData.data, tail...)
-	} else {
-		f.fileData.data = append(f.fileData.data[:cur], b...)
-		f.fileData.data = append(f.fileData.data, tail...)
+	cur := atomic.LoadInt64(&f.at)
+	if f.append {
+		cur = f.fileData.data.size
 	}
-	setModTime(f.fileData, time.Now())
+	f.fileData.data.writeAt(b, cur)
+	setModTime(f.fileData, f.fileData.now())
 
-	atomic.StoreInt64(&f.at, int64(len(f.fileData.data)))
+	atomic.StoreInt64(&f.at, cur+int64(n))
 	return
 }
 
@@ -281,8 +576,14 @@ func (f *File) WriteAt(b []byte, off int64) (n int, err error) {
 	if off < 0 {
 		return 0, &os.PathError{Op: "writeat", Path: f.fileData.name, Err: errors.New("negative offset")}
 	}
+	if f.append {
+		return 0, &os.PathError{Op: "writeat", Path: f.fileData.name, Err: errors.New("invalid use of WriteAt on file opened with O_APPEND")}
+	}
+	prev := atomic.LoadInt64(&f.at)
 	atomic.StoreInt64(&f.at, off)
-	return f.Write(b)
+	n, err = f.Write(b)
+	atomic.StoreInt64(&f.at, prev)
+	return
 }
 
 func (f *File) WriteString(s string) (ret int, err error) {
@@ -300,7 +601,7 @@ type FileInfo struct {
 // Implements os.FileInfo
 func (s *FileInfo) Name() string {
 	s.Lock()
-	_, name := filepath.Split(s.name)
+	name := s.baseName()
 	s.Unlock()
 	return name
 }
@@ -319,14 +620,34 @@ func (s *FileInfo) IsDir() bool {
 	defer s.Unlock()
 	return s.dir
 }
-func (s *FileInfo) Sys() interface{} { return nil }
+
+// Sys returns a *Stat.
+func (s *FileInfo) Sys() interface{} {
+	s.Lock()
+	defer s.Unlock()
+	st := &Stat{
+		Dev:     s.dev,
+		Ino:     s.ino,
+		Nlink:   s.nlink,
+		Mode:    unixMode(s.mode, s.dir),
+		Size:    s.data.size,
+		Blksize: blockSize,
+		Atim:    s.atime,
+		Mtim:    s.modtime,
+		Ctim:    s.ctime,
+		Btim:    s.btime,
+	}
+	if s.dir {
+		st.Size = 42
+	}
+	st.Blocks = (s.data.allocated() + 511) / 512
+	return st
+}
 func (s *FileInfo) Size() int64 {
 	if s.IsDir() {
 		return int64(42)
 	}
-	s.Lock()
-	defer s.Unlock()
-	return int64(len(s.data))
+	return s.FileData.Size()
 }
 
 var (
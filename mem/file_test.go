@@ -1,7 +1,9 @@
 package mem
 
 import (
+	"bytes"
 	"io"
+	"math/rand"
 	"testing"
 	"time"
 )
@@ -126,7 +128,7 @@ func TestFileDataSizeRace(t *testing.T) {
 	const someOtherDataSize = "Hello World"
 
 	d := FileData{
-		data: []byte(someData),
+		data: newExtents([]byte(someData)),
 		dir:  false,
 	}
 
@@ -140,7 +142,7 @@ func TestFileDataSizeRace(t *testing.T) {
 
 	go func() {
 		s.Lock()
-		d.data = []byte(someOtherDataSize)
+		d.data = newExtents([]byte(someOtherDataSize))
 		s.Unlock()
 	}()
 
@@ -274,3 +276,157 @@ func TestReadAtMustEOF(t *testing.T) {
 		t.Error("ReadAt must return an error since n < len(buf). Must be EOF here, but got:", err)
 	}
 }
+
+func TestExtentsAgainstSlice(t *testing.T) {
+	t.Parallel()
+
+	rnd := rand.New(rand.NewSource(1))
+	var e extents
+	var model []byte
+	for i := 0; i < 2000; i++ {
+		off := rnd.Int63n(4 * chunkSize)
+		switch rnd.Intn(10) {
+		case 0:
+			e.truncate(off)
+			if off < int64(len(model)) {
+				model = model[:off]
+			} else {
+				model = append(model, make([]byte, off-int64(len(model)))...)
+			}
+		case 1:
+			n := rnd.Int63n(chunkSize) + 1
+			e.allocate(off, n)
+			if off+n > int64(len(model)) {
+				model = append(model, make([]byte, off+n-int64(len(model)))...)
+			}
+		default:
+			p := make([]byte, rnd.Intn(2*chunkSize))
+			rnd.Read(p)
+			e.writeAt(p, off)
+			if end := off + int64(len(p)); end > int64(len(model)) {
+				model = append(model, make([]byte, end-int64(len(model)))...)
+			}
+			copy(model[off:], p)
+		}
+
+		if e.size != int64(len(model)) {
+			t.Fatalf("step %d: expected size %d, got %d", i, len(model), e.size)
+		}
+		got := make([]byte, len(model))
+		e.readAt(got, 0)
+		if !bytes.Equal(got, model) {
+			t.Fatalf("step %d: contents differ", i)
+		}
+		for j, x := range e.list {
+			if len(x.data) == 0 || x.off/chunkSize != (x.end()-1)/chunkSize || x.end() > e.size {
+				t.Fatalf("step %d: bad extent at %d of %d bytes", i, x.off, len(x.data))
+			}
+			if j > 0 && (x.off < e.list[j-1].end() || x.off == e.list[j-1].end() && x.off%chunkSize != 0) {
+				t.Fatalf("step %d: extent at %d overlaps or touches the previous one", i, x.off)
+			}
+		}
+	}
+}
+
+func TestExtentsClone(t *testing.T) {
+	data := make([]byte, 3*chunkSize)
+	rand.New(rand.NewSource(1)).Read(data)
+	e := newExtents(nil)
+	e.writeAt(data, 0)
+	c := e.clone()
+
+	c.writeAt([]byte("clone"), chunkSize+10)
+	for i := range c.list {
+		shared := &c.list[i].data[0] == &e.list[i].data[0]
+		if shared != (i != 1) {
+			t.Errorf("chunk %d: expected only the chunk written to be copied", i)
+		}
+	}
+	// growing a truncated extent must not write into the original either
+	e.truncate(10)
+	e.writeAt([]byte("original"), 10)
+
+	got := make([]byte, c.size)
+	c.readAt(got, 0)
+	expected := append([]byte{}, data...)
+	copy(expected[chunkSize+10:], "clone")
+	if !bytes.Equal(got, expected) {
+		t.Errorf("clone changed by writes to the original")
+	}
+	got = make([]byte, e.size)
+	e.readAt(got, 0)
+	if expected := append(data[:10:10], "original"...); !bytes.Equal(got, expected) {
+		t.Errorf("original changed by writes to the clone")
+	}
+}
+
+func benchmarkWrite(b *testing.B, size int) {
+	buf := make([]byte, size)
+	f := NewFileHandle(CreateFile("foo"))
+	b.SetBytes(int64(size))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := f.Write(buf); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkWrite512(b *testing.B) { benchmarkWrite(b, 512) }
+func BenchmarkWrite4K(b *testing.B)  { benchmarkWrite(b, 4096) }
+func BenchmarkWrite64K(b *testing.B) { benchmarkWrite(b, 64<<10) }
+
+// newBenchmarkFile returns a file of size bytes, written in 4K pieces.
+func newBenchmarkFile(b *testing.B, size int) *File {
+	f := NewFileHandle(CreateFile("foo"))
+	buf := make([]byte, 4096)
+	for n := 0; n < size; n += len(buf) {
+		if _, err := f.Write(buf); err != nil {
+			b.Fatal(err)
+		}
+	}
+	return f
+}
+
+func BenchmarkWriteAt(b *testing.B) {
+	const size = 64 << 20
+	f := newBenchmarkFile(b, size)
+	buf := make([]byte, 4096)
+	b.SetBytes(int64(len(buf)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		off := int64(i*7919*len(buf)) % (size - int64(len(buf)))
+		if _, err := f.WriteAt(buf, off); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkReadAt(b *testing.B) {
+	const size = 64 << 20
+	f := newBenchmarkFile(b, size)
+	buf := make([]byte, 4096)
+	b.SetBytes(int64(len(buf)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		off := int64(i*7919*len(buf)) % (size - int64(len(buf)))
+		if _, err := f.ReadAt(buf, off); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkTruncate(b *testing.B) {
+	const size = 64 << 20
+	f := newBenchmarkFile(b, size)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		// shrink and grow by a page at the end, as a log file might
+		if err := f.Truncate(size - 4096); err != nil {
+			b.Fatal(err)
+		}
+		if _, err := f.WriteAt(make([]byte, 4096), size-4096); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package mem
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"runtime"
+	"sync"
+)
+
+// BlobPool stores the contents of files by their hash, so identical contents
+// are kept once, however many files and file systems hold them. Files using
+// a pool add their contents to it, chunk by chunk, when a handle that can
+// write to them is closed or synced. Writing to a chunk held in the pool
+// copies it first. The pool keeps a chunk as long as some file refers to it;
+// the chunks of files that are removed, or belong to file systems no longer
+// in use, are released once the garbage collector finds those files
+// unreachable.
+type BlobPool struct {
+	mu       sync.Mutex
+	blobs    map[[sha256.Size]byte]*blob
+	physical int64
+	logical  int64
+}
+
+// BlobPoolStats describes the contents held in a BlobPool.
+type BlobPoolStats struct {
+	// Blobs is the number of distinct chunks held.
+	Blobs int
+	// LogicalBytes is the size of all chunks held, counted once for
+	// every file referring to them.
+	LogicalBytes int64
+	// PhysicalBytes is the size of all distinct chunks held, which is the
+	// memory they take.
+	PhysicalBytes int64
+}
+
+// blob is a chunk of file contents held in a BlobPool, which must not be
+// modified.
+type blob struct {
+	pool *BlobPool
+	key  [sha256.Size]byte
+	data []byte
+	refs int
+}
+
+func NewBlobPool() *BlobPool {
+	return &BlobPool{blobs: make(map[[sha256.Size]byte]*blob)}
+}
+
+func (p *BlobPool) Stats() BlobPoolStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return BlobPoolStats{Blobs: len(p.blobs), LogicalBytes: p.logical, PhysicalBytes: p.physical}
+}
+
+// intern returns the blob holding data, adding data to the pool if needed,
+// and takes a reference to it. It returns nil in the unlikely case that other
+// data with the same hash is held.
+func (p *BlobPool) intern(data []byte) *blob {
+	key := sha256.Sum256(data)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	b, ok := p.blobs[key]
+	if !ok {
+		if cap(data) > len(data) {
+			// do not keep the spare capacity
+			data = append([]byte(nil), data...)
+		}
+		b = &blob{pool: p, key: key, data: data}
+		p.blobs[key] = b
+		p.physical += int64(len(data))
+	} else if !bytes.Equal(b.data, data) {
+		return nil
+	}
+	b.refs++
+	p.logical += int64(len(data))
+	return b
+}
+
+func (b *blob) retain() {
+	b.pool.mu.Lock()
+	b.refs++
+	b.pool.logical += int64(len(b.data))
+	b.pool.mu.Unlock()
+}
+
+func (b *blob) release() {
+	p := b.pool
+	p.mu.Lock()
+	b.refs--
+	p.logical -= int64(len(b.data))
+	if b.refs == 0 {
+		delete(p.blobs, b.key)
+		p.physical -= int64(len(b.data))
+	}
+	p.mu.Unlock()
+}
+
+// SetBlobPool makes f keep its contents in pool, adding those it has. A nil
+// pool makes f keep the contents it writes to itself.
+func SetBlobPool(f *FileData, pool *BlobPool) {
+	f.Lock()
+	f.pool = pool
+	f.Unlock()
+	intern(f)
+}
+
+// intern adds the contents of f not yet held in its pool to the pool.
+func intern(f *FileData) {
+	f.Lock()
+	defer f.Unlock()
+	if f.pool == nil {
+		return
+	}
+	for i := range f.data.list {
+		x := &f.data.list[i]
+		if x.blob != nil {
+			continue
+		}
+		if b := f.pool.intern(x.data); b != nil {
+			x.data, x.blob, x.shared = b.data, b, true
+		}
+	}
+	holdBlobs(f)
+}
+
+// holdBlobs makes sure the blobs held by f are released with it. The caller
+// must hold the lock of f.
+func holdBlobs(f *FileData) {
+	if f.holdsBlobs {
+		return
+	}
+	for i := range f.data.list {
+		if f.data.list[i].blob != nil {
+			f.holdsBlobs = true
+			runtime.SetFinalizer(f, func(f *FileData) { f.data.release() })
+			return
+		}
+	}
+}
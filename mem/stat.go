@@ -0,0 +1,68 @@
+package mem
+
+import (
+	"os"
+	"time"
+)
+
+// blockSize is the preferred I/O size reported in Stat.Blksize.
+const blockSize = 4096
+
+// Stat is returned by FileInfo.Sys. Its fields are named like those of
+// syscall.Stat_t on Linux, so code inspecting inode numbers, link counts and
+// timestamps reads much the same for both.
+type Stat struct {
+	// Dev identifies the file system, Ino the file within it. Together
+	// they are stable for the lifetime of a file, across renames.
+	Dev uint64
+	Ino uint64
+	// Nlink counts the directory entries referring to the file. For
+	// directories this includes "." and the ".." of each subdirectory.
+	Nlink uint64
+	// Mode holds the file type and permission bits in Unix format.
+	Mode    uint32
+	Size    int64
+	Blksize int64
+	// Blocks is the number of 512-byte blocks allocated.
+	Blocks int64
+	// Atim is the time of the last read, as far as the AtimePolicy
+	// records it, Mtim that of the last change to the contents and Ctim
+	// that of the last change to the contents or metadata. Btim is the
+	// time the file was created.
+	Atim time.Time
+	Mtim time.Time
+	Ctim time.Time
+	Btim time.Time
+}
+
+// Unix file type bits, as in <sys/stat.h>.
+const (
+	sIFDIR = 0040000
+	sIFREG = 0100000
+	sIFLNK = 0120000
+	sISUID = 0004000
+	sISGID = 0002000
+	sISVTX = 0001000
+)
+
+func unixMode(mode os.FileMode, dir bool) uint32 {
+	m := uint32(mode.Perm())
+	switch {
+	case dir:
+		m |= sIFDIR
+	case mode&os.ModeSymlink != 0:
+		m |= sIFLNK
+	default:
+		m |= sIFREG
+	}
+	if mode&os.ModeSetuid != 0 {
+		m |= sISUID
+	}
+	if mode&os.ModeSetgid != 0 {
+		m |= sISGID
+	}
+	if mode&os.ModeSticky != 0 {
+		m |= sISVTX
+	}
+	return m
+}
@@ -14,12 +14,14 @@
 package afero
 
 import (
+	"errors"
 	"fmt"
-	"log"
+	"math"
 	"os"
-	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/spf13/afero/mem"
@@ -27,158 +29,286 @@ import (
 
 const chmodBits = os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky // Only a subset of bits are allowed to be changed. Documented under os.Chmod()
 
+var _ Locker = (*MemMapFs)(nil)
+var _ XAttr = (*MemMapFs)(nil)
+var _ StatFS = (*MemMapFs)(nil)
+var _ Cloner = (*MemMapFs)(nil)
+var _ Chdirer = (*MemMapFs)(nil)
+var _ SlashPather = (*MemMapFs)(nil)
+
+// MemMapFs is a file system held in memory. Its files behave like inodes on
+// POSIX systems: open handles refer to the file rather than its name, so a
+// file that is removed, or replaced by Rename, stays readable and writable
+// through the handles open on it until they are closed, and its link count
+// drops to zero. Renaming a file does not change the name its open handles
+// report.
+//
+// Operations on different directories run concurrently. Each directory has
+// its own lock, guarding its entries, and m.mu is held shared while looking
+// up and changing them, or exclusively to rename a directory, which changes
+// the names of all files below it. Locks are taken in this order:
+//
+//  1. m.mu
+//  2. renameMu, held while renaming a file from one directory to another
+//  3. directories, parents before their entries; the two directories of a
+//     rename are locked ancestor first, or in either order if unrelated
+//  4. files, after the directory holding them
+//
+// Only a rename locks two directories that are not parent and entry, and
+// renames between directories are serialized by renameMu, or by m.mu for
+// directories, so no two operations can wait on each other's directories.
 type MemMapFs struct {
-	mu   sync.RWMutex
-	data map[string]*mem.FileData
-	init sync.Once
+	mu       sync.RWMutex
+	renameMu sync.Mutex
+	root     *mem.FileData
+	init     sync.Once
+	locks    lockTable
+	dev      uint64
+	atime    mem.AtimePolicy
+	clock    Clock
+	pool     *mem.BlobPool
+	wd       atomic.Value // string, the working directory if set
+	umask    uint32
+	slash    bool
+
+	capacityBytes  uint64
+	capacityInodes uint64
 }
 
+// lastDev is the device number most recently given to a MemMapFs.
+var lastDev uint64
+
 func NewMemMapFs() Fs {
 	return &MemMapFs{}
 }
 
-func (m *MemMapFs) getData() map[string]*mem.FileData {
+// NewSlashMemMapFs returns a MemMapFs using slash paths, whose trees are the
+// same on every OS.
+func NewSlashMemMapFs() Fs {
+	return &MemMapFs{slash: true}
+}
+
+// SlashPaths reports whether m uses slash paths.
+func (m *MemMapFs) SlashPaths() bool {
+	return m.slash
+}
+
+// paths returns the path functions of m.
+func (m *MemMapFs) paths() *pathFuncs {
+	if m.slash {
+		return slashPaths
+	}
+	return hostPaths
+}
+
+// getRoot returns the root directory, which holds the tree of all files.
+func (m *MemMapFs) getRoot() *mem.FileData {
 	m.init.Do(func() {
-		m.data = make(map[string]*mem.FileData)
+		m.dev = atomic.AddUint64(&lastDev, 1)
 		// Root should always exist, right?
 		// TODO: what about windows?
-		root := mem.CreateDir(FilePathSeparator)
-		mem.SetMode(root, os.ModeDir|0755)
-		m.data[FilePathSeparator] = root
+		m.root = m.newDir(m.paths().separator)
+		mem.SetMode(m.root, os.ModeDir|0755)
+		mem.AddLink(m.root, 1) // the root is its own parent
 	})
-	return m.data
+	return m.root
 }
 
-func (*MemMapFs) Name() string { return "MemMapFS" }
-
-func (m *MemMapFs) Create(name string) (File, error) {
-	const createPerm = 0666
-
-	name = normalizePath(name)
-	err := m.requireParentDirectory("open", name)
-	if err != nil {
-		return nil, err
+// lookup walks the tree down to the file with the given normalized name.
+func (m *MemMapFs) lookup(name string) (*mem.FileData, bool) {
+	f := m.getRoot()
+	sep := m.paths().separator
+	if name == sep {
+		return f, true
+	}
+	for _, elem := range strings.Split(name[len(sep):], sep) {
+		f.Lock()
+		next, ok := mem.FindInDir(f, elem)
+		f.Unlock()
+		if !ok {
+			return nil, false
+		}
+		f = next
 	}
+	return f, true
+}
 
-	info, err := m.Stat(name)
-	switch {
-	case os.IsNotExist(err):
-		// if not exist or is a file, truncate
-		m.mu.Lock()
-		m.lockFreeRemoveAll(name)
-		file := mem.CreateFile(name)
-		mem.SetMode(file, createPerm)
-		m.getData()[name] = file
-		m.registerWithParent(file)
-		m.mu.Unlock()
-		return mem.NewFileHandle(file), nil
-	case err != nil:
-		return nil, err
-	case info.IsDir():
-		return nil, &os.PathError{Op: "open", Path: name, Err: ErrIsDir} // uses 'open' in os.Create
-	default:
-		// exists and is a file
-		m.mu.RLock()
-		fileData := m.getData()[name]
-		m.mu.RUnlock()
-		file := mem.NewFileHandle(fileData)
-		err := file.Truncate(0)
-		return file, err
+// walkTree calls fn for f and everything below it, parents first.
+func walkTree(f *mem.FileData, fn func(*mem.FileData)) {
+	fn(f)
+	if dir, err := mem.ReadMemDir(f); err == nil {
+		for _, fi := range dir {
+			walkTree(fi.(*mem.FileInfo).FileData, fn)
+		}
 	}
 }
 
-func (m *MemMapFs) unRegisterWithParent(fileName string) error {
-	f, err := m.lockfreeOpen(fileName)
-	if err != nil {
-		return err
+func (m *MemMapFs) newFile(name string) *mem.FileData {
+	f := mem.CreateFileWithClock(name, m.clock)
+	mem.SetDev(f, m.dev)
+	mem.SetAtimePolicy(f, m.atime)
+	if m.slash {
+		mem.SetSlashPaths(f)
 	}
-	parent := m.findParent(f)
-	if parent == nil {
-		log.Panic("parent of ", f.Name(), " is nil")
+	if m.pool != nil {
+		mem.SetBlobPool(f, m.pool)
 	}
+	return f
+}
 
-	parent.Lock()
-	mem.RemoveFromMemDir(parent, f)
-	parent.Unlock()
-	return nil
+func (m *MemMapFs) newDir(name string) *mem.FileData {
+	d := mem.CreateDirWithClock(name, m.clock)
+	mem.SetDev(d, m.dev)
+	mem.SetAtimePolicy(d, m.atime)
+	if m.slash {
+		mem.SetSlashPaths(d)
+	}
+	return d
+}
+
+// SetClock sets the clock files in m take their timestamps from. Set it
+// before creating any files to make all timestamps, including those of the
+// root directory, come from clock. A nil clock means the system clock.
+func (m *MemMapFs) SetClock(clock Clock) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.clock = clock
+	walkTree(m.getRoot(), func(f *mem.FileData) {
+		mem.SetClock(f, clock)
+	})
 }
 
-// requireParentDirectory requires the parent to 'path' exists and is a directory
-func (m *MemMapFs) requireParentDirectory(operationName, path string) error {
-	path = normalizePath(path)
-	parentPath := filepath.Dir(path)
-	parent, parentErr := m.Stat(parentPath)
-	if parentErr != nil {
-		if os.IsNotExist(parentErr) {
-			return &os.PathError{Op: operationName, Path: path, Err: os.ErrNotExist}
+// SetAtimePolicy sets when reading a file updates its access time, for all
+// files in m. The default is mem.NoAtime, which keeps reads cheap; access
+// times are then only changed by Chtimes.
+func (m *MemMapFs) SetAtimePolicy(policy mem.AtimePolicy) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.atime = policy
+	walkTree(m.getRoot(), func(f *mem.FileData) {
+		mem.SetAtimePolicy(f, policy)
+	})
+}
+
+// SetBlobPool makes the files in m keep their contents in pool, which may be
+// shared with other file systems, so that identical contents are stored
+// once. The contents of existing files are added to pool right away, and
+// those written later when the handles writing them are closed or synced.
+// Compare the logical and physical bytes reported by pool.Stats to see how
+// much memory this saves.
+func (m *MemMapFs) SetBlobPool(pool *mem.BlobPool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.pool = pool
+	walkTree(m.getRoot(), func(f *mem.FileData) {
+		if !mem.GetFileInfo(f).IsDir() {
+			mem.SetBlobPool(f, pool)
 		}
-		return parentErr
+	})
+}
+
+// SetUmask sets the permission bits cleared from the perm given when creating
+// files and directories, like the process umask does for OsFs. It is zero by
+// default, applying perm as given.
+func (m *MemMapFs) SetUmask(mask os.FileMode) {
+	atomic.StoreUint32(&m.umask, uint32(mask&os.ModePerm))
+}
+
+func (m *MemMapFs) Umask() os.FileMode {
+	return os.FileMode(atomic.LoadUint32(&m.umask))
+}
+
+func (*MemMapFs) Name() string { return "MemMapFS" }
+
+func (m *MemMapFs) Create(name string) (File, error) {
+	const createPerm = 0666
+
+	name = m.absPath(name)
+	f, created, err := m.create(name, false, createPerm)
+	if err != nil {
+		return nil, err
 	}
-	if !parent.IsDir() {
-		return &os.PathError{Op: operationName, Path: path, Err: ErrNotDir}
+	file := mem.NewFileHandle(f)
+	if !created {
+		if file.Info().IsDir() {
+			return nil, &os.PathError{Op: "open", Path: name, Err: ErrIsDir} // uses 'open' in os.Create
+		}
+		// exists and is a file, truncate
+		if err := file.Truncate(0); err != nil {
+			return file, err
+		}
 	}
-	return nil
+	return file, nil
 }
 
-func (m *MemMapFs) findParent(f *mem.FileData) *mem.FileData {
-	pdir, _ := filepath.Split(f.Name())
-	pdir = filepath.Clean(pdir)
-	pfile, err := m.lockfreeOpen(pdir)
+// create creates the file with the given normalized name and perm, unless
+// it exists, in which case it returns the existing file, or fails if excl is
+// set. It reports whether the file was created.
+func (m *MemMapFs) create(name string, excl bool, perm os.FileMode) (*mem.FileData, bool, error) {
+	if name == m.paths().separator {
+		return nil, false, &os.PathError{Op: "open", Path: name, Err: ErrIsDir}
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	dir, err := m.lockParent(name)
 	if err != nil {
-		return nil
+		return nil, false, &os.PathError{Op: "open", Path: name, Err: err}
+	}
+	defer dir.Unlock()
+
+	if f, ok := mem.FindInDir(dir, m.paths().base(name)); ok {
+		if excl {
+			return nil, false, &os.PathError{Op: "open", Path: name, Err: ErrFileExists}
+		}
+		return f, false, nil
 	}
-	return pfile
+	f := m.newFile(name)
+	mem.SetMode(f, perm&^m.Umask())
+	mem.AddToMemDir(dir, f)
+	return f, true, nil
 }
 
-func (m *MemMapFs) registerWithParent(f *mem.FileData) {
-	if f == nil {
-		return
+// lockParent looks up the directory holding the file with the given
+// normalized name and returns it locked. The caller must hold m.mu.
+func (m *MemMapFs) lockParent(name string) (*mem.FileData, error) {
+	dir, ok := m.lookup(m.paths().dir(name))
+	if !ok {
+		return nil, os.ErrNotExist
 	}
-	parent := m.findParent(f)
-	if parent == nil {
-		panic("parent does not exist for file: " + f.Name())
+	if !mem.GetFileInfo(dir).IsDir() {
+		return nil, ErrNotDir
 	}
-
-	parent.Lock()
-	mem.InitializeDir(parent)
-	mem.AddToMemDir(parent, f)
-	parent.Unlock()
+	dir.Lock()
+	if mem.Unlinked(dir) {
+		// removed since the lookup
+		dir.Unlock()
+		return nil, os.ErrNotExist
+	}
+	return dir, nil
 }
 
 func (m *MemMapFs) Mkdir(name string, perm os.FileMode) error {
 	perm &= chmodBits
-	name = normalizePath(name)
-
-	m.mu.RLock()
-	_, ok := m.getData()[name]
-	m.mu.RUnlock()
-	if ok {
+	name = m.absPath(name)
+	if name == m.paths().separator {
 		return &os.PathError{Op: "mkdir", Path: name, Err: ErrFileExists}
 	}
 
-	err := m.requireParentDirectory("mkdir", name)
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	dir, err := m.lockParent(name)
 	if err != nil {
-		return err
+		return &os.PathError{Op: "mkdir", Path: name, Err: err}
 	}
+	defer dir.Unlock()
 
-	m.mu.Lock()
-	defer m.mu.Unlock()
-	data := m.getData()
-	if _, ok := data[name]; ok {
+	if _, ok := mem.FindInDir(dir, m.paths().base(name)); ok {
 		return &os.PathError{Op: "mkdir", Path: name, Err: ErrFileExists}
 	}
-	parent := filepath.Dir(name)
-	parentFile, ok := data[parent]
-	if !ok {
-		return &os.PathError{Op: "mkdir", Path: parent, Err: os.ErrNotExist}
-	}
-	if !mem.NewReadOnlyFileHandle(parentFile).Info().IsDir() {
-		return &os.PathError{Op: "mkdir", Path: parent, Err: ErrNotDir}
-	}
-	item := mem.CreateDir(name)
-	data[name] = item
-	m.registerWithParent(item)
-	mem.SetMode(item, perm|os.ModeDir)
+	item := m.newDir(name)
+	mem.SetMode(item, perm&^m.Umask()|os.ModeDir)
+	mem.AddToMemDir(dir, item)
 	return nil
 }
 
@@ -199,9 +329,9 @@ func (m *MemMapFs) MkdirAll(path string, perm os.FileMode) error {
 
 // findMissingDirs returns all paths that must be created, in reverse order
 func (m *MemMapFs) findMissingDirs(path string) ([]string, error) {
-	path = normalizePath(path)
+	path = m.absPath(path)
 	var missingDirs []string
-	for currentPath := path; currentPath != FilePathSeparator; currentPath = filepath.Dir(currentPath) {
+	for currentPath := path; currentPath != m.paths().separator; currentPath = m.paths().dir(currentPath) {
 		info, err := m.Stat(currentPath)
 		switch {
 		case os.IsNotExist(err):
@@ -219,41 +349,59 @@ func (m *MemMapFs) findMissingDirs(path string) ([]string, error) {
 	return missingDirs, nil
 }
 
-// Handle some relative paths
-func normalizePath(path string) string {
-	path = filepath.Clean(FilePathSeparator + path) // prepend "/" to ensure "/tmp" and "tmp" are identical files
+// absPath returns the normalized absolute form of path, resolving it against
+// the working directory if it is relative.
+func (m *MemMapFs) absPath(path string) string {
+	p := m.paths()
+	if !p.isAbs(path) && !strings.HasPrefix(path, p.separator) {
+		if wd, ok := m.wd.Load().(string); ok {
+			path = p.join(wd, path)
+		}
+	}
+	return p.normalize(path)
+}
 
-	switch path {
-	case ".":
-		return FilePathSeparator
-	case "..":
-		return FilePathSeparator
-	default:
-		return path
+// Getwd returns the working directory, against which relative paths are
+// resolved. It is the root until changed by Chdir.
+func (m *MemMapFs) Getwd() (string, error) {
+	if wd, ok := m.wd.Load().(string); ok {
+		return wd, nil
 	}
+	return m.paths().separator, nil
 }
 
-func (m *MemMapFs) Open(name string) (File, error) {
-	f, err := m.open(name)
-	if f != nil {
-		return mem.NewReadOnlyFileHandle(f), err
+// Chdir changes the working directory of m to dir.
+func (m *MemMapFs) Chdir(dir string) error {
+	dir = m.absPath(dir)
+	f, err := m.open(dir)
+	if err != nil {
+		return &os.PathError{Op: "chdir", Path: dir, Err: os.ErrNotExist}
 	}
-	return nil, err
+	if !mem.GetFileInfo(f).IsDir() {
+		return &os.PathError{Op: "chdir", Path: dir, Err: ErrNotDir}
+	}
+	m.wd.Store(dir)
+	return nil
+}
+
+// Handle some relative paths
+func normalizePath(path string) string {
+	return hostPaths.normalize(path)
 }
 
-func (m *MemMapFs) openWrite(name string) (File, error) {
+func (m *MemMapFs) Open(name string) (File, error) {
 	f, err := m.open(name)
 	if f != nil {
-		return mem.NewFileHandle(f), err
+		return mem.NewReadOnlyFileHandle(f), err
 	}
 	return nil, err
 }
 
 func (m *MemMapFs) open(name string) (*mem.FileData, error) {
-	name = normalizePath(name)
+	name = m.absPath(name)
 
 	m.mu.RLock()
-	f, ok := m.getData()[name]
+	f, ok := m.lookup(name)
 	m.mu.RUnlock()
 	if !ok {
 		return nil, &os.PathError{Op: "open", Path: name, Err: ErrFileNotFound}
@@ -261,30 +409,19 @@ func (m *MemMapFs) open(name string) (*mem.FileData, error) {
 	return f, nil
 }
 
-func (m *MemMapFs) lockfreeOpen(name string) (*mem.FileData, error) {
-	name = normalizePath(name)
-	f, ok := m.getData()[name]
-	if ok {
-		return f, nil
-	} else {
-		return nil, ErrFileNotFound
-	}
-}
-
 func (m *MemMapFs) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
 	perm &= chmodBits
-	chmod := false
-	file, err := m.openWrite(name)
-	if err == nil && (flag&os.O_EXCL > 0) {
-		return nil, &os.PathError{Op: "open", Path: name, Err: ErrFileExists}
-	}
-	if os.IsNotExist(err) && flag&os.O_CREATE > 0 {
-		file, err = m.Create(name)
-		chmod = true
+	var f *mem.FileData
+	var err error
+	if flag&os.O_CREATE > 0 {
+		f, _, err = m.create(m.absPath(name), flag&os.O_EXCL > 0, perm)
+	} else {
+		f, err = m.open(name)
 	}
 	if err != nil {
 		return nil, err
 	}
+	var file File = mem.NewFileHandle(f)
 	if flag != os.O_RDONLY {
 		info, err := file.Stat()
 		if err != nil {
@@ -295,9 +432,10 @@ func (m *MemMapFs) OpenFile(name string, flag int, perm os.FileMode) (File, erro
 		}
 	}
 	if flag == os.O_RDONLY {
-		file = mem.NewReadOnlyFileHandle(file.(*mem.File).Data())
+		file = mem.NewReadOnlyFileHandle(f)
 	}
 	if flag&os.O_APPEND > 0 {
+		file.(*mem.File).SetAppend()
 		_, err = file.Seek(0, os.SEEK_END)
 		if err != nil {
 			file.Close()
@@ -311,73 +449,81 @@ func (m *MemMapFs) OpenFile(name string, flag int, perm os.FileMode) (File, erro
 			return nil, err
 		}
 	}
-	if chmod {
-		return file, m.unrestrictedChmod(name, perm)
-	}
 	return file, nil
 }
 
 func (m *MemMapFs) Remove(name string) error {
-	name = normalizePath(name)
+	name = m.absPath(name)
+	if name == m.paths().separator {
+		return &os.PathError{Op: "remove", Path: name, Err: syscall.EBUSY}
+	}
 
-	m.mu.Lock()
-	defer m.mu.Unlock()
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	dir, err := m.lockParent(name)
+	if err != nil {
+		return &os.PathError{Op: "remove", Path: name, Err: err}
+	}
+	defer dir.Unlock()
 
-	if f, ok := m.getData()[name]; ok {
-		if mem.GetFileInfo(f).IsDir() {
-			dir, err := mem.ReadMemDir(f)
-			if err != nil {
-				panic("Directory failed to list entries: " + err.Error())
-			}
-			if len(dir) != 0 {
-				return &os.PathError{Op: "remove", Path: name, Err: ErrNotEmpty}
-			}
-		}
-		err := m.unRegisterWithParent(name)
-		if err != nil {
-			return &os.PathError{Op: "remove", Path: name, Err: err}
-		}
-		delete(m.getData(), name)
-	} else {
+	f, ok := mem.FindInDir(dir, m.paths().base(name))
+	if !ok {
 		return &os.PathError{Op: "remove", Path: name, Err: os.ErrNotExist}
 	}
+	if !mem.RemoveEmptyFromMemDir(dir, f) {
+		return &os.PathError{Op: "remove", Path: name, Err: ErrNotEmpty}
+	}
 	return nil
 }
 
 func (m *MemMapFs) RemoveAll(path string) error {
-	m.mu.Lock()
-	m.lockFreeRemoveAll(path)
-	m.mu.Unlock()
-	return nil
-}
+	path = m.absPath(path)
 
-func (m *MemMapFs) lockFreeRemoveAll(path string) {
-	path = normalizePath(path)
-	fileData, err := m.lockfreeOpen(path)
-	if err == ErrFileNotFound {
-		return
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if path == m.paths().separator {
+		unlinkTree(m.getRoot())
+		return nil
 	}
+	dir, err := m.lockParent(path)
 	if err != nil {
-		panic("impossible case: other err from lockfreeOpen")
+		return nil
 	}
-	err = m.unRegisterWithParent(path)
-	if err != nil {
-		panic("failed to unregister with parent: " + err.Error())
+	f, ok := mem.FindInDir(dir, m.paths().base(path))
+	if ok {
+		mem.RemoveFromMemDir(dir, f)
+	}
+	dir.Unlock()
+	if ok {
+		unlinkTree(f)
 	}
-	defer delete(m.getData(), path)
+	return nil
+}
 
-	dir, err := mem.ReadMemDir(fileData)
-	if err == nil {
-		for _, f := range dir {
-			m.lockFreeRemoveAll(filepath.Join(path, f.Name()))
-		}
+// unlinkTree removes all entries below dir, so the files stay behind only
+// for the handles open on them, with no links. Each directory is unlinked
+// before its entries are read, so nothing can be added to it afterwards.
+func unlinkTree(dir *mem.FileData) {
+	files, err := mem.ReadMemDir(dir)
+	if err != nil {
+		return
+	}
+	dir.Lock()
+	for _, fi := range files {
+		mem.RemoveFromMemDir(dir, fi.(*mem.FileInfo).FileData)
+	}
+	dir.Unlock()
+	for _, fi := range files {
+		unlinkTree(fi.(*mem.FileInfo).FileData)
 	}
-	return
 }
 
+// errRenameDir makes Rename retry with m.mu held exclusively.
+var errRenameDir = errors.New("rename of a directory")
+
 func (m *MemMapFs) Rename(oldname, newname string) error {
-	oldname = normalizePath(oldname)
-	newname = normalizePath(newname)
+	oldname = m.absPath(oldname)
+	newname = m.absPath(newname)
 
 	info, err := m.Stat(newname)
 	if err == nil && info.IsDir() {
@@ -387,137 +533,282 @@ func (m *MemMapFs) Rename(oldname, newname string) error {
 	if oldname == newname {
 		return nil
 	}
-	if strings.HasPrefix(newname, oldname+FilePathSeparator) {
+	if strings.HasPrefix(newname, oldname+m.paths().separator) {
 		// new path must not be inside the old path
 		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: os.ErrInvalid}
 	}
 
 	m.mu.RLock()
-	_, ok := m.getData()[oldname]
+	err = m.rename(oldname, newname, false)
 	m.mu.RUnlock()
-	if ok {
-		// File existed a moment ago. Upgrade to full write lock, then double-check 'ok' is still true.
+	if err == errRenameDir {
 		m.mu.Lock()
-		defer m.mu.Unlock()
-		_, ok = m.getData()[oldname]
+		err = m.rename(oldname, newname, true)
+		m.mu.Unlock()
 	}
+	return err
+}
+
+// rename moves oldname to newname, replacing any file there. Renaming a
+// directory changes the names of everything below it, so it needs m.mu held
+// exclusively, which is indicated by exclusive; otherwise m.mu must be held
+// shared, and rename returns errRenameDir if oldname is a directory.
+func (m *MemMapFs) rename(oldname, newname string, exclusive bool) error {
+	oldDir, ok := m.lookup(m.paths().dir(oldname))
 	if !ok {
 		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: ErrFileNotFound}
 	}
+	newDir, ok := m.lookup(m.paths().dir(newname))
+	if !ok || !mem.GetFileInfo(newDir).IsDir() {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: ErrFileNotFound}
+	}
 
-	newParentDir := filepath.Dir(newname)
-	if _, ok := m.getData()[newParentDir]; !ok {
+	if oldDir == newDir {
+		oldDir.Lock()
+		defer oldDir.Unlock()
+	} else {
+		if !exclusive {
+			m.renameMu.Lock()
+			defer m.renameMu.Unlock()
+		}
+		first, second := oldDir, newDir
+		p := m.paths()
+		if parent := p.dir(newname); parent == p.separator ||
+			strings.HasPrefix(p.dir(oldname), parent+p.separator) {
+			// newDir is an ancestor of oldDir
+			first, second = newDir, oldDir
+		}
+		first.Lock()
+		defer first.Unlock()
+		second.Lock()
+		defer second.Unlock()
+	}
+	if mem.Unlinked(oldDir) || mem.Unlinked(newDir) {
 		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: ErrFileNotFound}
 	}
 
-	// proceed with rename. if newname exists, delete it
-	m.lockFreeRemoveAll(newname)
+	f, ok := mem.FindInDir(oldDir, m.paths().base(oldname))
+	if !ok {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: ErrFileNotFound}
+	}
+	isDir := mem.GetFileInfo(f).IsDir()
+	if isDir && !exclusive {
+		return errRenameDir
+	}
+	if target, ok := mem.FindInDir(newDir, m.paths().base(newname)); ok {
+		// oldDir is locked already, and a directory
+		if target == oldDir || mem.GetFileInfo(target).IsDir() {
+			return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: ErrFileExists}
+		}
+		mem.RemoveFromMemDir(newDir, target)
+	}
 
-	m.lockFreeRename(oldname, newname)
+	mem.RemoveFromMemDir(oldDir, f)
+	mem.ChangeFileName(f, newname)
+	if isDir {
+		m.renameEntries(f, newname)
+	}
+	mem.AddToMemDir(newDir, f)
 	return nil
 }
 
-func (m *MemMapFs) lockFreeRename(oldname, newname string) {
-	// 1. add file data to new map location
-	fileData, ok := m.getData()[oldname]
-	if !ok {
-		panic("File not found: " + oldname)
+// renameEntries renames everything below dir after dir has been renamed to
+// name. Entries are kept by base name, so no directory changes its entries.
+func (m *MemMapFs) renameEntries(dir *mem.FileData, name string) {
+	files, err := mem.ReadMemDir(dir)
+	if err != nil {
+		return
 	}
-	m.getData()[newname] = fileData
-
-	// 2. record children entries before rename
-	dir, err := mem.ReadMemDir(fileData)
-	if err != nil && !IsNotDir(err) {
-		panic(err)
+	names := make([]string, len(files))
+	for i, fi := range files {
+		names[i] = m.paths().join(name, fi.Name())
 	}
-
-	// 3. remove old parent directory's child entry
-	if err := m.unRegisterWithParent(oldname); err != nil {
-		panic(err)
+	// lock dir, whose sorted entries depend on their names
+	dir.Lock()
+	for i, fi := range files {
+		mem.ChangeFileName(fi.(*mem.FileInfo).FileData, names[i])
 	}
-
-	// 4. rename file itself to the new name
-	mem.ChangeFileName(fileData, newname)
-
-	// 5. add new parent directory's child entry
-	m.registerWithParent(fileData)
-
-	// 6. recurse into children, renaming each one
-	for _, f := range dir {
-		m.lockFreeRename(
-			filepath.Join(oldname, f.Name()),
-			filepath.Join(newname, f.Name()),
-		)
+	dir.Unlock()
+	for i, fi := range files {
+		m.renameEntries(fi.(*mem.FileInfo).FileData, names[i])
 	}
-
-	// 7. delete old file data from map
-	delete(m.getData(), oldname)
 }
 
 func (m *MemMapFs) Stat(name string) (os.FileInfo, error) {
-	f, err := m.Open(name)
+	f, err := m.open(name)
 	if err != nil {
 		return nil, err
 	}
-	fi := mem.GetFileInfo(f.(*mem.File).Data())
-	return fi, nil
+	return mem.GetFileInfo(f), nil
 }
 
 func (m *MemMapFs) Chmod(name string, mode os.FileMode) error {
-	name = normalizePath(name)
+	name = m.absPath(name)
 	mode &= chmodBits
 
 	m.mu.RLock()
-	f, ok := m.getData()[name]
+	f, ok := m.lookup(name)
 	m.mu.RUnlock()
 	if !ok {
 		return &os.PathError{Op: "chmod", Path: name, Err: ErrFileNotFound}
 	}
 	prevOtherBits := mem.GetFileInfo(f).Mode() & ^chmodBits
 
-	mode = prevOtherBits | mode
-	return m.unrestrictedChmod(name, mode)
+	mem.SetMode(f, prevOtherBits|mode)
+	return nil
 }
 
-func (m *MemMapFs) unrestrictedChmod(name string, mode os.FileMode) error {
-	name = normalizePath(name)
+func (m *MemMapFs) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	name = m.absPath(name)
 
 	m.mu.RLock()
-	f, ok := m.getData()[name]
+	f, ok := m.lookup(name)
 	m.mu.RUnlock()
 	if !ok {
-		return &os.PathError{Op: "chmod", Path: name, Err: ErrFileNotFound}
+		return &os.PathError{Op: "chtimes", Path: name, Err: ErrFileNotFound}
 	}
 
-	m.mu.Lock()
-	mem.SetMode(f, mode)
-	m.mu.Unlock()
+	mem.SetTimes(f, atime, mtime)
+	return nil
+}
 
+// CloneFile makes newname share the contents of oldname, copying them in
+// chunks only as either file is written to.
+func (m *MemMapFs) CloneFile(oldname, newname string) error {
+	oldname = m.absPath(oldname)
+	newname = m.absPath(newname)
+
+	src, err := m.open(oldname)
+	if err != nil {
+		return err
+	}
+	info := mem.GetFileInfo(src)
+	if info.IsDir() {
+		return &os.LinkError{Op: "clone", Old: oldname, New: newname, Err: ErrIsDir}
+	}
+	dst, _, err := m.create(newname, false, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if mem.GetFileInfo(dst).IsDir() {
+		return &os.LinkError{Op: "clone", Old: oldname, New: newname, Err: ErrIsDir}
+	}
+	if dst != src {
+		mem.CloneData(dst, src)
+	}
 	return nil
 }
 
-func (m *MemMapFs) Chtimes(name string, atime time.Time, mtime time.Time) error {
-	name = normalizePath(name)
+// LockIfPossible locks the named file within this MemMapFs. Locks belong to
+// the file, not its name, so they follow it through a Rename.
+func (m *MemMapFs) LockIfPossible(name string, typ LockType, wait bool) (Unlocker, error) {
+	name = m.absPath(name)
+	f, err := m.open(name)
+	if os.IsNotExist(err) {
+		var file File
+		if file, err = m.OpenFile(name, os.O_RDWR|os.O_CREATE, 0666); err == nil {
+			file.Close()
+			f, err = m.open(name)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !m.locks.lock(f, typ, wait) {
+		return nil, &os.PathError{Op: "lock", Path: name, Err: ErrLocked}
+	}
+	return &tableLock{table: &m.locks, key: f, typ: typ, name: name}, nil
+}
 
-	m.mu.RLock()
-	f, ok := m.getData()[name]
-	m.mu.RUnlock()
+func (m *MemMapFs) GetXAttr(name, attr string) ([]byte, error) {
+	f, err := m.open(name)
+	if err != nil {
+		return nil, err
+	}
+	value, ok := mem.GetXAttr(f, attr)
 	if !ok {
-		return &os.PathError{Op: "chtimes", Path: name, Err: ErrFileNotFound}
+		return nil, &os.PathError{Op: "getxattr", Path: m.absPath(name), Err: ENOATTR}
 	}
+	return value, nil
+}
+
+func (m *MemMapFs) SetXAttr(name, attr string, value []byte) error {
+	f, err := m.open(name)
+	if err != nil {
+		return err
+	}
+	mem.SetXAttr(f, attr, value)
+	return nil
+}
 
+func (m *MemMapFs) ListXAttrs(name string) ([]string, error) {
+	f, err := m.open(name)
+	if err != nil {
+		return nil, err
+	}
+	return mem.ListXAttrs(f), nil
+}
+
+func (m *MemMapFs) RemoveXAttr(name, attr string) error {
+	f, err := m.open(name)
+	if err != nil {
+		return err
+	}
+	if !mem.RemoveXAttr(f, attr) {
+		return &os.PathError{Op: "removexattr", Path: m.absPath(name), Err: ENOATTR}
+	}
+	return nil
+}
+
+// SetCapacity sets the total bytes and inodes reported by StatFS, from which
+// the free amounts are derived by subtracting what is in use. Zero means
+// unlimited, which is reported as math.MaxInt64. The capacity is not
+// enforced when writing.
+func (m *MemMapFs) SetCapacity(bytes, inodes uint64) {
 	m.mu.Lock()
-	mem.SetModTime(f, mtime)
+	m.capacityBytes, m.capacityInodes = bytes, inodes
 	m.mu.Unlock()
+}
 
-	return nil
+// StatFS reports the configured capacity and the bytes and inodes used by
+// all files and directories.
+func (m *MemMapFs) StatFS(name string) (*FsStats, error) {
+	if _, err := m.open(name); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var usedBytes, usedInodes uint64
+	walkTree(m.getRoot(), func(f *mem.FileData) {
+		if fi := mem.GetFileInfo(f); !fi.IsDir() {
+			usedBytes += uint64(fi.Size())
+		}
+		usedInodes++
+	})
+
+	stats := &FsStats{TotalBytes: m.capacityBytes, TotalInodes: m.capacityInodes}
+	if stats.TotalBytes == 0 {
+		stats.TotalBytes = math.MaxInt64
+	}
+	if stats.TotalInodes == 0 {
+		stats.TotalInodes = math.MaxInt64
+	}
+	if usedBytes < stats.TotalBytes {
+		stats.FreeBytes = stats.TotalBytes - usedBytes
+	}
+	if usedInodes < stats.TotalInodes {
+		stats.FreeInodes = stats.TotalInodes - usedInodes
+	}
+	stats.AvailableBytes = stats.FreeBytes
+	return stats, nil
 }
 
 func (m *MemMapFs) List() {
-	for _, x := range mem.DirMap(m.data).Files() {
+	walkTree(m.getRoot(), func(x *mem.FileData) {
 		y := mem.FileInfo{FileData: x}
 		fmt.Println(x.Name(), y.Size())
-	}
+	})
 }
 
 // func debugMemMapList(fs Fs) {
@@ -1,13 +1,18 @@
 package afero
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
 	"runtime"
+	"strings"
+	"sync"
 	"testing"
 	"time"
+
+	"github.com/spf13/afero/mem"
 )
 
 func TestNormalizePath(t *testing.T) {
@@ -964,3 +969,640 @@ func TestMemFsOpenFileTruncateReadOnly(t *testing.T) {
 		t.Error("Truncate on read-only settings should work. Actual size after truncate open:", info.Size())
 	}
 }
+
+func TestMemFsInodes(t *testing.T) {
+	fs := NewMemMapFs()
+	if err := fs.MkdirAll("/a/b", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Mkdir("/c", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteFile(fs, "/a/file", []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	stat := func(name string) *mem.Stat {
+		t.Helper()
+		fi, err := fs.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fi.Sys().(*mem.Stat)
+	}
+
+	// directories are linked from their parent, "." and each child's ".."
+	for name, nlink := range map[string]uint64{"/": 4, "/a": 3, "/a/b": 2, "/a/file": 1} {
+		if st := stat(name); st.Nlink != nlink {
+			t.Errorf("%s: expected %d links, got %d", name, nlink, st.Nlink)
+		}
+	}
+
+	file := stat("/a/file")
+	if file.Ino == stat("/a").Ino || file.Dev != stat("/a").Dev {
+		t.Errorf("expected a distinct inode on the same device, got %+v", file)
+	}
+	if file.Mode != 0100644 || file.Size != 4 || file.Blocks != 1 {
+		t.Errorf("unexpected stat %+v", file)
+	}
+	if err := fs.Rename("/a/file", "/c/moved"); err != nil {
+		t.Fatal(err)
+	}
+	moved := stat("/c/moved")
+	if moved.Ino != file.Ino || moved.Nlink != 1 {
+		t.Errorf("expected inode to survive rename, got %+v", moved)
+	}
+	if stat("/a").Nlink != 3 {
+		t.Errorf("rename of a file changed the directory link count")
+	}
+	if err := fs.Rename("/a/b", "/c/b"); err != nil {
+		t.Fatal(err)
+	}
+	if a, c := stat("/a").Nlink, stat("/c").Nlink; a != 2 || c != 3 {
+		t.Errorf("expected subdirectory links to move, got /a %d and /c %d", a, c)
+	}
+
+	other := NewMemMapFs()
+	other.Mkdir("/x", 0755)
+	if fi, _ := other.Stat("/x"); fi.Sys().(*mem.Stat).Dev == moved.Dev {
+		t.Error("expected different MemMapFs instances to have different devices")
+	}
+
+	f, err := fs.Open("/c/moved")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := fs.Remove("/c/moved"); err != nil {
+		t.Fatal(err)
+	}
+	fi, _ := f.Stat()
+	if nlink := fi.Sys().(*mem.Stat).Nlink; nlink != 0 {
+		t.Errorf("expected a removed file to have no links, got %d", nlink)
+	}
+}
+
+func TestSameFile(t *testing.T) {
+	fs := NewMemMapFs()
+	if err := WriteFile(fs, "/file", []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteFile(fs, "/other", []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	bp := NewBasePathFs(fs, "/")
+
+	fi1, _ := fs.Stat("/file")
+	fi2, _ := bp.Stat("/file")
+	fi3, _ := fs.Stat("/other")
+	if !SameFile(fi1, fi2) {
+		t.Error("expected the same file through a BasePathFs")
+	}
+	if SameFile(fi1, fi3) {
+		t.Error("expected different files")
+	}
+
+	osfs := NewOsFs()
+	dir := testDir(osfs)
+	defer removeAllTestFiles(t)
+	name := filepath.Join(dir, "file")
+	if err := WriteFile(osfs, name, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	fi1, _ = osfs.Stat(name)
+	fi2, _ = NewBasePathFs(osfs, dir).Stat("/file")
+	if !SameFile(fi1, fi2) {
+		t.Error("expected the same OsFs file through a BasePathFs")
+	}
+	if runtime.GOOS != "windows" {
+		fi2, _ = NewProfileFs(osfs, ProfileExt4).Stat(name)
+		if !SameFile(fi1, fi2) {
+			t.Error("expected the same OsFs file through a ProfileFs")
+		}
+	}
+	fi1, _ = fs.Stat("/file")
+	fi2, _ = NewProfileFs(bp, ProfileExt4).Stat("/file")
+	if SameFile(fi2, fi3) || !SameFile(fi1, fi2) {
+		t.Error("expected MemMapFs files to be told apart through a ProfileFs")
+	}
+	if SameFile(fi1, fi3) {
+		t.Error("expected OsFs and MemMapFs files to differ")
+	}
+}
+
+func TestMemFsTimes(t *testing.T) {
+	fs := &MemMapFs{}
+	if err := WriteFile(fs, "/file", []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	stat := func() *mem.Stat {
+		t.Helper()
+		fi, err := fs.Stat("/file")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fi.Sys().(*mem.Stat)
+	}
+	read := func() {
+		t.Helper()
+		if _, err := ReadFile(fs, "/file"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	created := stat().Btim
+	atime := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	mtime := time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC)
+	if err := fs.Chtimes("/file", atime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	st := stat()
+	if !st.Atim.Equal(atime) || !st.Mtim.Equal(mtime) {
+		t.Errorf("expected Chtimes to set both times, got %v and %v", st.Atim, st.Mtim)
+	}
+	if !st.Ctim.After(mtime) || !st.Btim.Equal(created) {
+		t.Errorf("expected Chtimes to change ctime only, got %v and %v", st.Ctim, st.Btim)
+	}
+
+	read()
+	if !stat().Atim.Equal(atime) {
+		t.Error("expected reads not to update the access time by default")
+	}
+
+	fs.SetAtimePolicy(mem.RelAtime)
+	read()
+	relatime := stat().Atim
+	if !relatime.After(st.Ctim) {
+		t.Errorf("expected an old access time to be updated, got %v", relatime)
+	}
+	read()
+	if !stat().Atim.Equal(relatime) {
+		t.Error("expected a recent access time not to be updated")
+	}
+
+	fs.SetAtimePolicy(mem.StrictAtime)
+	time.Sleep(time.Millisecond)
+	read()
+	if !stat().Atim.After(relatime) {
+		t.Error("expected every read to update the access time")
+	}
+
+	before := stat().Ctim
+	time.Sleep(time.Millisecond)
+	if err := fs.Chmod("/file", 0600); err != nil {
+		t.Fatal(err)
+	}
+	if st := stat(); !st.Ctim.After(before) || !st.Mtim.Equal(mtime) {
+		t.Errorf("expected Chmod to change ctime only, got %+v", st)
+	}
+}
+
+func TestMemFsClock(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := NewManualClock(start)
+	fs := &MemMapFs{}
+	fs.SetClock(clock)
+
+	if fi, err := fs.Stat("/"); err != nil || !fi.ModTime().Equal(start) {
+		t.Fatalf("expected the root to be created at %v, got %v", start, fi.ModTime())
+	}
+	f, err := fs.Create("/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(time.Minute)
+	f.WriteString("data")
+	clock.Advance(time.Minute)
+	f.Close()
+
+	fi, err := fs.Stat("/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := start.Add(2 * time.Minute); !fi.ModTime().Equal(expected) {
+		t.Errorf("expected modtime %v, got %v", expected, fi.ModTime())
+	}
+	if st := fi.Sys().(*mem.Stat); !st.Btim.Equal(start) {
+		t.Errorf("expected birth time %v, got %v", start, st.Btim)
+	}
+}
+
+func TestMemFsUnlinkWhileOpen(t *testing.T) {
+	fs := NewMemMapFs()
+	if err := WriteFile(fs, "/removed", []byte("removed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := fs.OpenFile("/removed", os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := fs.Remove("/removed"); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteFile(fs, "/removed", []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteAt([]byte("R"), 0); err != nil {
+		t.Fatalf("expected a removed file to stay writable, got %v", err)
+	}
+	buf := make([]byte, 7)
+	if _, err := f.ReadAt(buf, 0); err != nil || string(buf) != "Removed" {
+		t.Errorf("expected to read the removed file, got %q, %v", buf, err)
+	}
+	if data, _ := ReadFile(fs, "/removed"); string(data) != "new" {
+		t.Errorf("expected the new file to be unaffected, got %q", data)
+	}
+}
+
+func TestMemFsRenameWhileOpen(t *testing.T) {
+	fs := NewMemMapFs()
+	if err := WriteFile(fs, "/old", []byte("old target"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteFile(fs, "/new", []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	target, err := fs.Open("/old")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer target.Close()
+	source, err := fs.Open("/new")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer source.Close()
+
+	if err := fs.Rename("/new", "/old"); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := ReadAll(target); err != nil || string(data) != "old target" {
+		t.Errorf("expected the replaced target to keep its data, got %q, %v", data, err)
+	}
+	if fi, _ := target.Stat(); fi.Sys().(*mem.Stat).Nlink != 0 {
+		t.Error("expected the replaced target to have no links")
+	}
+	if source.Name() != "/new" {
+		t.Errorf("expected the renamed handle to keep its name, got %s", source.Name())
+	}
+	if data, err := ReadAll(source); err != nil || string(data) != "new" {
+		t.Errorf("expected the renamed file to keep its data, got %q, %v", data, err)
+	}
+}
+
+func TestMemFsConcurrentAppend(t *testing.T) {
+	const writers, lines = 20, 100
+	fs := NewMemMapFs()
+	if err := WriteFile(fs, "/log", nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			f, err := fs.OpenFile("/log", os.O_WRONLY|os.O_APPEND, 0)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer f.Close()
+			for i := 0; i < lines; i++ {
+				if _, err := fmt.Fprintf(f, "writer %d line %d\n", w, i); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	data, err := ReadFile(fs, "/log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := make(map[string]bool)
+	for _, line := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") {
+		var w, i int
+		if _, err := fmt.Sscanf(line, "writer %d line %d", &w, &i); err != nil {
+			t.Fatalf("garbled line %q", line)
+		}
+		seen[line] = true
+	}
+	if len(seen) != writers*lines {
+		t.Errorf("expected %d distinct lines, got %d", writers*lines, len(seen))
+	}
+
+	f, err := fs.OpenFile("/log", os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteAt([]byte("x"), 0); err == nil {
+		t.Error("expected WriteAt to fail in append mode")
+	}
+}
+
+func TestMemFsRenameTree(t *testing.T) {
+	fs := NewMemMapFs()
+	for _, name := range []string{"/a/b/c/file", "/a/b/other", "/a/top"} {
+		if err := fs.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := WriteFile(fs, name, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := fs.Mkdir("/x", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Rename("/a/b", "/x/y"); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, expected := range map[string]string{"/x/y/c/file": "/a/b/c/file", "/x/y/other": "/a/b/other", "/a/top": "/a/top"} {
+		if data, err := ReadFile(fs, name); err != nil || string(data) != expected {
+			t.Errorf("%s: expected %q, got %q, %v", name, expected, data, err)
+		}
+	}
+	if _, err := fs.Stat("/a/b/c"); !os.IsNotExist(err) {
+		t.Errorf("expected old names to be gone, got %v", err)
+	}
+	names, err := readDirNames(fs, "/x/y")
+	if err != nil || strings.Join(names, ",") != "c,other" {
+		t.Errorf("expected the moved directory to list c and other, got %v, %v", names, err)
+	}
+}
+
+// BenchmarkMemMapFsRenameDir renames a small directory in a large tree,
+// which only has to visit the directory.
+func BenchmarkMemMapFsRenameDir(b *testing.B) {
+	fs := NewMemMapFs()
+	for i := 0; i < 100; i++ {
+		dir := fmt.Sprintf("/big/%d", i)
+		fs.MkdirAll(dir, 0755)
+		for j := 0; j < 1000; j++ {
+			fs.Create(fmt.Sprintf("%s/%d", dir, j))
+		}
+	}
+	fs.MkdirAll("/small/dir", 0755)
+	fs.Create("/small/dir/file")
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := fs.Rename("/small/dir", "/small/renamed"); err != nil {
+			b.Fatal(err)
+		}
+		if err := fs.Rename("/small/renamed", "/small/dir"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkMemMapFsReaddir(b *testing.B) {
+	fs := NewMemMapFs()
+	fs.Mkdir("/dir", 0755)
+	for i := 0; i < 10000; i++ {
+		fs.Create(fmt.Sprintf("/dir/%d", i))
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		f, err := fs.Open("/dir")
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, err := f.Readdir(100); err != nil {
+			b.Fatal(err)
+		}
+		f.Close()
+	}
+}
+
+func TestMemFsRemoveAllTree(t *testing.T) {
+	fs := NewMemMapFs()
+	if err := fs.MkdirAll("/a/b/c", 0755); err != nil {
+		t.Fatal(err)
+	}
+	f, err := fs.Create("/a/b/c/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := fs.RemoveAll("/a/b"); err != nil {
+		t.Fatal(err)
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nlink := fi.Sys().(*mem.Stat).Nlink; nlink != 0 {
+		t.Errorf("expected a removed file to have no links, got %d", nlink)
+	}
+	for _, name := range []string{"/a/b", "/a/b/c", "/a/b/c/file"} {
+		if _, err := fs.Stat(name); !os.IsNotExist(err) {
+			t.Errorf("%s: expected not to exist, got %v", name, err)
+		}
+	}
+	if err := fs.MkdirAll("/a/b/c", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if names, err := readDirNames(fs, "/a/b/c"); err != nil || len(names) != 0 {
+		t.Errorf("expected a recreated directory to be empty, got %v, %v", names, err)
+	}
+}
+
+// TestMemFsParallel works on many directories at once, moving files and
+// directories between them, and then checks that the tree is consistent.
+// Run it with -race.
+func TestMemFsParallel(t *testing.T) {
+	fs := NewMemMapFs()
+	iterations := 200
+	if testing.Short() {
+		iterations = 20
+	}
+	if err := fs.Mkdir("/shared", 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, memFsParallelWorkers)
+	for w := 0; w < memFsParallelWorkers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			errs <- memFsParallelWorker(fs, w, iterations)
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+
+	err := Walk(fs, "/", func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if name := info.(*mem.FileInfo).FileData.Name(); name != path {
+			t.Errorf("%s: file is named %s", path, name)
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		names, err := readDirNames(fs, path)
+		if err != nil {
+			return err
+		}
+		subdirs := 0
+		for _, name := range names {
+			if fi, err := fs.Stat(filepath.Join(path, name)); err == nil && fi.IsDir() {
+				subdirs++
+			}
+		}
+		if nlink := info.Sys().(*mem.Stat).Nlink; nlink != uint64(2+subdirs) {
+			t.Errorf("%s: expected %d links, got %d", path, 2+subdirs, nlink)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+const memFsParallelWorkers = 8
+
+func memFsParallelWorker(fs Fs, w, iterations int) error {
+	base := fmt.Sprintf("/w%d", w)
+	for i := 0; i < iterations; i++ {
+		dir := fmt.Sprintf("%s/%d/sub", base, i%5)
+		if err := fs.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+		name := fmt.Sprintf("%s/f%d", dir, i)
+		if err := WriteFile(fs, name, []byte(name), 0644); err != nil {
+			return err
+		}
+		if data, err := ReadFile(fs, name); err != nil || string(data) != name {
+			return fmt.Errorf("%s: read %q, %v", name, data, err)
+		}
+		if _, err := ReadDir(fs, dir); err != nil {
+			return err
+		}
+
+		// move files between this worker's directories and the shared one,
+		// and whole directories around
+		switch i % 4 {
+		case 0:
+			if err := fs.Rename(name, fmt.Sprintf("/shared/w%d-%d", w, i)); err != nil {
+				return err
+			}
+		case 1:
+			if err := fs.Rename(name, fmt.Sprintf("%s/f%d", base, i)); err != nil {
+				return err
+			}
+		case 2:
+			if err := fs.Rename(fmt.Sprintf("%s/%d", base, i%5), fmt.Sprintf("%s/moved%d", base, i)); err != nil {
+				return err
+			}
+		case 3:
+			if err := fs.RemoveAll(fmt.Sprintf("%s/%d", base, i%5)); err != nil {
+				return err
+			}
+			if err := fs.Remove(fmt.Sprintf("/shared/w%d-%d", w, i-3)); err != nil {
+				return err
+			}
+		}
+
+		// and look at and remove what other workers are doing
+		other := (w + 1) % memFsParallelWorkers
+		fs.Stat(fmt.Sprintf("/w%d/%d/sub", other, i%5))
+		fs.RemoveAll(fmt.Sprintf("/w%d/moved%d", other, i-4))
+		if _, err := ReadDir(fs, "/shared"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// BenchmarkMemMapFsParallelCreate creates files in a directory per goroutine.
+func BenchmarkMemMapFsParallelCreate(b *testing.B) {
+	fs := NewMemMapFs()
+	var next int64
+	var mu sync.Mutex
+	b.RunParallel(func(pb *testing.PB) {
+		mu.Lock()
+		next++
+		dir := fmt.Sprintf("/%d", next)
+		mu.Unlock()
+		if err := fs.Mkdir(dir, 0755); err != nil {
+			b.Fatal(err)
+		}
+		for i := 0; pb.Next(); i++ {
+			f, err := fs.Create(fmt.Sprintf("%s/%d", dir, i%1000))
+			if err != nil {
+				b.Fatal(err)
+			}
+			f.Close()
+		}
+	})
+}
+
+func TestMemFsBlobPool(t *testing.T) {
+	const size = 200000 // three chunks and a bit
+	data := bytes.Repeat([]byte("0123456789"), size/10)
+	pool := mem.NewBlobPool()
+	fs1, fs2 := NewMemMapFs(), NewMemMapFs()
+	if err := WriteFile(fs1, "/a", data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	// existing files are added when setting the pool
+	fs1.(*MemMapFs).SetBlobPool(pool)
+	fs2.(*MemMapFs).SetBlobPool(pool)
+	for _, f := range []struct {
+		fs   Fs
+		name string
+	}{{fs1, "/b"}, {fs2, "/a"}} {
+		if err := WriteFile(f.fs, f.name, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if stats := pool.Stats(); stats.Blobs != 4 || stats.PhysicalBytes != size || stats.LogicalBytes != 3*size {
+		t.Errorf("expected 4 blobs of %d bytes holding %d, got %+v", size, 3*size, stats)
+	}
+
+	// writing splits off the chunk written to
+	f, err := fs2.OpenFile("/a", os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteAt([]byte("x"), 1); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if stats := pool.Stats(); stats.Blobs != 5 || stats.PhysicalBytes != size+64<<10 || stats.LogicalBytes != 3*size {
+		t.Errorf("expected the chunk written to to be stored separately, got %+v", stats)
+	}
+	if got, err := ReadFile(fs1, "/a"); err != nil || !bytes.Equal(got, data) {
+		t.Errorf("expected other files to be unchanged, got %v", err)
+	}
+	if got, err := ReadFile(fs2, "/a"); err != nil || string(got[:3]) != "0x2" || !bytes.Equal(got[3:], data[3:]) {
+		t.Errorf("expected the file written to to change, got %v", err)
+	}
+
+	// removed files release their contents once collected
+	fs1.Remove("/a")
+	fs1.Remove("/b")
+	for i := 0; i < 100 && pool.Stats().LogicalBytes != size; i++ {
+		runtime.GC()
+		time.Sleep(10 * time.Millisecond)
+	}
+	if stats := pool.Stats(); stats.Blobs != 4 || stats.PhysicalBytes != size || stats.LogicalBytes != size {
+		t.Errorf("expected only the contents of the remaining file, got %+v", stats)
+	}
+	runtime.KeepAlive(fs2)
+}
@@ -20,6 +20,11 @@ import (
 )
 
 var _ Lstater = (*OsFs)(nil)
+var _ Locker = (*OsFs)(nil)
+var _ XAttr = (*OsFs)(nil)
+var _ StatFS = (*OsFs)(nil)
+var _ Cloner = (*OsFs)(nil)
+var _ Chdirer = (*OsFs)(nil)
 
 // OsFs is a Fs implementation that uses functions provided by the os package.
 //
@@ -107,3 +112,44 @@ func (OsFs) SymlinkIfPossible(oldname, newname string) error {
 func (OsFs) ReadlinkIfPossible(name string) (string, error) {
 	return os.Readlink(name)
 }
+
+func (OsFs) LockIfPossible(name string, typ LockType, wait bool) (Unlocker, error) {
+	return lockOsFile(name, typ, wait)
+}
+
+func (OsFs) GetXAttr(name, attr string) ([]byte, error) {
+	return getXAttr(name, attr)
+}
+
+func (OsFs) SetXAttr(name, attr string, value []byte) error {
+	return setXAttr(name, attr, value)
+}
+
+func (OsFs) ListXAttrs(name string) ([]string, error) {
+	return listXAttrs(name)
+}
+
+func (OsFs) RemoveXAttr(name, attr string) error {
+	return removeXAttr(name, attr)
+}
+
+func (OsFs) StatFS(name string) (*FsStats, error) {
+	return statFS(name)
+}
+
+func (OsFs) Getwd() (string, error) {
+	return os.Getwd()
+}
+
+// Chdir changes the working directory of the process, like os.Chdir. Use a
+// WorkingDirFs to change it for one file system only.
+func (OsFs) Chdir(dir string) error {
+	return os.Chdir(dir)
+}
+
+// CloneFile clones oldname using reflinks on Linux filesystems supporting
+// them, falling back to copy_file_range(2). It fails with ErrNoClone on
+// other systems.
+func (OsFs) CloneFile(oldname, newname string) error {
+	return cloneOsFile(oldname, newname)
+}
@@ -59,7 +59,7 @@ func walk(fs Fs, path string, info os.FileInfo, walkFn filepath.WalkFunc) error
 	}
 
 	for _, name := range names {
-		filename := filepath.Join(path, name)
+		filename := pathsOf(fs).join(path, name)
 		fileInfo, err := lstatIfPossible(fs, filename)
 		if err != nil {
 			if err := walkFn(filename, fileInfo, err); err != nil && err != filepath.SkipDir {
@@ -91,7 +91,8 @@ func lstatIfPossible(fs Fs, path string) (os.FileInfo, error) {
 // and directories are filtered by walkFn. The files are walked in lexical
 // order, which makes the output deterministic but means that for very
 // large directories Walk can be inefficient.
-// Walk does not follow symbolic links.
+// Walk does not follow symbolic links. It joins paths with package path on
+// filesystems using slash paths.
 
 func (a Afero) Walk(root string, walkFn filepath.WalkFunc) error {
 	return Walk(a.Fs, root, walkFn)
@@ -0,0 +1,415 @@
+package afero
+
+import (
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+	"unicode/utf16"
+)
+
+// FsProfile describes the limits of an on-disk file system format, which a
+// ProfileFs enforces. Zero values mean no limit.
+type FsProfile struct {
+	Name string
+
+	// MaxNameLength is the length of the longest name, counted in bytes,
+	// or in UTF-16 code units if NameUTF16 is set.
+	MaxNameLength int
+	NameUTF16     bool
+
+	// MaxPathLength is the length of the longest path, counted like names.
+	MaxPathLength int
+
+	// WindowsNames forbids names that Windows rejects: names containing
+	// any of "*/:<>?\| or control characters, and the reserved device
+	// names CON, PRN, AUX, NUL, COM1 to COM9 and LPT1 to LPT9.
+	WindowsNames bool
+
+	// InvalidChars lists further characters names cannot contain, besides
+	// NUL, which no name can contain.
+	InvalidChars string
+
+	// ModTimeGranularity and AtimeGranularity are the precision of the
+	// modification and access times stored.
+	ModTimeGranularity time.Duration
+	AtimeGranularity   time.Duration
+
+	// MaxFileSize is the size of the largest file.
+	MaxFileSize int64
+}
+
+// Profiles of common file system formats. The path limits of FAT32, exFAT
+// and NTFS are that of Windows, MAX_PATH, and those of HFS+ and ext4 that of
+// macOS and Linux, PATH_MAX, less their terminating NUL.
+var (
+	ProfileFAT32 = FsProfile{
+		Name:               "FAT32",
+		MaxNameLength:      255,
+		NameUTF16:          true,
+		MaxPathLength:      259,
+		WindowsNames:       true,
+		ModTimeGranularity: 2 * time.Second,
+		AtimeGranularity:   24 * time.Hour,
+		MaxFileSize:        1<<32 - 1,
+	}
+	ProfileExFAT = FsProfile{
+		Name:               "exFAT",
+		MaxNameLength:      255,
+		NameUTF16:          true,
+		MaxPathLength:      259,
+		WindowsNames:       true,
+		ModTimeGranularity: 10 * time.Millisecond,
+		AtimeGranularity:   2 * time.Second,
+	}
+	ProfileNTFS = FsProfile{
+		Name:               "NTFS",
+		MaxNameLength:      255,
+		NameUTF16:          true,
+		MaxPathLength:      259,
+		WindowsNames:       true,
+		ModTimeGranularity: 100 * time.Nanosecond,
+		AtimeGranularity:   100 * time.Nanosecond,
+		MaxFileSize:        1<<44 - 1<<16,
+	}
+	ProfileHFSPlus = FsProfile{
+		Name:               "HFS+",
+		MaxNameLength:      255,
+		NameUTF16:          true,
+		MaxPathLength:      1023,
+		ModTimeGranularity: time.Second,
+		AtimeGranularity:   time.Second,
+	}
+	ProfileExt4 = FsProfile{
+		Name:          "ext4",
+		MaxNameLength: 255,
+		MaxPathLength: 4095,
+		MaxFileSize:   1 << 44,
+	}
+)
+
+var _ Lstater = (*ProfileFs)(nil)
+var _ Symlinker = (*ProfileFs)(nil)
+var _ SlashPather = (*ProfileFs)(nil)
+
+// ProfileFs makes its source behave like a file system of a given format, to
+// find out in tests what would break when files end up on a FAT drive, a
+// Windows share or a Mac. It enforces the limits of its FsProfile with the
+// errors Linux reports on such file systems:
+//
+//   - creating or renaming to a name that is too long, or opening a path
+//     that is too long, fails with ENAMETOOLONG
+//   - creating or renaming to a name with invalid characters fails with
+//     EINVAL
+//   - writing past the largest file size writes what fits and fails with
+//     EFBIG, as does truncating a file beyond it
+//   - Chtimes, Stat and Readdir truncate times to the stored precision
+//
+// Names keep their case; wrap a WindowsFs to also make them case-insensitive.
+type ProfileFs struct {
+	source  Fs
+	profile FsProfile
+}
+
+func NewProfileFs(source Fs, profile FsProfile) *ProfileFs {
+	return &ProfileFs{source: source, profile: profile}
+}
+
+// Profile returns the profile p enforces.
+func (p *ProfileFs) Profile() FsProfile {
+	return p.profile
+}
+
+// length returns the length of s as counted by the profile.
+func (p *ProfileFs) length(s string) int {
+	if p.profile.NameUTF16 {
+		return len(utf16.Encode([]rune(s)))
+	}
+	return len(s)
+}
+
+// checkPath returns the error opening name fails with, if any. The length
+// counted is that of the cleaned path, as the kernel resolves "." and ".."
+// before measuring it.
+func (p *ProfileFs) checkPath(name string) error {
+	if p.profile.WindowsNames {
+		name = path.Clean(strings.Replace(name, `\`, "/", -1))
+	} else {
+		name = pathsOf(p.source).clean(name)
+	}
+	if p.profile.MaxPathLength > 0 && p.length(name) > p.profile.MaxPathLength {
+		return syscall.ENAMETOOLONG
+	}
+	return nil
+}
+
+// checkNames returns the error creating name fails with, if any.
+func (p *ProfileFs) checkNames(name string) error {
+	if err := p.checkPath(name); err != nil {
+		return err
+	}
+	separator := pathsOf(p.source).separator
+	if p.profile.WindowsNames {
+		// both separators, as on Windows, on any source
+		name = strings.TrimPrefix(strings.Replace(name, `\`, "/", -1), "//?/")
+		if len(name) >= 2 && name[1] == ':' && isDriveLetter(name[0]) {
+			name = name[2:]
+		}
+		separator = "/"
+	} else if separator == FilePathSeparator {
+		name = name[len(filepath.VolumeName(name)):]
+	}
+	for _, elem := range strings.Split(name, separator) {
+		if elem == "" || elem == "." || elem == ".." {
+			continue
+		}
+		if p.profile.MaxNameLength > 0 && p.length(elem) > p.profile.MaxNameLength {
+			return syscall.ENAMETOOLONG
+		}
+		if strings.ContainsRune(elem, 0) || strings.ContainsAny(elem, p.profile.InvalidChars) ||
+			p.profile.WindowsNames && !validWindowsName(elem) {
+			return syscall.EINVAL
+		}
+	}
+	return nil
+}
+
+// truncateTime truncates t to the precision d of the profile.
+func truncateTime(t time.Time, d time.Duration) time.Time {
+	if d <= time.Nanosecond {
+		return t
+	}
+	return t.Truncate(d)
+}
+
+func (p *ProfileFs) Create(name string) (File, error) {
+	return p.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (p *ProfileFs) Mkdir(name string, perm os.FileMode) error {
+	if err := p.checkNames(name); err != nil {
+		return &os.PathError{Op: "mkdir", Path: name, Err: err}
+	}
+	return p.source.Mkdir(name, perm)
+}
+
+func (p *ProfileFs) MkdirAll(path string, perm os.FileMode) error {
+	if err := p.checkNames(path); err != nil {
+		return &os.PathError{Op: "mkdir", Path: path, Err: err}
+	}
+	return p.source.MkdirAll(path, perm)
+}
+
+func (p *ProfileFs) Open(name string) (File, error) {
+	return p.OpenFile(name, os.O_RDONLY, 0)
+}
+
+func (p *ProfileFs) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	check := p.checkPath
+	if flag&os.O_CREATE != 0 {
+		check = p.checkNames
+	}
+	if err := check(name); err != nil {
+		return nil, &os.PathError{Op: "open", Path: name, Err: err}
+	}
+	f, err := p.source.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &profileFile{File: f, fs: p, append: flag&os.O_APPEND != 0}, nil
+}
+
+func (p *ProfileFs) Remove(name string) error {
+	if err := p.checkPath(name); err != nil {
+		return &os.PathError{Op: "remove", Path: name, Err: err}
+	}
+	return p.source.Remove(name)
+}
+
+func (p *ProfileFs) RemoveAll(path string) error {
+	if err := p.checkPath(path); err != nil {
+		return &os.PathError{Op: "remove", Path: path, Err: err}
+	}
+	return p.source.RemoveAll(path)
+}
+
+func (p *ProfileFs) Rename(oldname, newname string) error {
+	err := p.checkPath(oldname)
+	if err == nil {
+		err = p.checkNames(newname)
+	}
+	if err != nil {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: err}
+	}
+	return p.source.Rename(oldname, newname)
+}
+
+func (p *ProfileFs) Stat(name string) (os.FileInfo, error) {
+	if err := p.checkPath(name); err != nil {
+		return nil, &os.PathError{Op: "stat", Path: name, Err: err}
+	}
+	fi, err := p.source.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	return profileFileInfo{fi, p.profile.ModTimeGranularity}, nil
+}
+
+func (p *ProfileFs) LstatIfPossible(name string) (os.FileInfo, bool, error) {
+	if err := p.checkPath(name); err != nil {
+		return nil, false, &os.PathError{Op: "lstat", Path: name, Err: err}
+	}
+	if lsf, ok := p.source.(Lstater); ok {
+		fi, lstatCalled, err := lsf.LstatIfPossible(name)
+		if err != nil {
+			return nil, lstatCalled, err
+		}
+		return profileFileInfo{fi, p.profile.ModTimeGranularity}, lstatCalled, nil
+	}
+	fi, err := p.Stat(name)
+	return fi, false, err
+}
+
+// SymlinkIfPossible creates newname pointing to oldname in the source, if
+// newname is a valid name for the profile. The target is stored as given.
+func (p *ProfileFs) SymlinkIfPossible(oldname, newname string) error {
+	if err := p.checkNames(newname); err != nil {
+		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: err}
+	}
+	if linker, ok := p.source.(Linker); ok {
+		return linker.SymlinkIfPossible(oldname, newname)
+	}
+	return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: ErrNoSymlink}
+}
+
+func (p *ProfileFs) ReadlinkIfPossible(name string) (string, error) {
+	if err := p.checkPath(name); err != nil {
+		return "", &os.PathError{Op: "readlink", Path: name, Err: err}
+	}
+	if reader, ok := p.source.(LinkReader); ok {
+		return reader.ReadlinkIfPossible(name)
+	}
+	return "", &os.PathError{Op: "readlink", Path: name, Err: ErrNoReadlink}
+}
+
+func (p *ProfileFs) Name() string {
+	return "ProfileFs"
+}
+
+func (p *ProfileFs) SlashPaths() bool {
+	return UsesSlashPaths(p.source)
+}
+
+func (p *ProfileFs) Chmod(name string, mode os.FileMode) error {
+	if err := p.checkPath(name); err != nil {
+		return &os.PathError{Op: "chmod", Path: name, Err: err}
+	}
+	return p.source.Chmod(name, mode)
+}
+
+// Chtimes sets the times of name, truncated to the precision of the profile.
+func (p *ProfileFs) Chtimes(name string, atime, mtime time.Time) error {
+	if err := p.checkPath(name); err != nil {
+		return &os.PathError{Op: "chtimes", Path: name, Err: err}
+	}
+	atime = truncateTime(atime, p.profile.AtimeGranularity)
+	mtime = truncateTime(mtime, p.profile.ModTimeGranularity)
+	return p.source.Chtimes(name, atime, mtime)
+}
+
+type profileFile struct {
+	File
+	fs     *ProfileFs
+	append bool
+}
+
+// fit returns how many of n bytes written at off fit in the largest file,
+// and the error writing them fails with if not all of them do.
+func (f *profileFile) fit(op string, off int64, n int) (int, error) {
+	max := f.fs.profile.MaxFileSize
+	if max <= 0 || off+int64(n) <= max {
+		return n, nil
+	}
+	err := &os.PathError{Op: op, Path: f.Name(), Err: syscall.EFBIG}
+	if off >= max {
+		return 0, err
+	}
+	return int(max - off), err
+}
+
+func (f *profileFile) Write(b []byte) (int, error) {
+	var off int64
+	var err error
+	if f.append {
+		var fi os.FileInfo
+		if fi, err = f.File.Stat(); err == nil {
+			off = fi.Size()
+		}
+	} else {
+		off, err = f.File.Seek(0, io.SeekCurrent)
+	}
+	if err != nil {
+		return 0, err
+	}
+	n, errFit := f.fit("write", off, len(b))
+	if n == 0 && errFit != nil {
+		return 0, errFit
+	}
+	n, err = f.File.Write(b[:n])
+	if err == nil {
+		err = errFit
+	}
+	return n, err
+}
+
+func (f *profileFile) WriteAt(b []byte, off int64) (int, error) {
+	n, errFit := f.fit("write", off, len(b))
+	if n == 0 && errFit != nil {
+		return 0, errFit
+	}
+	n, err := f.File.WriteAt(b[:n], off)
+	if err == nil {
+		err = errFit
+	}
+	return n, err
+}
+
+func (f *profileFile) WriteString(s string) (int, error) {
+	return f.Write([]byte(s))
+}
+
+func (f *profileFile) Truncate(size int64) error {
+	if max := f.fs.profile.MaxFileSize; max > 0 && size > max {
+		return &os.PathError{Op: "truncate", Path: f.Name(), Err: syscall.EFBIG}
+	}
+	return f.File.Truncate(size)
+}
+
+func (f *profileFile) Stat() (os.FileInfo, error) {
+	fi, err := f.File.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return profileFileInfo{fi, f.fs.profile.ModTimeGranularity}, nil
+}
+
+func (f *profileFile) Readdir(count int) ([]os.FileInfo, error) {
+	fis, err := f.File.Readdir(count)
+	for i := range fis {
+		fis[i] = profileFileInfo{fis[i], f.fs.profile.ModTimeGranularity}
+	}
+	return fis, err
+}
+
+// profileFileInfo reports the modification time with the precision stored.
+type profileFileInfo struct {
+	os.FileInfo
+	granularity time.Duration
+}
+
+func (fi profileFileInfo) ModTime() time.Time {
+	return truncateTime(fi.FileInfo.ModTime(), fi.granularity)
+}
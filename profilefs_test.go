@@ -0,0 +1,206 @@
+package afero
+
+import (
+	"os"
+	"runtime"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero/mem"
+)
+
+func profileErrno(err error) error {
+	switch err := err.(type) {
+	case *os.PathError:
+		return err.Err
+	case *os.LinkError:
+		return err.Err
+	}
+	return err
+}
+
+func TestProfileFsNames(t *testing.T) {
+	tests := []struct {
+		profile FsProfile
+		name    string
+		err     error
+	}{
+		{ProfileFAT32, "/" + strings.Repeat("a", 255), nil},
+		{ProfileFAT32, "/" + strings.Repeat("a", 256), syscall.ENAMETOOLONG},
+		{ProfileFAT32, "/" + strings.Repeat("é", 255), nil},
+		{ProfileExt4, "/" + strings.Repeat("é", 128), syscall.ENAMETOOLONG},
+		{ProfileExt4, "/" + strings.Repeat("é", 127), nil},
+		{ProfileNTFS, strings.Repeat("/dir", 65), syscall.ENAMETOOLONG},
+		{ProfileExt4, strings.Repeat("/dir", 65), nil},
+		{ProfileFAT32, "/a:b", syscall.EINVAL},
+		{ProfileExFAT, "/what?", syscall.EINVAL},
+		{ProfileNTFS, "/dir/con.txt", syscall.EINVAL},
+		{ProfileNTFS, `/back\slash`, nil},
+		{ProfileNTFS, "/a|b", syscall.EINVAL},
+		{ProfileHFSPlus, "/a:b?", nil},
+		{ProfileExt4, "/con", nil},
+		{ProfileExt4, "/nul\x00", syscall.EINVAL},
+	}
+	for _, test := range tests {
+		fs := NewProfileFs(NewMemMapFs(), test.profile)
+		if err := fs.MkdirAll(test.name, 0755); profileErrno(err) != test.err {
+			t.Errorf("%s: MkdirAll(%.20q...) expected %v, got %v", test.profile.Name, test.name, test.err, err)
+		}
+	}
+
+	fs := NewProfileFs(NewMemMapFs(), ProfileFAT32)
+	if err := WriteFile(fs, "/file", []byte("file"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Rename("/file", "/file?"); profileErrno(err) != syscall.EINVAL {
+		t.Errorf("expected EINVAL renaming to an invalid name, got %v", err)
+	}
+	if _, err := fs.OpenFile("/aux", os.O_RDWR|os.O_CREATE, 0644); profileErrno(err) != syscall.EINVAL {
+		t.Errorf("expected EINVAL creating a reserved name, got %v", err)
+	}
+	if _, err := fs.Stat("/" + strings.Repeat("a", 300)); profileErrno(err) != syscall.ENAMETOOLONG {
+		t.Errorf("expected ENAMETOOLONG looking up a long path, got %v", err)
+	}
+
+	w := NewProfileFs(NewWindowsMemMapFs(), ProfileNTFS)
+	if err := w.MkdirAll(`C:\Users\Gopher`, 0755); err != nil {
+		t.Errorf("expected Windows paths to be valid, got %v", err)
+	}
+	if err := w.Mkdir(`C:\Users\a<b`, 0755); profileErrno(err) != syscall.EINVAL {
+		t.Errorf("expected EINVAL on a Windows path, got %v", err)
+	}
+}
+
+func TestProfileFsTimes(t *testing.T) {
+	mtime := time.Date(2020, 5, 17, 13, 45, 27, 987654321, time.UTC)
+	tests := []struct {
+		profile      FsProfile
+		atime, mtime time.Time
+	}{
+		{ProfileFAT32, time.Date(2020, 5, 17, 0, 0, 0, 0, time.UTC), time.Date(2020, 5, 17, 13, 45, 26, 0, time.UTC)},
+		{ProfileExFAT, time.Date(2020, 5, 17, 13, 45, 26, 0, time.UTC), time.Date(2020, 5, 17, 13, 45, 27, 980000000, time.UTC)},
+		{ProfileNTFS, time.Date(2020, 5, 17, 13, 45, 27, 987654300, time.UTC), time.Date(2020, 5, 17, 13, 45, 27, 987654300, time.UTC)},
+		{ProfileHFSPlus, time.Date(2020, 5, 17, 13, 45, 27, 0, time.UTC), time.Date(2020, 5, 17, 13, 45, 27, 0, time.UTC)},
+		{ProfileExt4, mtime, mtime},
+	}
+	for _, test := range tests {
+		source := NewMemMapFs()
+		fs := NewProfileFs(source, test.profile)
+		if err := WriteFile(fs, "/file", []byte("file"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := fs.Chtimes("/file", mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+		fi, err := source.Stat("/file")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !fi.ModTime().Equal(test.mtime) {
+			t.Errorf("%s: expected mtime %v, got %v", test.profile.Name, test.mtime, fi.ModTime())
+		}
+		if atime := fi.Sys().(*mem.Stat).Atim; !atime.Equal(test.atime) {
+			t.Errorf("%s: expected atime %v, got %v", test.profile.Name, test.atime, atime)
+		}
+	}
+
+	source := NewMemMapFs()
+	fs := NewProfileFs(source, ProfileFAT32)
+	if err := WriteFile(fs, "/file", []byte("file"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := source.Chtimes("/file", mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := fs.Stat("/file"); err != nil || fi.ModTime().Nanosecond() != 0 || fi.ModTime().Second()%2 != 0 {
+		t.Errorf("expected Stat to truncate the mtime to 2s, got %v, %v", fi.ModTime(), err)
+	}
+	if fis, err := ReadDir(fs, "/"); err != nil || len(fis) != 1 || fis[0].ModTime().Second()%2 != 0 {
+		t.Errorf("expected Readdir to truncate the mtime to 2s, got %v", err)
+	}
+}
+
+func TestProfileFsFileSize(t *testing.T) {
+	profile := ProfileExt4
+	profile.MaxFileSize = 10
+	fs := NewProfileFs(NewMemMapFs(), profile)
+	f, err := fs.Create("/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if n, err := f.WriteString("12345678"); n != 8 || err != nil {
+		t.Fatalf("expected to write 8 bytes, got %d, %v", n, err)
+	}
+	if n, err := f.Write([]byte("9abc")); n != 2 || profileErrno(err) != syscall.EFBIG {
+		t.Errorf("expected to write 2 bytes and fail with EFBIG, got %d, %v", n, err)
+	}
+	if n, err := f.Write([]byte("d")); n != 0 || profileErrno(err) != syscall.EFBIG {
+		t.Errorf("expected EFBIG writing at the limit, got %d, %v", n, err)
+	}
+	if n, err := f.WriteAt([]byte("xyz"), 8); n != 2 || profileErrno(err) != syscall.EFBIG {
+		t.Errorf("expected WriteAt to write 2 bytes and fail with EFBIG, got %d, %v", n, err)
+	}
+	if err := f.Truncate(11); profileErrno(err) != syscall.EFBIG {
+		t.Errorf("expected EFBIG truncating beyond the limit, got %v", err)
+	}
+	if data, err := ReadFile(fs, "/file"); err != nil || string(data) != "12345678xy" {
+		t.Errorf("expected 12345678xy, got %q, %v", data, err)
+	}
+
+	a, err := fs.OpenFile("/file", os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	if n, err := a.Write([]byte("z")); n != 0 || profileErrno(err) != syscall.EFBIG {
+		t.Errorf("expected EFBIG appending to a full file, got %d, %v", n, err)
+	}
+
+	if ProfileFAT32.MaxFileSize != 1<<32-1 {
+		t.Errorf("expected FAT32 files to be limited to 4GB, got %d", ProfileFAT32.MaxFileSize)
+	}
+}
+
+func TestProfileFsCleanPath(t *testing.T) {
+	profile := ProfileExt4
+	profile.MaxPathLength = 16
+	fs := NewProfileFs(NewMemMapFs(), profile)
+	if err := fs.MkdirAll("/dir/sub", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Stat("/dir/" + strings.Repeat("sub/../", 8) + "sub"); err != nil {
+		t.Errorf("expected the cleaned path to count, got %v", err)
+	}
+	if _, err := fs.Stat("/dir/sub/" + strings.Repeat("x", 8)); profileErrno(err) != syscall.ENAMETOOLONG {
+		t.Errorf("expected ENAMETOOLONG, got %v", err)
+	}
+}
+
+func TestProfileFsSymlink(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks need privileges on Windows")
+	}
+	osfs := NewOsFs()
+	dir, err := TempDir(osfs, "", "afero-profile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer osfs.RemoveAll(dir)
+	fs := NewProfileFs(NewBasePathFs(osfs, dir), ProfileFAT32)
+	if err := fs.SymlinkIfPossible("target", "/a:b"); profileErrno(err) != syscall.EINVAL {
+		t.Errorf("expected EINVAL creating a link with an invalid name, got %v", err)
+	}
+	if err := fs.SymlinkIfPossible("target", "/"+strings.Repeat("a", 256)); profileErrno(err) != syscall.ENAMETOOLONG {
+		t.Errorf("expected ENAMETOOLONG creating a link with a long name, got %v", err)
+	}
+	if err := fs.SymlinkIfPossible("a:b", "/link"); err != nil {
+		t.Fatal(err)
+	}
+	// targets are not names on the profile, so they are not checked
+	if target, err := fs.ReadlinkIfPossible("/link"); err != nil || !strings.HasSuffix(target, "a:b") {
+		t.Errorf("expected the link to point to a:b, got %q, %v", target, err)
+	}
+}
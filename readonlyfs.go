@@ -7,6 +7,11 @@ import (
 )
 
 var _ Lstater = (*ReadOnlyFs)(nil)
+var _ Locker = (*ReadOnlyFs)(nil)
+var _ XAttr = (*ReadOnlyFs)(nil)
+var _ StatFS = (*ReadOnlyFs)(nil)
+var _ Cloner = (*ReadOnlyFs)(nil)
+var _ SlashPather = (*ReadOnlyFs)(nil)
 
 type ReadOnlyFs struct {
 	source Fs
@@ -32,6 +37,10 @@ func (r *ReadOnlyFs) Name() string {
 	return "ReadOnlyFilter"
 }
 
+func (r *ReadOnlyFs) SlashPaths() bool {
+	return UsesSlashPaths(r.source)
+}
+
 func (r *ReadOnlyFs) Stat(name string) (os.FileInfo, error) {
 	return r.source.Stat(name)
 }
@@ -56,6 +65,51 @@ func (r *ReadOnlyFs) ReadlinkIfPossible(name string) (string, error) {
 	return "", &os.PathError{Op: "readlink", Path: name, Err: ErrNoReadlink}
 }
 
+// LockIfPossible delegates to the source for existing files only, as the
+// source would create a missing file to lock.
+func (r *ReadOnlyFs) LockIfPossible(name string, typ LockType, wait bool) (Unlocker, error) {
+	if locker, ok := r.source.(Locker); ok {
+		if _, err := r.source.Stat(name); err != nil {
+			return nil, err
+		}
+		return locker.LockIfPossible(name, typ, wait)
+	}
+	return nil, &os.PathError{Op: "lock", Path: name, Err: ErrNoLock}
+}
+
+func (r *ReadOnlyFs) GetXAttr(name, attr string) ([]byte, error) {
+	if x, ok := r.source.(XAttr); ok {
+		return x.GetXAttr(name, attr)
+	}
+	return nil, &os.PathError{Op: "getxattr", Path: name, Err: ErrNoXAttr}
+}
+
+func (r *ReadOnlyFs) SetXAttr(name, attr string, value []byte) error {
+	return syscall.EPERM
+}
+
+func (r *ReadOnlyFs) ListXAttrs(name string) ([]string, error) {
+	if x, ok := r.source.(XAttr); ok {
+		return x.ListXAttrs(name)
+	}
+	return nil, &os.PathError{Op: "listxattr", Path: name, Err: ErrNoXAttr}
+}
+
+func (r *ReadOnlyFs) RemoveXAttr(name, attr string) error {
+	return syscall.EPERM
+}
+
+func (r *ReadOnlyFs) StatFS(name string) (*FsStats, error) {
+	if s, ok := r.source.(StatFS); ok {
+		return s.StatFS(name)
+	}
+	return nil, &os.PathError{Op: "statfs", Path: name, Err: ErrNoStatFS}
+}
+
+func (r *ReadOnlyFs) CloneFile(oldname, newname string) error {
+	return syscall.EPERM
+}
+
 func (r *ReadOnlyFs) Rename(o, n string) error {
 	return syscall.EPERM
 }
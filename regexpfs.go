@@ -64,6 +64,10 @@ func (r *RegexpFs) Name() string {
 	return "RegexpFs"
 }
 
+func (r *RegexpFs) SlashPaths() bool {
+	return UsesSlashPaths(r.source)
+}
+
 func (r *RegexpFs) Stat(name string) (os.FileInfo, error) {
 	if err := r.dirOrMatches(name); err != nil {
 		return nil, err
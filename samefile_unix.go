@@ -0,0 +1,17 @@
+// +build aix darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package afero
+
+import (
+	"os"
+	"syscall"
+)
+
+// sameOsFile compares the device and inode numbers of OsFs files, which
+// os.SameFile only finds in the FileInfo of package os itself, not in the
+// FileInfo wrappers of other filesystems.
+func sameOsFile(fi1, fi2 os.FileInfo) bool {
+	s1, ok1 := fi1.Sys().(*syscall.Stat_t)
+	s2, ok2 := fi2.Sys().(*syscall.Stat_t)
+	return ok1 && ok2 && s1.Dev == s2.Dev && s1.Ino == s2.Ino
+}
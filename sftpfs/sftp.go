@@ -130,6 +130,22 @@ func (s Fs) Chmod(name string, mode os.FileMode) error {
 	return s.client.Chmod(name, mode)
 }
 
+// StatFS uses the statvfs@openssh.com extension, which the server has to
+// support.
+func (s Fs) StatFS(name string) (*afero.FsStats, error) {
+	st, err := s.client.StatVFS(name)
+	if err != nil {
+		return nil, &os.PathError{Op: "statfs", Path: name, Err: err}
+	}
+	return &afero.FsStats{
+		TotalBytes:     st.Blocks * st.Frsize,
+		FreeBytes:      st.Bfree * st.Frsize,
+		AvailableBytes: st.Bavail * st.Frsize,
+		TotalInodes:    st.Files,
+		FreeInodes:     st.Ffree,
+	}, nil
+}
+
 func (s Fs) Chtimes(name string, atime time.Time, mtime time.Time) error {
 	return s.client.Chtimes(name, atime, mtime)
 }
@@ -0,0 +1,138 @@
+package afero
+
+import (
+	"errors"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// SlashPather is an optional interface in Afero. It is only implemented by the
+// filesystems saying so.
+// A filesystem using slash paths separates path elements with forward slashes
+// only, like io/fs, on every OS, so its trees behave the same everywhere: a
+// backslash is an ordinary character in a name, and there are no volume
+// names. Walk, Glob and the other helpers of Afero build the paths of such a
+// filesystem with package path rather than path/filepath.
+type SlashPather interface {
+	SlashPaths() bool
+}
+
+// UsesSlashPaths reports whether fs uses slash paths.
+func UsesSlashPaths(fs Fs) bool {
+	s, ok := fs.(SlashPather)
+	return ok && s.SlashPaths()
+}
+
+// pathFuncs are the functions handling the paths of a filesystem, from
+// package path for slash paths, or from package path/filepath.
+type pathFuncs struct {
+	separator string
+	join      func(elem ...string) string
+	split     func(path string) (dir, file string)
+	dir       func(path string) string
+	base      func(path string) string
+	clean     func(path string) string
+	isAbs     func(path string) bool
+	match     func(pattern, name string) (bool, error)
+	rel       func(basepath, targpath string) (string, error)
+	fromSlash func(path string) string
+	toSlash   func(path string) string
+}
+
+var hostPaths = &pathFuncs{
+	separator: FilePathSeparator,
+	join:      filepath.Join,
+	split:     filepath.Split,
+	dir:       filepath.Dir,
+	base:      filepath.Base,
+	clean:     filepath.Clean,
+	isAbs:     filepath.IsAbs,
+	match:     filepath.Match,
+	rel:       filepath.Rel,
+	fromSlash: filepath.FromSlash,
+	toSlash:   filepath.ToSlash,
+}
+
+var slashPaths = &pathFuncs{
+	separator: "/",
+	join:      path.Join,
+	split:     path.Split,
+	dir:       path.Dir,
+	base:      path.Base,
+	clean:     path.Clean,
+	isAbs:     path.IsAbs,
+	match:     path.Match,
+	rel:       slashRel,
+	fromSlash: func(path string) string { return path },
+	toSlash:   func(path string) string { return path },
+}
+
+// pathsOf returns the path functions of fs.
+func pathsOf(fs Fs) *pathFuncs {
+	if UsesSlashPaths(fs) {
+		return slashPaths
+	}
+	return hostPaths
+}
+
+// normalize cleans name as an absolute path, so that "/tmp" and "tmp" are
+// the same.
+func (p *pathFuncs) normalize(name string) string {
+	name = p.clean(p.separator + name)
+	switch name {
+	case ".", "..":
+		return p.separator
+	}
+	return name
+}
+
+// convert converts name, a relative path in the syntax of p, to the syntax
+// of to.
+func (p *pathFuncs) convert(name string, to *pathFuncs) string {
+	if p == to {
+		return name
+	}
+	return to.fromSlash(p.toSlash(name))
+}
+
+// slashRel is filepath.Rel for slash paths.
+func slashRel(basepath, targpath string) (string, error) {
+	base, targ := path.Clean(basepath), path.Clean(targpath)
+	if targ == base {
+		return ".", nil
+	}
+	if path.IsAbs(base) != path.IsAbs(targ) {
+		return "", errors.New("Rel: can't make " + targpath + " relative to " + basepath)
+	}
+	elems := func(p string) []string {
+		p = strings.TrimPrefix(p, "/")
+		if p == "" || p == "." {
+			return nil
+		}
+		return strings.Split(p, "/")
+	}
+	b, t := elems(base), elems(targ)
+	i := 0
+	for i < len(b) && i < len(t) && b[i] == t[i] {
+		i++
+	}
+	var rel []string
+	for _, elem := range b[i:] {
+		if elem == ".." {
+			return "", errors.New("Rel: can't make " + targpath + " relative to " + basepath)
+		}
+		rel = append(rel, "..")
+	}
+	return strings.Join(append(rel, t[i:]...), "/"), nil
+}
+
+// commonPaths returns the path functions for paths relative to a root on
+// either a or b: those of slash paths if both use them, or else those of
+// the host.
+func commonPaths(a, b Fs) *pathFuncs {
+	if UsesSlashPaths(a) && UsesSlashPaths(b) {
+		return slashPaths
+	}
+	return hostPaths
+}
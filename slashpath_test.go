@@ -0,0 +1,171 @@
+package afero
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSlashRel(t *testing.T) {
+	tests := []struct {
+		base, targ, rel string
+	}{
+		{"/a", "/a", "."},
+		{"/a", "/a/b/c", "b/c"},
+		{"/a/b", "/a/c", "../c"},
+		{"/", "/a", "a"},
+		{"/a/b", "/", "../.."},
+		{".", "a/b", "a/b"},
+		{"a/b", ".", "../.."},
+		{"a", "a/b/../c", "c"},
+	}
+	for _, test := range tests {
+		if rel, err := slashRel(test.base, test.targ); err != nil || rel != test.rel {
+			t.Errorf("slashRel(%q, %q) = %q, %v, expected %q", test.base, test.targ, rel, err, test.rel)
+		}
+	}
+	for _, test := range []struct{ base, targ string }{{"/a", "b"}, {"a", "/b"}, {"..", "a"}} {
+		if _, err := slashRel(test.base, test.targ); err == nil {
+			t.Errorf("slashRel(%q, %q) expected to fail", test.base, test.targ)
+		}
+	}
+}
+
+func TestSlashMemMapFs(t *testing.T) {
+	fs := NewSlashMemMapFs()
+	if !UsesSlashPaths(fs) || UsesSlashPaths(NewMemMapFs()) {
+		t.Fatal("expected only NewSlashMemMapFs to use slash paths")
+	}
+	for _, name := range []string{"/dir/a.txt", "/dir/sub/b.txt", `/dir/back\slash.txt`, "/dir/star*.txt"} {
+		if err := WriteReader(fs, name, strings.NewReader(name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if names, err := readDirNames(fs, "dir"); err != nil ||
+		!reflect.DeepEqual(names, []string{"a.txt", `back\slash.txt`, "star*.txt", "sub"}) {
+		t.Errorf("expected a backslash to be part of a name, got %v, %v", names, err)
+	}
+	if fi, err := fs.Stat(`/dir/back\slash.txt`); err != nil || fi.Name() != `back\slash.txt` {
+		t.Errorf("expected the base name to keep the backslash, got %v", err)
+	}
+
+	var walked []string
+	err := Walk(fs, "/dir", func(path string, info os.FileInfo, err error) error {
+		walked = append(walked, path)
+		return err
+	})
+	expected := []string{"/dir", "/dir/a.txt", `/dir/back\slash.txt`, "/dir/star*.txt", "/dir/sub", "/dir/sub/b.txt"}
+	if err != nil || !reflect.DeepEqual(walked, expected) {
+		t.Errorf("expected Walk to visit %v, got %v, %v", expected, walked, err)
+	}
+
+	if matches, err := Glob(fs, "/dir/*/*.txt"); err != nil || !reflect.DeepEqual(matches, []string{"/dir/sub/b.txt"}) {
+		t.Errorf("expected Glob to match /dir/sub/b.txt, got %v, %v", matches, err)
+	}
+	if matches, err := Glob(fs, `/dir/star\*.txt`); err != nil || !reflect.DeepEqual(matches, []string{"/dir/star*.txt"}) {
+		t.Errorf(`expected \ to escape * in patterns, got %v, %v`, matches, err)
+	}
+
+	f, err := TempFile(fs, "", "tmp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if !strings.HasPrefix(f.Name(), "/tmp/tmp") {
+		t.Errorf("expected a temporary file in /tmp, got %s", f.Name())
+	}
+}
+
+func TestSlashPathsWrappers(t *testing.T) {
+	source := NewSlashMemMapFs()
+	if err := WriteReader(source, "/base/dir/file", strings.NewReader("file")); err != nil {
+		t.Fatal(err)
+	}
+	base := NewBasePathFs(source, "/base")
+	wrappers := []Fs{
+		base,
+		NewReadOnlyFs(source),
+		NewWorkingDirFs(source),
+		NewUmaskFs(source, 022),
+		NewCopyOnWriteFs(source, NewSlashMemMapFs()),
+		NewTrackingFs(source),
+	}
+	crash, err := NewCrashFs(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrappers = append(wrappers, crash)
+	for _, fs := range wrappers {
+		if !UsesSlashPaths(fs) {
+			t.Errorf("%s: expected slash paths from the source", fs.Name())
+		}
+	}
+	if matches, err := Glob(NewTrackingFs(source), "/base/*/*"); err != nil || !reflect.DeepEqual(matches, []string{"/base/dir/file"}) {
+		t.Errorf("expected Glob through TrackingFs to match /base/dir/file, got %v, %v", matches, err)
+	}
+	if name, err := base.(*BasePathFs).RealPath("dir/../dir/file"); err != nil || name != "/base/dir/file" {
+		t.Errorf("expected /base/dir/file, got %q, %v", name, err)
+	}
+	f, err := base.Open("/dir/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if f.Name() != "/dir/file" {
+		t.Errorf("expected /dir/file, got %s", f.Name())
+	}
+}
+
+func TestSlashPathsSync(t *testing.T) {
+	src, dst := NewSlashMemMapFs(), NewSlashMemMapFs()
+	for _, name := range []string{"/src/a", "/src/sub/b", "/src/sub/skip"} {
+		if err := WriteReader(src, name, strings.NewReader(name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	report, err := Sync(dst, "/dst", src, "/src", &SyncOptions{Exclude: []string{"sub/skip"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var paths []string
+	for _, a := range report.Actions {
+		paths = append(paths, a.Path)
+	}
+	if !reflect.DeepEqual(paths, []string{"a", "sub", "sub/b"}) {
+		t.Errorf("expected slash relative paths, got %v", paths)
+	}
+	diff, err := Compare(src, "/src", dst, "/dst", &CompareOptions{Ignore: []string{"sub/skip"}})
+	if err != nil || len(diff.Changes) != 0 {
+		t.Errorf("expected the trees to match, got %v, %v", diff, err)
+	}
+}
+
+func TestSlashPathsCrashFs(t *testing.T) {
+	source := NewSlashMemMapFs()
+	if err := source.Mkdir("/dir", 0755); err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewCrashFs(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := `/dir/back\slash`
+	if err := WriteFile(c, name, []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	syncName(t, c, name)
+	syncName(t, c, "/dir")
+
+	crashed, err := c.Crash(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !UsesSlashPaths(crashed) {
+		t.Error("expected the state after the crash to use slash paths")
+	}
+	checkCrashed(t, crashed, name, "data")
+	if names, err := readDirNames(crashed, "/dir"); err != nil || !reflect.DeepEqual(names, []string{`back\slash`}) {
+		t.Errorf("expected the backslash to stay in the name, got %v, %v", names, err)
+	}
+}
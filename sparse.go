@@ -0,0 +1,125 @@
+package afero
+
+import (
+	"io"
+	"os"
+	"syscall"
+
+	"github.com/spf13/afero/mem"
+)
+
+var _ SparseFile = (*mem.File)(nil)
+
+// SparseFile is an optional interface in Afero, implemented by the files of
+// the filesystems supporting holes, such as MemMapFs. Holes are ranges of a
+// file that were never written, which read as zeros but take no space.
+// Use the SeekData, SeekHole and Allocate functions, which also work on OsFs
+// files and fall back to treating other files as fully allocated.
+type SparseFile interface {
+	// SeekData moves the offset to the start of the first data at or
+	// after offset, like lseek(2) with SEEK_DATA, and returns it.
+	SeekData(offset int64) (int64, error)
+	// SeekHole moves the offset to the start of the first hole at or
+	// after offset, like lseek(2) with SEEK_HOLE, and returns it. The end
+	// of the file counts as a hole.
+	SeekHole(offset int64) (int64, error)
+	// Allocate preallocates the range of length bytes at offset, like
+	// fallocate(2) in its default mode, growing the file if needed.
+	Allocate(offset, length int64) error
+}
+
+// SeekData moves the offset of f to the start of the first data at or after
+// offset and returns it. It fails with an error wrapping syscall.ENXIO if
+// there is no data after offset. OsFs files use lseek(2) on Linux.
+func SeekData(f File, offset int64) (int64, error) {
+	switch f := f.(type) {
+	case SparseFile:
+		return f.SeekData(offset)
+	case *os.File:
+		return seekOsFile(f, offset, false)
+	case *BasePathFile:
+		return SeekData(f.File, offset)
+	}
+	return seekFallback(f, offset, false)
+}
+
+// SeekHole moves the offset of f to the start of the first hole at or after
+// offset and returns it, the end of the file counting as a hole. It fails
+// with an error wrapping syscall.ENXIO if offset is past the end. OsFs files
+// use lseek(2) on Linux.
+func SeekHole(f File, offset int64) (int64, error) {
+	switch f := f.(type) {
+	case SparseFile:
+		return f.SeekHole(offset)
+	case *os.File:
+		return seekOsFile(f, offset, true)
+	case *BasePathFile:
+		return SeekHole(f.File, offset)
+	}
+	return seekFallback(f, offset, true)
+}
+
+// Allocate preallocates the range of length bytes at offset in f, so later
+// writes to it cannot run out of space, growing the file if the range extends
+// past its end. OsFs files use fallocate(2) on Linux; elsewhere the part of
+// the range past the end of the file is written with zeros.
+func Allocate(f File, offset, length int64) error {
+	switch f := f.(type) {
+	case SparseFile:
+		return f.Allocate(offset, length)
+	case *os.File:
+		return allocateOsFile(f, offset, length)
+	case *BasePathFile:
+		return Allocate(f.File, offset, length)
+	}
+	return allocateFallback(f, offset, length)
+}
+
+// seekFallback seeks in a file without holes, which is data up to its end.
+func seekFallback(f File, offset int64, hole bool) (int64, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	if offset >= fi.Size() {
+		return 0, &os.PathError{Op: "seek", Path: f.Name(), Err: syscall.ENXIO}
+	}
+	if hole {
+		offset = fi.Size()
+	}
+	return f.Seek(offset, io.SeekStart)
+}
+
+// allocateFallback writes zeros to the part of the range past the end of f.
+func allocateFallback(f File, offset, length int64) error {
+	if offset < 0 || length <= 0 {
+		return &os.PathError{Op: "allocate", Path: f.Name(), Err: syscall.EINVAL}
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	pos, end := fi.Size(), offset+length
+	if offset > pos {
+		if err := f.Truncate(offset); err != nil {
+			return err
+		}
+		pos = offset
+	}
+	zeros := make([]byte, 32*1024)
+	for pos < end {
+		n := int64(len(zeros))
+		if end-pos < n {
+			n = end - pos
+		}
+		written, err := f.WriteAt(zeros[:n], pos)
+		if err != nil {
+			return err
+		}
+		pos += int64(written)
+	}
+	return nil
+}
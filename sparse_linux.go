@@ -0,0 +1,35 @@
+package afero
+
+import (
+	"os"
+	"syscall"
+)
+
+const (
+	seekData = 3 // SEEK_DATA
+	seekHole = 4 // SEEK_HOLE
+)
+
+func seekOsFile(f *os.File, offset int64, hole bool) (int64, error) {
+	whence := seekData
+	if hole {
+		whence = seekHole
+	}
+	pos, err := f.Seek(offset, whence)
+	if pathErr, ok := err.(*os.PathError); ok && pathErr.Err == syscall.EINVAL {
+		// old kernels and some filesystems do not know SEEK_DATA
+		return seekFallback(f, offset, hole)
+	}
+	return pos, err
+}
+
+func allocateOsFile(f *os.File, offset, length int64) error {
+	err := syscall.Fallocate(int(f.Fd()), 0, offset, length)
+	if err == syscall.EOPNOTSUPP {
+		return allocateFallback(f, offset, length)
+	}
+	if err != nil {
+		return &os.PathError{Op: "fallocate", Path: f.Name(), Err: err}
+	}
+	return nil
+}
@@ -0,0 +1,13 @@
+// +build !linux
+
+package afero
+
+import "os"
+
+func seekOsFile(f *os.File, offset int64, hole bool) (int64, error) {
+	return seekFallback(f, offset, hole)
+}
+
+func allocateOsFile(f *os.File, offset, length int64) error {
+	return allocateFallback(f, offset, length)
+}
@@ -0,0 +1,140 @@
+package afero
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/spf13/afero/mem"
+)
+
+func checkENXIO(t *testing.T, err error) {
+	t.Helper()
+	if pathErr, ok := err.(*os.PathError); !ok || pathErr.Err != syscall.ENXIO {
+		t.Errorf("expected ENXIO, got %v", err)
+	}
+}
+
+func TestMemMapFsSparse(t *testing.T) {
+	const size int64 = 10 << 30
+	fs := NewMemMapFs()
+	f, err := fs.Create("/sparse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteAt([]byte("data"), size); err != nil {
+		t.Fatal(err)
+	}
+
+	fi, _ := f.Stat()
+	if fi.Size() != size+4 {
+		t.Errorf("expected size %d, got %d", size+4, fi.Size())
+	}
+	if blocks := fi.Sys().(*mem.Stat).Blocks; blocks != 1 {
+		t.Errorf("expected the hole to take no blocks, got %d", blocks)
+	}
+	buf := []byte("xxxx")
+	if _, err := f.ReadAt(buf, size-2); err != nil || string(buf) != "\x00\x00da" {
+		t.Errorf("expected the hole to read as zeros, got %q, %v", buf, err)
+	}
+
+	for _, c := range []struct {
+		hole     bool
+		offset   int64
+		expected int64
+	}{
+		{false, 0, size},
+		{false, size + 2, size + 2},
+		{true, 0, 0},
+		{true, size, size + 4},
+	} {
+		seek := SeekData
+		if c.hole {
+			seek = SeekHole
+		}
+		if pos, err := seek(f, c.offset); err != nil || pos != c.expected {
+			t.Errorf("seeking (hole %v) from %d: expected %d, got %d, %v", c.hole, c.offset, c.expected, pos, err)
+		}
+	}
+	_, err = SeekData(f, size+4)
+	checkENXIO(t, err)
+
+	if err := Allocate(f, 4096, 4096); err != nil {
+		t.Fatal(err)
+	}
+	if pos, _ := SeekData(f, 0); pos != 4096 {
+		t.Errorf("expected preallocated data at 4096, got %d", pos)
+	}
+	if pos, _ := SeekHole(f, 4096); pos != 8192 {
+		t.Errorf("expected a hole after the preallocated range, got %d", pos)
+	}
+	if err := f.Truncate(2 * size); err != nil {
+		t.Fatal(err)
+	}
+	fi, _ = f.Stat()
+	if blocks := fi.Sys().(*mem.Stat).Blocks; blocks != 9 {
+		t.Errorf("expected 9 blocks after growing, got %d", blocks)
+	}
+}
+
+func TestOsFsSparse(t *testing.T) {
+	osfs := NewOsFs()
+	dir, err := TempDir(osfs, "", "afero-sparse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer osfs.RemoveAll(dir)
+
+	f, err := osfs.Create(filepath.Join(dir, "sparse"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteAt([]byte("data"), 1<<20); err != nil {
+		t.Fatal(err)
+	}
+	// filesystems without holes report all of the file as data
+	if pos, err := SeekData(f, 0); err != nil || (pos != 0 && pos > 1<<20) {
+		t.Errorf("unexpected data offset %d, %v", pos, err)
+	}
+	if pos, err := SeekHole(f, 1<<20); err != nil || pos != 1<<20+4 {
+		t.Errorf("expected the end of the file, got %d, %v", pos, err)
+	}
+	_, err = SeekData(f, 1<<21)
+	checkENXIO(t, err)
+
+	if err := Allocate(f, 0, 2<<20); err != nil {
+		t.Fatal(err)
+	}
+	if fi, _ := f.Stat(); fi.Size() != 2<<20 {
+		t.Errorf("expected Allocate to grow the file, got %d", fi.Size())
+	}
+}
+
+func TestSparseFallback(t *testing.T) {
+	fs := NewMemMapFs()
+	mf, err := fs.Create("/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// embedding only File hides the SparseFile methods
+	f := struct{ File }{mf}
+	f.WriteString("data")
+
+	if pos, err := SeekData(f, 1); err != nil || pos != 1 {
+		t.Errorf("expected data at 1, got %d, %v", pos, err)
+	}
+	if pos, err := SeekHole(f, 1); err != nil || pos != 4 {
+		t.Errorf("expected a hole at the end, got %d, %v", pos, err)
+	}
+	_, err = SeekHole(f, 4)
+	checkENXIO(t, err)
+	if err := Allocate(f, 8, 8); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := ReadFile(fs, "/file"); string(data) != "data"+string(make([]byte, 12)) {
+		t.Errorf("expected the file to be zero-filled, got %q", data)
+	}
+}
@@ -0,0 +1,29 @@
+package afero
+
+import (
+	"errors"
+)
+
+// FsStats describes the capacity and usage of a file system, in bytes and
+// inodes, like statfs(2) does.
+type FsStats struct {
+	TotalBytes uint64
+	FreeBytes  uint64
+	// AvailableBytes is the free space available to unprivileged users,
+	// which may be less than FreeBytes.
+	AvailableBytes uint64
+
+	TotalInodes uint64
+	FreeInodes  uint64
+}
+
+// StatFS is an optional interface in Afero. It is only implemented by the
+// filesystems saying so.
+// It reports the statistics of the file system containing the named file.
+type StatFS interface {
+	StatFS(name string) (*FsStats, error)
+}
+
+// ErrNoStatFS is the error wrapped in an os.PathError if a file system does
+// not support StatFS either directly or through its delegated filesystem.
+var ErrNoStatFS = errors.New("statfs not supported")
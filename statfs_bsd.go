@@ -0,0 +1,11 @@
+// +build darwin freebsd
+
+package afero
+
+import "syscall"
+
+// blockSize returns the unit of the block counts of st, which on the BSDs
+// is the fundamental block size.
+func blockSize(st *syscall.Statfs_t) uint64 {
+	return uint64(st.Bsize)
+}
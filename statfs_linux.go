@@ -0,0 +1,12 @@
+package afero
+
+import "syscall"
+
+// blockSize returns the unit of the block counts of st, the fragment size
+// on Linux, or the block size on kernels too old to report one.
+func blockSize(st *syscall.Statfs_t) uint64 {
+	if st.Frsize > 0 {
+		return uint64(st.Frsize)
+	}
+	return uint64(st.Bsize)
+}
@@ -0,0 +1,11 @@
+// +build !darwin,!freebsd,!linux
+
+package afero
+
+import (
+	"os"
+)
+
+func statFS(name string) (*FsStats, error) {
+	return nil, &os.PathError{Op: "statfs", Path: name, Err: ErrNoStatFS}
+}
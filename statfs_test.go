@@ -0,0 +1,70 @@
+package afero
+
+import (
+	"math"
+	"os"
+	"testing"
+)
+
+func TestMemMapFsStatFS(t *testing.T) {
+	fs := NewMemMapFs()
+	if err := fs.MkdirAll("/dir", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteFile(fs, "/dir/file", make([]byte, 100), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err := fs.(StatFS).StatFS("/dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.TotalBytes != math.MaxInt64 || stats.FreeBytes != math.MaxInt64-100 {
+		t.Errorf("expected unlimited capacity, got %+v", stats)
+	}
+
+	fs.(*MemMapFs).SetCapacity(1000, 10)
+	stats, err = fs.(StatFS).StatFS("/dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the root, /dir and /dir/file are in use
+	expected := FsStats{TotalBytes: 1000, FreeBytes: 900, AvailableBytes: 900, TotalInodes: 10, FreeInodes: 7}
+	if *stats != expected {
+		t.Errorf("expected %+v, got %+v", expected, *stats)
+	}
+
+	if _, err := fs.(StatFS).StatFS("/missing"); !os.IsNotExist(err) {
+		t.Errorf("expected not exist error, got %v", err)
+	}
+}
+
+func TestStatFSDelegation(t *testing.T) {
+	fs := &MemMapFs{}
+	fs.MkdirAll("/base", 0755)
+	fs.SetCapacity(1000, 10)
+
+	for _, wrapper := range []Fs{NewBasePathFs(fs, "/base"), NewReadOnlyFs(fs), NewCopyOnWriteFs(NewMemMapFs(), fs)} {
+		stats, err := wrapper.(StatFS).StatFS("/")
+		if err != nil {
+			t.Errorf("%s: %v", wrapper.Name(), err)
+			continue
+		}
+		if stats.TotalBytes != 1000 {
+			t.Errorf("%s: expected statistics of the source, got %+v", wrapper.Name(), stats)
+		}
+	}
+}
+
+func TestOsFsStatFS(t *testing.T) {
+	stats, err := NewOsFs().(StatFS).StatFS(os.TempDir())
+	if pathErr, ok := err.(*os.PathError); ok && pathErr.Err == ErrNoStatFS {
+		t.Skip("statfs not supported on this platform")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.TotalBytes == 0 || stats.FreeBytes > stats.TotalBytes || stats.AvailableBytes > stats.FreeBytes {
+		t.Errorf("implausible statistics %+v", stats)
+	}
+}
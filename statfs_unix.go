@@ -0,0 +1,23 @@
+// +build darwin freebsd linux
+
+package afero
+
+import (
+	"os"
+	"syscall"
+)
+
+func statFS(name string) (*FsStats, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(name, &st); err != nil {
+		return nil, &os.PathError{Op: "statfs", Path: name, Err: err}
+	}
+	bsize := blockSize(&st)
+	return &FsStats{
+		TotalBytes:     uint64(st.Blocks) * bsize,
+		FreeBytes:      uint64(st.Bfree) * bsize,
+		AvailableBytes: uint64(st.Bavail) * bsize,
+		TotalInodes:    uint64(st.Files),
+		FreeInodes:     uint64(st.Ffree),
+	}, nil
+}
@@ -0,0 +1,445 @@
+package afero
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// SyncOptions controls how Sync decides what to transfer.
+type SyncOptions struct {
+	// Checksum compares regular files by a SHA-256 hash of their contents
+	// instead of by size and modification time.
+	Checksum bool
+
+	// ModTimePrecision truncates modification times before comparing them,
+	// e.g. time.Second when one side is an sftp server.
+	ModTimePrecision time.Duration
+
+	// Delete removes files from the destination that do not exist in the
+	// source. Excluded paths are never deleted, nor are the directories
+	// holding them, which a file from the source then cannot replace.
+	Delete bool
+
+	// DryRun reports the actions that would be taken without changing the
+	// destination.
+	DryRun bool
+
+	// Include, when not empty, limits the transfer to files matching one of
+	// these patterns. Directories are always traversed.
+	Include []string
+
+	// Exclude leaves out files and directories matching one of these
+	// patterns. It takes precedence over Include.
+	//
+	// Patterns of both lists use the syntax of filepath.Match and are
+	// matched against the path relative to the root and its base name.
+	// When both file systems use slash paths, relative paths are slash
+	// paths, and patterns use the syntax of path.Match.
+	Exclude []string
+
+	// Progress, if set, is called after each action, or for each planned
+	// action in a dry run.
+	Progress func(SyncAction)
+}
+
+// SyncActionType describes what Sync did to a destination path.
+type SyncActionType int
+
+const (
+	// SyncCreate copied a file or created a directory or symlink that
+	// was missing in the destination.
+	SyncCreate SyncActionType = iota + 1
+	// SyncUpdate copied a file over an outdated one.
+	SyncUpdate
+	// SyncAttributes only updated the mode or modification time.
+	SyncAttributes
+	// SyncDelete removed an extraneous path.
+	SyncDelete
+)
+
+func (t SyncActionType) String() string {
+	switch t {
+	case SyncCreate:
+		return "create"
+	case SyncUpdate:
+		return "update"
+	case SyncAttributes:
+		return "attributes"
+	case SyncDelete:
+		return "delete"
+	}
+	return "unknown"
+}
+
+// SyncAction is a single change made to the destination.
+type SyncAction struct {
+	// Path is relative to the synchronized roots.
+	Path string
+	Type SyncActionType
+	// Bytes is the number of bytes copied, or that would have been copied
+	// in a dry run.
+	Bytes int64
+}
+
+// SyncReport lists the actions taken by Sync, in the order they were taken.
+type SyncReport struct {
+	Actions []SyncAction
+	// Unchanged counts the source paths that were already up to date.
+	Unchanged int
+	// BytesCopied is the sum of the Bytes of all actions.
+	BytesCopied int64
+}
+
+// Sync makes root on a match srcRoot on src. See the Sync function.
+func (a Afero) Sync(root string, src Fs, srcRoot string, opts *SyncOptions) (*SyncReport, error) {
+	return Sync(a.Fs, root, src, srcRoot, opts)
+}
+
+// Sync makes dstRoot on dst match srcRoot on src, transferring only what
+// changed, much like rsync does between two hosts. Files are compared by
+// size and modification time, or by content if opts.Checksum is set; copied
+// files get the mode and modification time of their source so the next Sync
+// recognizes them as unchanged. A nil opts uses the defaults.
+//
+// Sync stops at the first error and returns the report of the actions taken
+// until then together with the error.
+func Sync(dst Fs, dstRoot string, src Fs, srcRoot string, opts *SyncOptions) (*SyncReport, error) {
+	if opts == nil {
+		opts = &SyncOptions{}
+	}
+	s := &syncer{dst: dst, dstRoot: dstRoot, src: src, srcRoot: srcRoot, paths: commonPaths(dst, src), opts: opts, report: &SyncReport{}}
+
+	srcInfos, err := s.collect(src, srcRoot, nil)
+	if err != nil {
+		return s.report, err
+	}
+	s.dstInfos = make(map[string]os.FileInfo)
+	s.kept = make(map[string]bool)
+	if _, err := lstatIfPossible(dst, dstRoot); os.IsNotExist(err) {
+		if !opts.DryRun {
+			if err := dst.MkdirAll(dstRoot, 0777); err != nil {
+				return s.report, err
+			}
+		}
+	} else if s.dstInfos, err = s.collect(dst, dstRoot, s.kept); err != nil {
+		return s.report, err
+	}
+	dstInfos := s.dstInfos
+
+	paths := make([]string, 0, len(srcInfos))
+	for p := range srcInfos {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	for _, p := range paths {
+		if err := s.syncPath(p, srcInfos[p], dstInfos[p]); err != nil {
+			return s.report, err
+		}
+	}
+
+	if opts.Delete {
+		var extraneous []string
+		for p := range dstInfos {
+			if _, ok := srcInfos[p]; !ok {
+				extraneous = append(extraneous, p)
+			}
+		}
+		sort.Strings(extraneous)
+		for _, p := range extraneous {
+			if s.removed[p] {
+				continue // already gone with its parent
+			}
+			if dstInfos[p].IsDir() && s.kept[p] {
+				continue // holds excluded paths, only its other entries go
+			}
+			if err := s.remove(p, dstInfos[p]); err != nil {
+				return s.report, err
+			}
+		}
+	}
+	return s.report, nil
+}
+
+type syncer struct {
+	dst, src         Fs
+	dstRoot, srcRoot string
+	paths            *pathFuncs // of relative paths
+	opts             *SyncOptions
+	report           *SyncReport
+
+	// dstInfos are the paths collected from the destination, kept the
+	// destination directories holding paths that were left out of them,
+	// and removed the destination paths removed so far.
+	dstInfos      map[string]os.FileInfo
+	kept, removed map[string]bool
+}
+
+func (s *syncer) record(a SyncAction) {
+	s.report.Actions = append(s.report.Actions, a)
+	s.report.BytesCopied += a.Bytes
+	if s.opts.Progress != nil {
+		s.opts.Progress(a)
+	}
+}
+
+// collect returns the FileInfo of every path below root that is not
+// excluded, keyed by its path relative to root. If kept is not nil, the
+// directories holding paths that were left out are set in it.
+func (s *syncer) collect(fs Fs, root string, kept map[string]bool) (map[string]os.FileInfo, error) {
+	infos := make(map[string]os.FileInfo)
+	err := Walk(fs, root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		p := pathsOf(fs)
+		rel, err := p.rel(root, path)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		rel = p.convert(rel, s.paths)
+		if matchesAny(s.paths, s.opts.Exclude, rel) {
+			s.keep(kept, rel)
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.IsDir() && len(s.opts.Include) > 0 && !matchesAny(s.paths, s.opts.Include, rel) {
+			s.keep(kept, rel)
+			return nil
+		}
+		infos[rel] = info
+		return nil
+	})
+	return infos, err
+}
+
+// keep sets the directories holding rel in kept, if not nil.
+func (s *syncer) keep(kept map[string]bool, rel string) {
+	if kept == nil {
+		return
+	}
+	for dir := s.paths.dir(rel); dir != "."; dir = s.paths.dir(dir) {
+		kept[dir] = true
+	}
+}
+
+// srcName returns the name of rel on the source.
+func (s *syncer) srcName(rel string) string {
+	p := pathsOf(s.src)
+	return p.join(s.srcRoot, s.paths.convert(rel, p))
+}
+
+// dstName returns the name of rel on the destination.
+func (s *syncer) dstName(rel string) string {
+	p := pathsOf(s.dst)
+	return p.join(s.dstRoot, s.paths.convert(rel, p))
+}
+
+func (s *syncer) syncPath(rel string, sfi, dfi os.FileInfo) error {
+	srcName, dstName := s.srcName(rel), s.dstName(rel)
+
+	if dfi != nil && fileKind(sfi) != fileKind(dfi) {
+		// a file replaced by a directory or the other way around
+		if err := s.remove(rel, dfi); err != nil {
+			return err
+		}
+		dfi = nil
+	}
+
+	switch {
+	case sfi.IsDir():
+		if dfi == nil {
+			s.record(SyncAction{Path: rel, Type: SyncCreate})
+			if s.opts.DryRun {
+				return nil
+			}
+			if err := s.dst.Mkdir(dstName, sfi.Mode().Perm()); err != nil {
+				return err
+			}
+			return s.dst.Chmod(dstName, sfi.Mode())
+		}
+		if dfi.Mode() != sfi.Mode() {
+			s.record(SyncAction{Path: rel, Type: SyncAttributes})
+			if s.opts.DryRun {
+				return nil
+			}
+			return s.dst.Chmod(dstName, sfi.Mode())
+		}
+
+	case sfi.Mode()&os.ModeSymlink != 0:
+		target, err := readlinkIfPossible(s.src, srcName)
+		if err != nil {
+			return err
+		}
+		if dfi != nil {
+			if dtarget, err := readlinkIfPossible(s.dst, dstName); err == nil && dtarget == target {
+				break
+			}
+			if err := s.remove(rel, dfi); err != nil {
+				return err
+			}
+		}
+		s.record(SyncAction{Path: rel, Type: SyncCreate})
+		if s.opts.DryRun {
+			return nil
+		}
+		linker, ok := s.dst.(Linker)
+		if !ok {
+			return &os.LinkError{Op: "symlink", Old: target, New: dstName, Err: ErrNoSymlink}
+		}
+		return linker.SymlinkIfPossible(target, dstName)
+
+	default:
+		if dfi == nil {
+			return s.copyFile(rel, SyncCreate, sfi)
+		}
+		same, err := s.sameFile(srcName, dstName, sfi, dfi)
+		if err != nil {
+			return err
+		}
+		if !same {
+			return s.copyFile(rel, SyncUpdate, sfi)
+		}
+		if dfi.Mode() != sfi.Mode() || !s.sameModTime(sfi, dfi) {
+			s.record(SyncAction{Path: rel, Type: SyncAttributes})
+			if s.opts.DryRun {
+				return nil
+			}
+			return s.setAttributes(dstName, sfi)
+		}
+	}
+	s.report.Unchanged++
+	return nil
+}
+
+// sameFile reports whether the destination file is up to date.
+func (s *syncer) sameFile(srcName, dstName string, sfi, dfi os.FileInfo) (bool, error) {
+	if sfi.Size() != dfi.Size() {
+		return false, nil
+	}
+	if !s.opts.Checksum {
+		return s.sameModTime(sfi, dfi), nil
+	}
+	srcSum, err := fileChecksum(s.src, srcName)
+	if err != nil {
+		return false, err
+	}
+	dstSum, err := fileChecksum(s.dst, dstName)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(srcSum, dstSum), nil
+}
+
+func (s *syncer) sameModTime(sfi, dfi os.FileInfo) bool {
+	st, dt := sfi.ModTime(), dfi.ModTime()
+	if s.opts.ModTimePrecision > 0 {
+		st, dt = st.Truncate(s.opts.ModTimePrecision), dt.Truncate(s.opts.ModTimePrecision)
+	}
+	return st.Equal(dt)
+}
+
+func (s *syncer) copyFile(rel string, typ SyncActionType, sfi os.FileInfo) error {
+	if s.opts.DryRun {
+		s.record(SyncAction{Path: rel, Type: typ, Bytes: sfi.Size()})
+		return nil
+	}
+	srcName, dstName := s.srcName(rel), s.dstName(rel)
+
+	sf, err := s.src.Open(srcName)
+	if err != nil {
+		return err
+	}
+	defer sf.Close()
+	df, err := s.dst.OpenFile(dstName, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, sfi.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	n, err := io.Copy(df, sf)
+	if err1 := df.Close(); err == nil {
+		err = err1
+	}
+	if err != nil {
+		return err
+	}
+	s.record(SyncAction{Path: rel, Type: typ, Bytes: n})
+	return s.setAttributes(dstName, sfi)
+}
+
+func (s *syncer) setAttributes(dstName string, sfi os.FileInfo) error {
+	if err := s.dst.Chmod(dstName, sfi.Mode()); err != nil {
+		return err
+	}
+	return s.dst.Chtimes(dstName, sfi.ModTime(), sfi.ModTime())
+}
+
+// remove removes rel from the destination, recording a single action for it.
+// A directory is emptied bottom-up of the paths collected in it first, and
+// cannot be removed if it holds paths that were left out, as those are
+// never deleted.
+func (s *syncer) remove(rel string, dfi os.FileInfo) error {
+	dstName := s.dstName(rel)
+	if dfi.IsDir() && s.kept[rel] {
+		return &os.PathError{Op: "remove", Path: dstName, Err: ErrNotEmpty}
+	}
+	s.record(SyncAction{Path: rel, Type: SyncDelete})
+
+	names := []string{rel}
+	if dfi.IsDir() {
+		for p := range s.dstInfos {
+			if s.inside(p, rel) {
+				names = append(names, p)
+			}
+		}
+		// children sort after their parents
+		sort.Sort(sort.Reverse(sort.StringSlice(names)))
+	}
+	if s.removed == nil {
+		s.removed = make(map[string]bool)
+	}
+	for _, p := range names {
+		if s.removed[p] {
+			continue
+		}
+		s.removed[p] = true
+		if s.opts.DryRun {
+			continue
+		}
+		if err := s.dst.Remove(s.dstName(p)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// inside reports whether rel is below dir.
+func (s *syncer) inside(rel, dir string) bool {
+	for d := s.paths.dir(rel); d != "."; d = s.paths.dir(d) {
+		if d == dir {
+			return true
+		}
+	}
+	return false
+}
+
+func fileChecksum(fs Fs, name string) ([]byte, error) {
+	f, err := fs.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
@@ -0,0 +1,219 @@
+package afero
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func setupSyncSource(t *testing.T) Fs {
+	fs := NewMemMapFs()
+	if err := fs.MkdirAll("/src/dir", 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range map[string]string{
+		"/src/a.txt":     "aaa",
+		"/src/dir/b.txt": "bbbb",
+		"/src/skip.tmp":  "tmp",
+	} {
+		if err := WriteFile(fs, name, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return fs
+}
+
+func TestSync(t *testing.T) {
+	src, dst := setupSyncSource(t), NewMemMapFs()
+	opts := &SyncOptions{Exclude: []string{"*.tmp"}}
+
+	report, err := Sync(dst, "/dst", src, "/src", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Actions) != 3 || report.BytesCopied != 7 {
+		t.Fatalf("expected 3 actions copying 7 bytes, got %+v", report)
+	}
+	if ok, _ := Exists(dst, "/dst/skip.tmp"); ok {
+		t.Error("excluded file was copied")
+	}
+	diff, err := Compare(src, "/src", dst, "/dst", &CompareOptions{Ignore: opts.Exclude})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !diff.Equal() {
+		t.Errorf("expected trees to match after sync:\n%s", diff)
+	}
+
+	// a second sync has nothing to do
+	report, err = Sync(dst, "/dst", src, "/src", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Actions) != 0 || report.Unchanged != 3 {
+		t.Errorf("expected no actions, got %+v", report)
+	}
+
+	// only the changed file is transferred
+	if err := WriteFile(src, "/src/dir/b.txt", []byte("BBBB"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := src.Chtimes("/src/dir/b.txt", time.Now(), time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	report, err = Sync(dst, "/dst", src, "/src", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := SyncAction{Path: normalizeSlashes("dir/b.txt"), Type: SyncUpdate, Bytes: 4}
+	if len(report.Actions) != 1 || report.Actions[0] != expected {
+		t.Errorf("expected %+v, got %+v", expected, report.Actions)
+	}
+}
+
+func TestSyncDeleteDryRun(t *testing.T) {
+	src, dst := setupSyncSource(t), NewMemMapFs()
+	if _, err := Sync(dst, "/dst", src, "/src", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := src.RemoveAll("/src/dir"); err != nil {
+		t.Fatal(err)
+	}
+
+	var progress []SyncAction
+	opts := &SyncOptions{Delete: true, DryRun: true, Progress: func(a SyncAction) {
+		progress = append(progress, a)
+	}}
+	report, err := Sync(dst, "/dst", src, "/src", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Actions) != 1 || report.Actions[0].Type != SyncDelete || report.Actions[0].Path != "dir" {
+		t.Fatalf("expected only dir to be deleted, got %+v", report.Actions)
+	}
+	if len(progress) != 1 {
+		t.Errorf("expected progress to be reported once, got %d", len(progress))
+	}
+	if ok, _ := Exists(dst, "/dst/dir/b.txt"); !ok {
+		t.Fatal("dry run deleted a file")
+	}
+
+	opts.DryRun = false
+	if _, err := Sync(dst, "/dst", src, "/src", opts); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := Exists(dst, "/dst/dir"); ok {
+		t.Error("extraneous directory was not deleted")
+	}
+}
+
+func TestSyncChecksum(t *testing.T) {
+	src, dst := setupSyncSource(t), NewMemMapFs()
+	if _, err := Sync(dst, "/dst", src, "/src", nil); err != nil {
+		t.Fatal(err)
+	}
+	// same size and time, different content
+	fi, err := dst.Stat("/dst/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mtime := fi.ModTime()
+	if err := WriteFile(dst, "/dst/a.txt", []byte("xxx"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := dst.Chtimes("/dst/a.txt", mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := Sync(dst, "/dst", src, "/src", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Actions) != 0 {
+		t.Errorf("expected size and time comparison to miss the change, got %+v", report.Actions)
+	}
+	report, err = Sync(dst, "/dst", src, "/src", &SyncOptions{Checksum: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Actions) != 1 || report.Actions[0].Type != SyncUpdate {
+		t.Errorf("expected checksum to detect the change, got %+v", report.Actions)
+	}
+}
+
+func TestSyncDeleteRemovedDirs(t *testing.T) {
+	src, dst := NewMemMapFs(), NewMemMapFs()
+	for _, name := range []string{"/dst/x/y", "/dst/a/x", "/dst/a-b", "/dst/a/sub/z"} {
+		if err := WriteReader(dst, name, strings.NewReader(name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := WriteReader(src, "/src/x", strings.NewReader("x")); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := Sync(dst, "/dst", src, "/src", &SyncOptions{Delete: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var deleted []string
+	for _, a := range report.Actions {
+		if a.Type == SyncDelete {
+			deleted = append(deleted, a.Path)
+		}
+	}
+	if !reflect.DeepEqual(deleted, []string{"x", "a", "a-b"}) {
+		t.Errorf("expected x, a and a-b to be deleted once, got %v", deleted)
+	}
+	if fi, err := dst.Stat("/dst/x"); err != nil || fi.IsDir() {
+		t.Errorf("expected the directory x to be replaced by a file, got %v", err)
+	}
+	if names, err := readDirNames(dst, "/dst"); err != nil || !reflect.DeepEqual(names, []string{"x"}) {
+		t.Errorf("expected only x to be left, got %v, %v", names, err)
+	}
+}
+
+func TestSyncDeleteKeepsExcluded(t *testing.T) {
+	src, dst := NewMemMapFs(), NewMemMapFs()
+	if err := src.MkdirAll("/src", 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"/r/old/a.keep", "/r/old/b", "/r/old/sub/c", "/r/gone/d"} {
+		if err := WriteReader(dst, name, strings.NewReader(name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	report, err := Sync(dst, "/r", src, "/src", &SyncOptions{Delete: true, Exclude: []string{"*.keep"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var deleted []string
+	for _, a := range report.Actions {
+		if a.Type == SyncDelete {
+			deleted = append(deleted, a.Path)
+		}
+	}
+	expected := []string{"gone", normalizeSlashes("old/b"), normalizeSlashes("old/sub")}
+	if !reflect.DeepEqual(deleted, expected) {
+		t.Errorf("expected %v to be deleted, got %v", expected, deleted)
+	}
+	if ok, _ := Exists(dst, "/r/old/a.keep"); !ok {
+		t.Error("an excluded file was deleted")
+	}
+	if names, err := readDirNames(dst, "/r/old"); err != nil || !reflect.DeepEqual(names, []string{"a.keep"}) {
+		t.Errorf("expected only the excluded file to be left, got %v, %v", names, err)
+	}
+
+	// a file cannot replace a directory holding excluded files
+	if err := WriteReader(src, "/src/old", strings.NewReader("file")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Sync(dst, "/r", src, "/src", &SyncOptions{Exclude: []string{"*.keep"}}); underlyingError(err) != ErrNotEmpty {
+		t.Errorf("expected ErrNotEmpty replacing a directory holding excluded files, got %v", err)
+	}
+	if ok, _ := Exists(dst, "/r/old/a.keep"); !ok {
+		t.Error("an excluded file was deleted")
+	}
+}
@@ -0,0 +1,281 @@
+package afero
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+)
+
+var _ Lstater = (*TrackingFs)(nil)
+var _ Symlinker = (*TrackingFs)(nil)
+var _ Locker = (*TrackingFs)(nil)
+var _ XAttr = (*TrackingFs)(nil)
+var _ StatFS = (*TrackingFs)(nil)
+var _ Cloner = (*TrackingFs)(nil)
+var _ SlashPather = (*TrackingFs)(nil)
+
+// TrackingFs keeps track of the files opened through it until they are
+// closed, remembering where each was opened, so tests can find leaked
+// handles:
+//
+//	fs := afero.NewTrackingFs(afero.NewMemMapFs())
+//	defer func() {
+//		if err := fs.Check(); err != nil {
+//			t.Error(err)
+//		}
+//	}()
+//
+// It can also limit the number of files open at once, failing further opens
+// with syscall.EMFILE like a process running out of file descriptors.
+type TrackingFs struct {
+	source Fs
+
+	mu      sync.Mutex
+	open    map[*trackedFile]struct{}
+	opening int // opens in progress, counted against maxOpen
+	maxOpen int
+}
+
+func NewTrackingFs(source Fs) *TrackingFs {
+	return &TrackingFs{source: source, open: make(map[*trackedFile]struct{})}
+}
+
+// SetMaxOpen limits the number of files open at once to n. Zero, the
+// default, means no limit.
+func (t *TrackingFs) SetMaxOpen(n int) {
+	t.mu.Lock()
+	t.maxOpen = n
+	t.mu.Unlock()
+}
+
+// OpenHandle describes a file opened through a TrackingFs and not closed.
+type OpenHandle struct {
+	Name   string
+	Flag   int
+	Opened time.Time
+	// Stack is the stack trace of the goroutine that opened the file.
+	Stack string
+}
+
+func (h OpenHandle) String() string {
+	return fmt.Sprintf("%s opened at %s\n%s", h.Name, h.Opened.Format(time.RFC3339Nano), h.Stack)
+}
+
+// OpenHandles returns the files currently open, oldest first.
+func (t *TrackingFs) OpenHandles() []OpenHandle {
+	t.mu.Lock()
+	handles := make([]OpenHandle, 0, len(t.open))
+	for f := range t.open {
+		handles = append(handles, OpenHandle{Name: f.Name(), Flag: f.flag, Opened: f.opened, Stack: formatStack(f.pcs)})
+	}
+	t.mu.Unlock()
+	sort.Slice(handles, func(i, j int) bool { return handles[i].Opened.Before(handles[j].Opened) })
+	return handles
+}
+
+// Check returns an error listing the files still open along with where they
+// were opened, or nil if all were closed.
+func (t *TrackingFs) Check() error {
+	handles := t.OpenHandles()
+	if len(handles) == 0 {
+		return nil
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%d file(s) still open:", len(handles))
+	for _, h := range handles {
+		fmt.Fprintf(&buf, "\n\n%s", h)
+	}
+	return errors.New(buf.String())
+}
+
+func formatStack(pcs []uintptr) string {
+	var buf bytes.Buffer
+	frames := runtime.CallersFrames(pcs)
+	for {
+		frame, more := frames.Next()
+		fmt.Fprintf(&buf, "%s\n\t%s:%d\n", frame.Function, frame.File, frame.Line)
+		if !more {
+			break
+		}
+	}
+	return buf.String()
+}
+
+// track opens a file with open, outside of t.mu so that slow opens do not
+// hold up others, and registers it.
+func (t *TrackingFs) track(name string, flag int, open func() (File, error)) (File, error) {
+	t.mu.Lock()
+	if t.maxOpen > 0 && len(t.open)+t.opening >= t.maxOpen {
+		t.mu.Unlock()
+		return nil, &os.PathError{Op: "open", Path: name, Err: syscall.EMFILE}
+	}
+	t.opening++
+	t.mu.Unlock()
+
+	f, err := open()
+	var tf *trackedFile
+	if err == nil {
+		pcs := make([]uintptr, 32)
+		// skip runtime.Callers, track and the TrackingFs method calling it
+		pcs = pcs[:runtime.Callers(3, pcs)]
+		tf = &trackedFile{File: f, fs: t, flag: flag, opened: time.Now(), pcs: pcs}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.opening--
+	if err != nil {
+		return nil, err
+	}
+	t.open[tf] = struct{}{}
+	return tf, nil
+}
+
+func (t *TrackingFs) Create(name string) (File, error) {
+	return t.track(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, func() (File, error) {
+		return t.source.Create(name)
+	})
+}
+
+func (t *TrackingFs) Open(name string) (File, error) {
+	return t.track(name, os.O_RDONLY, func() (File, error) {
+		return t.source.Open(name)
+	})
+}
+
+func (t *TrackingFs) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	return t.track(name, flag, func() (File, error) {
+		return t.source.OpenFile(name, flag, perm)
+	})
+}
+
+func (t *TrackingFs) Mkdir(name string, perm os.FileMode) error {
+	return t.source.Mkdir(name, perm)
+}
+
+func (t *TrackingFs) MkdirAll(path string, perm os.FileMode) error {
+	return t.source.MkdirAll(path, perm)
+}
+
+func (t *TrackingFs) Remove(name string) error {
+	return t.source.Remove(name)
+}
+
+func (t *TrackingFs) RemoveAll(path string) error {
+	return t.source.RemoveAll(path)
+}
+
+func (t *TrackingFs) Rename(oldname, newname string) error {
+	return t.source.Rename(oldname, newname)
+}
+
+func (t *TrackingFs) Stat(name string) (os.FileInfo, error) {
+	return t.source.Stat(name)
+}
+
+func (t *TrackingFs) LstatIfPossible(name string) (os.FileInfo, bool, error) {
+	if lsf, ok := t.source.(Lstater); ok {
+		return lsf.LstatIfPossible(name)
+	}
+	fi, err := t.Stat(name)
+	return fi, false, err
+}
+
+func (t *TrackingFs) Name() string {
+	return "TrackingFs"
+}
+
+func (t *TrackingFs) SlashPaths() bool {
+	return UsesSlashPaths(t.source)
+}
+
+func (t *TrackingFs) Chmod(name string, mode os.FileMode) error {
+	return t.source.Chmod(name, mode)
+}
+
+func (t *TrackingFs) Chtimes(name string, atime, mtime time.Time) error {
+	return t.source.Chtimes(name, atime, mtime)
+}
+
+func (t *TrackingFs) SymlinkIfPossible(oldname, newname string) error {
+	if linker, ok := t.source.(Linker); ok {
+		return linker.SymlinkIfPossible(oldname, newname)
+	}
+	return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: ErrNoSymlink}
+}
+
+func (t *TrackingFs) ReadlinkIfPossible(name string) (string, error) {
+	if reader, ok := t.source.(LinkReader); ok {
+		return reader.ReadlinkIfPossible(name)
+	}
+	return "", &os.PathError{Op: "readlink", Path: name, Err: ErrNoReadlink}
+}
+
+func (t *TrackingFs) LockIfPossible(name string, typ LockType, wait bool) (Unlocker, error) {
+	return LockFile(t.source, name, typ, wait)
+}
+
+func (t *TrackingFs) GetXAttr(name, attr string) ([]byte, error) {
+	if x, ok := t.source.(XAttr); ok {
+		return x.GetXAttr(name, attr)
+	}
+	return nil, &os.PathError{Op: "getxattr", Path: name, Err: ErrNoXAttr}
+}
+
+func (t *TrackingFs) SetXAttr(name, attr string, value []byte) error {
+	if x, ok := t.source.(XAttr); ok {
+		return x.SetXAttr(name, attr, value)
+	}
+	return &os.PathError{Op: "setxattr", Path: name, Err: ErrNoXAttr}
+}
+
+func (t *TrackingFs) ListXAttrs(name string) ([]string, error) {
+	if x, ok := t.source.(XAttr); ok {
+		return x.ListXAttrs(name)
+	}
+	return nil, &os.PathError{Op: "listxattr", Path: name, Err: ErrNoXAttr}
+}
+
+func (t *TrackingFs) RemoveXAttr(name, attr string) error {
+	if x, ok := t.source.(XAttr); ok {
+		return x.RemoveXAttr(name, attr)
+	}
+	return &os.PathError{Op: "removexattr", Path: name, Err: ErrNoXAttr}
+}
+
+func (t *TrackingFs) StatFS(name string) (*FsStats, error) {
+	if s, ok := t.source.(StatFS); ok {
+		return s.StatFS(name)
+	}
+	return nil, &os.PathError{Op: "statfs", Path: name, Err: ErrNoStatFS}
+}
+
+func (t *TrackingFs) CloneFile(oldname, newname string) error {
+	if c, ok := t.source.(Cloner); ok {
+		return c.CloneFile(oldname, newname)
+	}
+	return &os.LinkError{Op: "clone", Old: oldname, New: newname, Err: ErrNoClone}
+}
+
+type trackedFile struct {
+	File
+	fs     *TrackingFs
+	flag   int
+	opened time.Time
+	pcs    []uintptr
+}
+
+// Close stops tracking the file, even if closing it fails, as the file
+// cannot be used any more either way.
+func (f *trackedFile) Close() error {
+	f.fs.mu.Lock()
+	delete(f.fs.open, f)
+	f.fs.mu.Unlock()
+	return f.File.Close()
+}
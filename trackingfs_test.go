@@ -0,0 +1,158 @@
+package afero
+
+import (
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestTrackingFsCheck(t *testing.T) {
+	fs := NewTrackingFs(NewMemMapFs())
+	if err := WriteFile(fs, "/closed", []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadFile(fs, "/closed"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Check(); err != nil {
+		t.Fatalf("expected no open files, got %v", err)
+	}
+
+	f, err := fs.Create("/leaked")
+	if err != nil {
+		t.Fatal(err)
+	}
+	handles := fs.OpenHandles()
+	if len(handles) != 1 || handles[0].Name != "/leaked" {
+		t.Fatalf("expected /leaked to be open, got %+v", handles)
+	}
+	err = fs.Check()
+	if err == nil || !strings.Contains(err.Error(), "/leaked") || !strings.Contains(err.Error(), "TestTrackingFsCheck") {
+		t.Errorf("expected the leak and where it was opened to be reported, got %v", err)
+	}
+
+	f.Close()
+	if err := fs.Check(); err != nil {
+		t.Errorf("expected no open files, got %v", err)
+	}
+}
+
+func TestTrackingFsMaxOpen(t *testing.T) {
+	fs := NewTrackingFs(NewMemMapFs())
+	fs.SetMaxOpen(2)
+
+	f1, err := fs.Create("/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f2, err := fs.Open("/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = fs.OpenFile("/a", os.O_RDONLY, 0)
+	if pathErr, ok := err.(*os.PathError); !ok || pathErr.Err != syscall.EMFILE {
+		t.Fatalf("expected EMFILE, got %v", err)
+	}
+	f1.Close()
+	f3, err := fs.Open("/a")
+	if err != nil {
+		t.Fatalf("expected a closed handle to free a slot, got %v", err)
+	}
+	f2.Close()
+	f3.Close()
+}
+
+// slowOpenFs blocks opening name until unblock is closed.
+type slowOpenFs struct {
+	Fs
+	name             string
+	opening, unblock chan struct{}
+}
+
+func (s *slowOpenFs) Open(name string) (File, error) {
+	if name == s.name {
+		close(s.opening)
+		<-s.unblock
+	}
+	return s.Fs.Open(name)
+}
+
+func TestTrackingFsSlowOpen(t *testing.T) {
+	source := &slowOpenFs{Fs: NewMemMapFs(), name: "/slow", opening: make(chan struct{}), unblock: make(chan struct{})}
+	for _, name := range []string{"/fast", "/slow"} {
+		if err := WriteFile(source.Fs, name, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fs := NewTrackingFs(source)
+	fs.SetMaxOpen(2)
+	fast, err := fs.Open("/fast")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opened := make(chan File)
+	go func() {
+		f, err := fs.Open("/slow")
+		if err != nil {
+			t.Error(err)
+		}
+		opened <- f
+	}()
+	<-source.opening
+
+	done := make(chan struct{})
+	go func() {
+		if _, err := fs.Open("/fast"); underlyingError(err) != syscall.EMFILE {
+			t.Errorf("expected the open in progress to count against the limit, got %v", err)
+		}
+		if n := len(fs.OpenHandles()); n != 1 {
+			t.Errorf("expected 1 open file, got %d", n)
+		}
+		fast.Close()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("a slow open blocked other calls")
+	}
+	close(source.unblock)
+	(<-opened).Close()
+	if err := fs.Check(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestTrackingFsForwarding(t *testing.T) {
+	source := NewMemMapFs()
+	fs := NewTrackingFs(source)
+	if err := WriteFile(fs, "/file", []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	u, err := fs.LockIfPossible("/file", LockExclusive, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := source.(Locker).LockIfPossible("/file", LockExclusive, false); underlyingError(err) != ErrLocked {
+		t.Errorf("expected the lock to be taken in the source, got %v", err)
+	}
+	u.Unlock()
+	if err := fs.SetXAttr("/file", "user.a", []byte("b")); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := source.(XAttr).GetXAttr("/file", "user.a"); err != nil || string(v) != "b" {
+		t.Errorf("expected the attribute in the source, got %q, %v", v, err)
+	}
+	if err := fs.CloneFile("/file", "/clone"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.StatFS("/"); err != nil {
+		t.Errorf("expected StatFS to be forwarded, got %v", err)
+	}
+	if err := fs.Check(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,193 @@
+package afero
+
+import (
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+var _ Lstater = (*UmaskFs)(nil)
+var _ Symlinker = (*UmaskFs)(nil)
+var _ Locker = (*UmaskFs)(nil)
+var _ XAttr = (*UmaskFs)(nil)
+var _ StatFS = (*UmaskFs)(nil)
+var _ Cloner = (*UmaskFs)(nil)
+var _ SlashPather = (*UmaskFs)(nil)
+
+// UmaskFs clears the permission bits of a umask from the perm given when
+// creating files and directories in its source, like the process umask does
+// for os.OpenFile and os.Mkdir. It applies to Create, OpenFile with
+// O_CREATE, Mkdir and MkdirAll, and so to TempFile and TempDir, as well as
+// to the files created by LockIfPossible and CloneFile. It does not apply
+// to Chmod, nor to symlinks, whose permissions are not used. The umask of
+// an OsFs source, that of the process, still applies on top of it.
+type UmaskFs struct {
+	source Fs
+	umask  uint32
+}
+
+func NewUmaskFs(source Fs, mask os.FileMode) *UmaskFs {
+	return &UmaskFs{source: source, umask: uint32(mask & os.ModePerm)}
+}
+
+func (u *UmaskFs) SetUmask(mask os.FileMode) {
+	atomic.StoreUint32(&u.umask, uint32(mask&os.ModePerm))
+}
+
+func (u *UmaskFs) Umask() os.FileMode {
+	return os.FileMode(atomic.LoadUint32(&u.umask))
+}
+
+// Create creates name with mode 0666 minus the umask, or truncates it if it
+// exists.
+func (u *UmaskFs) Create(name string) (File, error) {
+	return u.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (u *UmaskFs) Mkdir(name string, perm os.FileMode) error {
+	return u.source.Mkdir(name, perm&^u.Umask())
+}
+
+func (u *UmaskFs) MkdirAll(path string, perm os.FileMode) error {
+	return u.source.MkdirAll(path, perm&^u.Umask())
+}
+
+func (u *UmaskFs) Open(name string) (File, error) {
+	return u.source.Open(name)
+}
+
+func (u *UmaskFs) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	return u.source.OpenFile(name, flag, perm&^u.Umask())
+}
+
+func (u *UmaskFs) Remove(name string) error {
+	return u.source.Remove(name)
+}
+
+func (u *UmaskFs) RemoveAll(path string) error {
+	return u.source.RemoveAll(path)
+}
+
+func (u *UmaskFs) Rename(oldname, newname string) error {
+	return u.source.Rename(oldname, newname)
+}
+
+func (u *UmaskFs) Stat(name string) (os.FileInfo, error) {
+	return u.source.Stat(name)
+}
+
+func (u *UmaskFs) LstatIfPossible(name string) (os.FileInfo, bool, error) {
+	if lsf, ok := u.source.(Lstater); ok {
+		return lsf.LstatIfPossible(name)
+	}
+	fi, err := u.Stat(name)
+	return fi, false, err
+}
+
+func (u *UmaskFs) Name() string {
+	return "UmaskFs"
+}
+
+func (u *UmaskFs) SlashPaths() bool {
+	return UsesSlashPaths(u.source)
+}
+
+func (u *UmaskFs) Chmod(name string, mode os.FileMode) error {
+	return u.source.Chmod(name, mode)
+}
+
+func (u *UmaskFs) Chtimes(name string, atime, mtime time.Time) error {
+	return u.source.Chtimes(name, atime, mtime)
+}
+
+// SymlinkIfPossible creates newname pointing to oldname in the source. The
+// umask does not apply, as the permissions of a symlink are not used.
+func (u *UmaskFs) SymlinkIfPossible(oldname, newname string) error {
+	if linker, ok := u.source.(Linker); ok {
+		return linker.SymlinkIfPossible(oldname, newname)
+	}
+	return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: ErrNoSymlink}
+}
+
+func (u *UmaskFs) ReadlinkIfPossible(name string) (string, error) {
+	if reader, ok := u.source.(LinkReader); ok {
+		return reader.ReadlinkIfPossible(name)
+	}
+	return "", &os.PathError{Op: "readlink", Path: name, Err: ErrNoReadlink}
+}
+
+// LockIfPossible locks name in the source. A missing file is created first,
+// so that it gets mode 0666 minus the umask rather than whatever the source
+// would give it.
+func (u *UmaskFs) LockIfPossible(name string, typ LockType, wait bool) (Unlocker, error) {
+	if _, ok := u.source.(Locker); ok {
+		if _, err := u.source.Stat(name); os.IsNotExist(err) {
+			f, err := u.OpenFile(name, os.O_RDONLY|os.O_CREATE, 0666)
+			if err != nil {
+				return nil, err
+			}
+			f.Close()
+		}
+	}
+	return LockFile(u.source, name, typ, wait)
+}
+
+func (u *UmaskFs) GetXAttr(name, attr string) ([]byte, error) {
+	if x, ok := u.source.(XAttr); ok {
+		return x.GetXAttr(name, attr)
+	}
+	return nil, &os.PathError{Op: "getxattr", Path: name, Err: ErrNoXAttr}
+}
+
+func (u *UmaskFs) SetXAttr(name, attr string, value []byte) error {
+	if x, ok := u.source.(XAttr); ok {
+		return x.SetXAttr(name, attr, value)
+	}
+	return &os.PathError{Op: "setxattr", Path: name, Err: ErrNoXAttr}
+}
+
+func (u *UmaskFs) ListXAttrs(name string) ([]string, error) {
+	if x, ok := u.source.(XAttr); ok {
+		return x.ListXAttrs(name)
+	}
+	return nil, &os.PathError{Op: "listxattr", Path: name, Err: ErrNoXAttr}
+}
+
+func (u *UmaskFs) RemoveXAttr(name, attr string) error {
+	if x, ok := u.source.(XAttr); ok {
+		return x.RemoveXAttr(name, attr)
+	}
+	return &os.PathError{Op: "removexattr", Path: name, Err: ErrNoXAttr}
+}
+
+func (u *UmaskFs) StatFS(name string) (*FsStats, error) {
+	if s, ok := u.source.(StatFS); ok {
+		return s.StatFS(name)
+	}
+	return nil, &os.PathError{Op: "statfs", Path: name, Err: ErrNoStatFS}
+}
+
+// CloneFile clones oldname to newname in the source. If that creates
+// newname, its permissions are those of oldname minus the umask.
+func (u *UmaskFs) CloneFile(oldname, newname string) error {
+	c, ok := u.source.(Cloner)
+	if !ok {
+		return &os.LinkError{Op: "clone", Old: oldname, New: newname, Err: ErrNoClone}
+	}
+	_, err := u.source.Stat(newname)
+	created := os.IsNotExist(err)
+	if err := c.CloneFile(oldname, newname); err != nil {
+		return err
+	}
+	if !created {
+		return nil
+	}
+	fi, err := u.source.Stat(newname)
+	if err != nil {
+		return err
+	}
+	if perm := fi.Mode().Perm(); perm&u.Umask() != 0 {
+		return u.source.Chmod(newname, fi.Mode()&^u.Umask())
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package afero
+
+import (
+	"os"
+	"testing"
+)
+
+func testUmask(t *testing.T, fs Fs) {
+	mode := func(name string) os.FileMode {
+		fi, err := fs.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fi.Mode().Perm()
+	}
+
+	f, err := fs.Create("/created")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if m := mode("/created"); m != 0640 {
+		t.Errorf("Create: expected 0640, got %v", m)
+	}
+	if f, err = fs.OpenFile("/opened", os.O_WRONLY|os.O_CREATE, 0666); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if m := mode("/opened"); m != 0640 {
+		t.Errorf("OpenFile: expected 0640, got %v", m)
+	}
+	if err := fs.Mkdir("/dir", 0777); err != nil {
+		t.Fatal(err)
+	}
+	if m := mode("/dir"); m != 0750 {
+		t.Errorf("Mkdir: expected 0750, got %v", m)
+	}
+	if err := fs.MkdirAll("/a/b", 0777); err != nil {
+		t.Fatal(err)
+	}
+	if m, n := mode("/a"), mode("/a/b"); m != 0750 || n != 0750 {
+		t.Errorf("MkdirAll: expected 0750, got %v and %v", m, n)
+	}
+	dir, err := TempDir(fs, "/", "tmp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m := mode(dir); m != 0700 {
+		t.Errorf("TempDir: expected 0700, got %v", m)
+	}
+	if f, err = TempFile(fs, dir, "tmp"); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if m := mode(f.Name()); m != 0600 {
+		t.Errorf("TempFile: expected 0600, got %v", m)
+	}
+
+	// only applies when creating
+	if f, err = fs.OpenFile("/opened", os.O_WRONLY|os.O_CREATE, 0600); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if m := mode("/opened"); m != 0640 {
+		t.Errorf("OpenFile of an existing file: expected 0640, got %v", m)
+	}
+	if err := fs.Chmod("/opened", 0777); err != nil {
+		t.Fatal(err)
+	}
+	if m := mode("/opened"); m != 0777 {
+		t.Errorf("Chmod: expected 0777, got %v", m)
+	}
+}
+
+func TestMemMapFsUmask(t *testing.T) {
+	fs := &MemMapFs{}
+	fs.SetUmask(027)
+	testUmask(t, fs)
+}
+
+func TestUmaskFs(t *testing.T) {
+	fs := NewUmaskFs(NewMemMapFs(), 027)
+	testUmask(t, fs)
+	if fs.SetUmask(0); fs.Umask() != 0 {
+		t.Errorf("expected the umask to be cleared, got %v", fs.Umask())
+	}
+	if err := fs.Mkdir("/open", 0777); err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := fs.Stat("/open"); err != nil || fi.Mode().Perm() != 0777 {
+		t.Errorf("expected 0777 without a umask, got %v, %v", fi.Mode(), err)
+	}
+}
+
+func TestUmaskFsForwarding(t *testing.T) {
+	source := NewMemMapFs()
+	var fs Fs = NewUmaskFs(source, 027)
+	if err := WriteFile(source, "/file", []byte("data"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	u, err := fs.(Locker).LockIfPossible("/lock", LockExclusive, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u.Unlock()
+	if fi, err := source.Stat("/lock"); err != nil || fi.Mode().Perm() != 0640 {
+		t.Errorf("expected a lock file with mode 0640, got %v", err)
+	}
+	if err := fs.(Cloner).CloneFile("/file", "/clone"); err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := source.Stat("/clone"); err != nil || fi.Mode().Perm() != 0640 {
+		t.Errorf("expected a clone with mode 0640, got %v", err)
+	}
+	if err := fs.(XAttr).SetXAttr("/file", "user.a", []byte("b")); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := source.(XAttr).GetXAttr("/file", "user.a"); err != nil || string(v) != "b" {
+		t.Errorf("expected the attribute in the source, got %q, %v", v, err)
+	}
+	if _, err := fs.(StatFS).StatFS("/"); err != nil {
+		t.Errorf("expected StatFS to be forwarded, got %v", err)
+	}
+	if err := fs.(Linker).SymlinkIfPossible("/file", "/link"); underlyingError(err) != ErrNoSymlink {
+		t.Errorf("expected ErrNoSymlink from MemMapFs, got %v", err)
+	}
+}
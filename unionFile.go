@@ -3,7 +3,6 @@ package afero
 import (
 	"io"
 	"os"
-	"path/filepath"
 	"syscall"
 )
 
@@ -279,12 +278,13 @@ func copyToLayer(base Fs, layer Fs, name string) error {
 	defer bfh.Close()
 
 	// First make sure the directory exists
-	exists, err := Exists(layer, filepath.Dir(name))
+	dir := pathsOf(layer).dir(name)
+	exists, err := Exists(layer, dir)
 	if err != nil {
 		return err
 	}
 	if !exists {
-		err = layer.MkdirAll(filepath.Dir(name), 0777) // FIXME?
+		err = layer.MkdirAll(dir, 0777) // FIXME?
 		if err != nil {
 			return err
 		}
@@ -316,5 +316,9 @@ func copyToLayer(base Fs, layer Fs, name string) error {
 		lfh.Close()
 		return err
 	}
+	if err := copyXAttrs(base, layer, name); err != nil {
+		layer.Remove(name)
+		return err
+	}
 	return layer.Chtimes(name, bfi.ModTime(), bfi.ModTime())
 }
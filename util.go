@@ -18,6 +18,7 @@ package afero
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -25,6 +26,7 @@ import (
 	"strings"
 	"unicode"
 
+	"github.com/spf13/afero/mem"
 	"golang.org/x/text/transform"
 	"golang.org/x/text/unicode/norm"
 )
@@ -38,8 +40,9 @@ func (a Afero) WriteReader(path string, r io.Reader) (err error) {
 }
 
 func WriteReader(fs Fs, path string, r io.Reader) (err error) {
-	dir, _ := filepath.Split(path)
-	ospath := filepath.FromSlash(dir)
+	p := pathsOf(fs)
+	dir, _ := p.split(path)
+	ospath := p.fromSlash(dir)
 
 	if ospath != "" {
 		err = fs.MkdirAll(ospath, 0777) // rwx, rw, r
@@ -66,8 +69,9 @@ func (a Afero) SafeWriteReader(path string, r io.Reader) (err error) {
 }
 
 func SafeWriteReader(fs Fs, path string, r io.Reader) (err error) {
-	dir, _ := filepath.Split(path)
-	ospath := filepath.FromSlash(dir)
+	p := pathsOf(fs)
+	dir, _ := p.split(path)
+	ospath := p.fromSlash(dir)
 
 	if ospath != "" {
 		err = fs.MkdirAll(ospath, 0777) // rwx, rw, r
@@ -260,7 +264,7 @@ func DirExists(fs Fs, path string) (bool, error) {
 	if err == nil && fi.IsDir() {
 		return true, nil
 	}
-	if os.IsNotExist(err) {
+	if errors.Is(err, os.ErrNotExist) {
 		return false, nil
 	}
 	return false, err
@@ -314,14 +318,28 @@ func Exists(fs Fs, path string) (bool, error) {
 	if err == nil {
 		return true, nil
 	}
-	if os.IsNotExist(err) {
+	if errors.Is(err, os.ErrNotExist) {
 		return false, nil
 	}
 	return false, err
 }
 
+// SameFile reports whether fi1 and fi2 describe the same file, like
+// os.SameFile does for the FileInfo of OsFs. It also compares the device and
+// inode numbers of MemMapFs files, and works for filesystems wrapping either.
+// On Windows, OsFs files are only recognized through filesystems returning
+// the FileInfo of package os as is.
+func SameFile(fi1, fi2 os.FileInfo) bool {
+	if os.SameFile(fi1, fi2) || sameOsFile(fi1, fi2) {
+		return true
+	}
+	s1, ok1 := fi1.Sys().(*mem.Stat)
+	s2, ok2 := fi2.Sys().(*mem.Stat)
+	return ok1 && ok2 && s1.Dev == s2.Dev && s1.Ino == s2.Ino
+}
+
 func FullBaseFsPath(basePathFs *BasePathFs, relativePath string) string {
-	combinedPath := filepath.Join(basePathFs.path, relativePath)
+	combinedPath := pathsOf(basePathFs.source).join(basePathFs.path, relativePath)
 	if parent, ok := basePathFs.source.(*BasePathFs); ok {
 		return FullBaseFsPath(parent, combinedPath)
 	}
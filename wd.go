@@ -0,0 +1,205 @@
+package afero
+
+import (
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Chdirer is an optional interface in Afero. It is only implemented by the
+// filesystems saying so.
+// It gives the filesystem a working directory, against which relative paths
+// given to all its methods are resolved, like os.Getwd and os.Chdir.
+type Chdirer interface {
+	Getwd() (string, error)
+	Chdir(dir string) error
+}
+
+var _ Chdirer = (*WorkingDirFs)(nil)
+var _ Lstater = (*WorkingDirFs)(nil)
+var _ Symlinker = (*WorkingDirFs)(nil)
+var _ Locker = (*WorkingDirFs)(nil)
+var _ XAttr = (*WorkingDirFs)(nil)
+var _ StatFS = (*WorkingDirFs)(nil)
+var _ Cloner = (*WorkingDirFs)(nil)
+var _ SlashPather = (*WorkingDirFs)(nil)
+
+// WorkingDirFs gives any Fs a working directory of its own. Relative paths
+// are resolved against it before calling the source, which only sees
+// absolute paths. Changing it does not change the working directory of the
+// source, nor of the process for an OsFs.
+type WorkingDirFs struct {
+	source Fs
+
+	mu sync.RWMutex
+	wd string
+}
+
+// NewWorkingDirFs returns a WorkingDirFs starting in the working directory of
+// source if it is a Chdirer, or else in the root.
+func NewWorkingDirFs(source Fs) *WorkingDirFs {
+	wd := pathsOf(source).separator
+	if c, ok := source.(Chdirer); ok {
+		if dir, err := c.Getwd(); err == nil {
+			wd = dir
+		}
+	}
+	return &WorkingDirFs{source: source, wd: wd}
+}
+
+func (w *WorkingDirFs) Getwd() (string, error) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.wd, nil
+}
+
+func (w *WorkingDirFs) Chdir(dir string) error {
+	dir = w.abs(dir)
+	fi, err := w.source.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return &os.PathError{Op: "chdir", Path: dir, Err: ErrNotDir}
+	}
+	w.mu.Lock()
+	w.wd = dir
+	w.mu.Unlock()
+	return nil
+}
+
+// abs resolves name against the working directory if it is relative.
+func (w *WorkingDirFs) abs(name string) string {
+	p := pathsOf(w.source)
+	if p.isAbs(name) || strings.HasPrefix(name, p.separator) {
+		return name
+	}
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return p.join(w.wd, name)
+}
+
+func (w *WorkingDirFs) Create(name string) (File, error) {
+	return w.source.Create(w.abs(name))
+}
+
+func (w *WorkingDirFs) Mkdir(name string, perm os.FileMode) error {
+	return w.source.Mkdir(w.abs(name), perm)
+}
+
+func (w *WorkingDirFs) MkdirAll(path string, perm os.FileMode) error {
+	return w.source.MkdirAll(w.abs(path), perm)
+}
+
+func (w *WorkingDirFs) Open(name string) (File, error) {
+	return w.source.Open(w.abs(name))
+}
+
+func (w *WorkingDirFs) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	return w.source.OpenFile(w.abs(name), flag, perm)
+}
+
+func (w *WorkingDirFs) Remove(name string) error {
+	return w.source.Remove(w.abs(name))
+}
+
+func (w *WorkingDirFs) RemoveAll(path string) error {
+	return w.source.RemoveAll(w.abs(path))
+}
+
+func (w *WorkingDirFs) Rename(oldname, newname string) error {
+	return w.source.Rename(w.abs(oldname), w.abs(newname))
+}
+
+func (w *WorkingDirFs) Stat(name string) (os.FileInfo, error) {
+	return w.source.Stat(w.abs(name))
+}
+
+func (w *WorkingDirFs) Name() string {
+	return "WorkingDirFs"
+}
+
+func (w *WorkingDirFs) SlashPaths() bool {
+	return UsesSlashPaths(w.source)
+}
+
+func (w *WorkingDirFs) Chmod(name string, mode os.FileMode) error {
+	return w.source.Chmod(w.abs(name), mode)
+}
+
+func (w *WorkingDirFs) Chtimes(name string, atime, mtime time.Time) error {
+	return w.source.Chtimes(w.abs(name), atime, mtime)
+}
+
+func (w *WorkingDirFs) LstatIfPossible(name string) (os.FileInfo, bool, error) {
+	name = w.abs(name)
+	if lstater, ok := w.source.(Lstater); ok {
+		return lstater.LstatIfPossible(name)
+	}
+	fi, err := w.source.Stat(name)
+	return fi, false, err
+}
+
+// SymlinkIfPossible creates newname pointing to oldname, which is stored as
+// given, so that a relative target stays relative to the directory of the
+// link.
+func (w *WorkingDirFs) SymlinkIfPossible(oldname, newname string) error {
+	if linker, ok := w.source.(Linker); ok {
+		return linker.SymlinkIfPossible(oldname, w.abs(newname))
+	}
+	return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: ErrNoSymlink}
+}
+
+func (w *WorkingDirFs) ReadlinkIfPossible(name string) (string, error) {
+	if reader, ok := w.source.(LinkReader); ok {
+		return reader.ReadlinkIfPossible(w.abs(name))
+	}
+	return "", &os.PathError{Op: "readlink", Path: name, Err: ErrNoReadlink}
+}
+
+func (w *WorkingDirFs) LockIfPossible(name string, typ LockType, wait bool) (Unlocker, error) {
+	return LockFile(w.source, w.abs(name), typ, wait)
+}
+
+func (w *WorkingDirFs) GetXAttr(name, attr string) ([]byte, error) {
+	if x, ok := w.source.(XAttr); ok {
+		return x.GetXAttr(w.abs(name), attr)
+	}
+	return nil, &os.PathError{Op: "getxattr", Path: name, Err: ErrNoXAttr}
+}
+
+func (w *WorkingDirFs) SetXAttr(name, attr string, value []byte) error {
+	if x, ok := w.source.(XAttr); ok {
+		return x.SetXAttr(w.abs(name), attr, value)
+	}
+	return &os.PathError{Op: "setxattr", Path: name, Err: ErrNoXAttr}
+}
+
+func (w *WorkingDirFs) ListXAttrs(name string) ([]string, error) {
+	if x, ok := w.source.(XAttr); ok {
+		return x.ListXAttrs(w.abs(name))
+	}
+	return nil, &os.PathError{Op: "listxattr", Path: name, Err: ErrNoXAttr}
+}
+
+func (w *WorkingDirFs) RemoveXAttr(name, attr string) error {
+	if x, ok := w.source.(XAttr); ok {
+		return x.RemoveXAttr(w.abs(name), attr)
+	}
+	return &os.PathError{Op: "removexattr", Path: name, Err: ErrNoXAttr}
+}
+
+func (w *WorkingDirFs) StatFS(name string) (*FsStats, error) {
+	if s, ok := w.source.(StatFS); ok {
+		return s.StatFS(w.abs(name))
+	}
+	return nil, &os.PathError{Op: "statfs", Path: name, Err: ErrNoStatFS}
+}
+
+func (w *WorkingDirFs) CloneFile(oldname, newname string) error {
+	if c, ok := w.source.(Cloner); ok {
+		return c.CloneFile(w.abs(oldname), w.abs(newname))
+	}
+	return &os.LinkError{Op: "clone", Old: oldname, New: newname, Err: ErrNoClone}
+}
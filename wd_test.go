@@ -0,0 +1,103 @@
+package afero
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func testWorkingDir(t *testing.T, fs Fs) {
+	c := fs.(Chdirer)
+	if err := fs.MkdirAll("/a/b", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteFile(fs, "/a/b/f", []byte("f"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if wd, err := c.Getwd(); err != nil || wd != FilePathSeparator {
+		t.Errorf("expected to start in the root, got %q, %v", wd, err)
+	}
+
+	if err := c.Chdir("a"); err != nil {
+		t.Fatal(err)
+	}
+	if wd, err := c.Getwd(); err != nil || wd != filepath.FromSlash("/a") {
+		t.Errorf("expected /a, got %q, %v", wd, err)
+	}
+	if data, err := ReadFile(fs, "b/f"); err != nil || string(data) != "f" {
+		t.Errorf("expected to read b/f, got %q, %v", data, err)
+	}
+	if err := WriteFile(fs, "g", []byte("g"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Rename("g", "b/h"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Mkdir("c", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Stat("/a/b/h"); err != nil {
+		t.Errorf("expected relative paths to resolve against /a, got %v", err)
+	}
+	if names, err := readDirNames(fs, ".."); err != nil || !reflect.DeepEqual(names, []string{"a"}) {
+		t.Errorf("expected .. to be the root, got %v, %v", names, err)
+	}
+
+	if err := c.Chdir("b/f"); err == nil {
+		t.Error("expected changing to a file to fail")
+	}
+	if err := c.Chdir("missing"); !os.IsNotExist(err) {
+		t.Errorf("expected changing to a missing directory to fail with ErrNotExist, got %v", err)
+	}
+	if err := c.Chdir("b"); err != nil {
+		t.Fatal(err)
+	}
+
+	var walked []string
+	err := Walk(fs, ".", func(path string, info os.FileInfo, err error) error {
+		walked = append(walked, path)
+		return err
+	})
+	if err != nil || !reflect.DeepEqual(walked, []string{".", "f", "h"}) {
+		t.Errorf("expected to walk the working directory, got %v, %v", walked, err)
+	}
+	matches, err := Glob(fs, filepath.FromSlash("../*"))
+	expected := []string{filepath.FromSlash("../b"), filepath.FromSlash("../c")}
+	if err != nil || !reflect.DeepEqual(matches, expected) {
+		t.Errorf("expected %v, got %v, %v", expected, matches, err)
+	}
+
+	if err := fs.Remove("h"); err != nil {
+		t.Error(err)
+	}
+	if err := fs.RemoveAll(filepath.FromSlash("../c")); err != nil {
+		t.Error(err)
+	}
+	if names, err := readDirNames(fs, "/a"); err != nil || !reflect.DeepEqual(names, []string{"b"}) {
+		t.Errorf("expected only b to be left in /a, got %v, %v", names, err)
+	}
+}
+
+func TestMemMapFsWorkingDir(t *testing.T) {
+	testWorkingDir(t, NewMemMapFs())
+}
+
+func TestWorkingDirFs(t *testing.T) {
+	source := NewMemMapFs()
+	testWorkingDir(t, NewWorkingDirFs(source))
+	if wd, _ := source.(Chdirer).Getwd(); wd != FilePathSeparator {
+		t.Errorf("expected the working directory of the source to be unchanged, got %q", wd)
+	}
+
+	// the wrapper starts where the source is
+	if err := source.(Chdirer).Chdir("/a"); err != nil {
+		t.Fatal(err)
+	}
+	if wd, _ := NewWorkingDirFs(source).Getwd(); wd != filepath.FromSlash("/a") {
+		t.Errorf("expected to start in /a, got %q", wd)
+	}
+	if wd, _ := NewWorkingDirFs(&ReadOnlyFs{source: source}).Getwd(); wd != FilePathSeparator {
+		t.Errorf("expected to start in the root without a Chdirer, got %q", wd)
+	}
+}
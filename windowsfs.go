@@ -0,0 +1,588 @@
+package afero
+
+import (
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WindowsError is a Windows system error code, as reported by WindowsFs in
+// an os.PathError or os.LinkError. Like syscall.Errno on Windows, it matches
+// os.ErrNotExist, os.ErrExist and os.ErrPermission with errors.Is. Use
+// errors.Is rather than os.IsNotExist and friends, which only know the
+// errors of the system the program runs on.
+type WindowsError uint32
+
+// Windows error codes reported by WindowsFs, named like in package syscall on
+// Windows.
+const (
+	ERROR_FILE_NOT_FOUND    WindowsError = 2
+	ERROR_PATH_NOT_FOUND    WindowsError = 3
+	ERROR_ACCESS_DENIED     WindowsError = 5
+	ERROR_SHARING_VIOLATION WindowsError = 32
+	ERROR_BAD_NETPATH       WindowsError = 53
+	ERROR_FILE_EXISTS       WindowsError = 80
+	ERROR_INVALID_NAME      WindowsError = 123
+	ERROR_DIR_NOT_EMPTY     WindowsError = 145
+	ERROR_ALREADY_EXISTS    WindowsError = 183
+	ERROR_DIRECTORY         WindowsError = 267
+)
+
+var windowsErrors = map[WindowsError]string{
+	ERROR_FILE_NOT_FOUND:    "The system cannot find the file specified.",
+	ERROR_PATH_NOT_FOUND:    "The system cannot find the path specified.",
+	ERROR_ACCESS_DENIED:     "Access is denied.",
+	ERROR_SHARING_VIOLATION: "The process cannot access the file because it is being used by another process.",
+	ERROR_BAD_NETPATH:       "The network path was not found.",
+	ERROR_FILE_EXISTS:       "The file exists.",
+	ERROR_INVALID_NAME:      "The filename, directory name, or volume label syntax is incorrect.",
+	ERROR_DIR_NOT_EMPTY:     "The directory is not empty.",
+	ERROR_ALREADY_EXISTS:    "Cannot create a file when that file already exists.",
+	ERROR_DIRECTORY:         "The directory name is invalid.",
+}
+
+func (e WindowsError) Error() string {
+	if s, ok := windowsErrors[e]; ok {
+		return s
+	}
+	return "Windows error " + strconv.Itoa(int(e))
+}
+
+func (e WindowsError) Is(target error) bool {
+	switch target {
+	case os.ErrNotExist:
+		return e == ERROR_FILE_NOT_FOUND || e == ERROR_PATH_NOT_FOUND || e == ERROR_BAD_NETPATH
+	case os.ErrExist:
+		return e == ERROR_FILE_EXISTS || e == ERROR_ALREADY_EXISTS || e == ERROR_DIR_NOT_EMPTY
+	case os.ErrPermission:
+		return e == ERROR_ACCESS_DENIED
+	}
+	return false
+}
+
+var _ Chdirer = (*WindowsFs)(nil)
+
+// WindowsFs gives another Fs, normally a MemMapFs, the paths and errors of a
+// Windows file system, so code handling Windows paths can be tested on any
+// system:
+//
+//   - paths start with a drive letter like C: or a UNC share like
+//     \\server\share, or are relative to the working directory, which starts
+//     at C:\; a path like \dir is on the drive of the working directory
+//   - both \ and / separate path elements, and trailing dots and spaces are
+//     removed from each element
+//   - names are case-insensitive, but keep the case they were created with
+//   - names containing <>:"|?* or control characters, and the reserved
+//     device names CON, PRN, AUX, NUL, COM1 to COM9 and LPT1 to LPT9, with
+//     or without an extension, fail with ERROR_INVALID_NAME; the devices
+//     themselves are not emulated
+//   - files open through the WindowsFs cannot be removed or renamed, failing
+//     with ERROR_SHARING_VIOLATION
+//   - the only permission is the read-only attribute: files report mode 0666,
+//     or 0444 if created or changed with a perm without the 0200 bit, which
+//     makes opening them for writing and removing them fail with
+//     ERROR_ACCESS_DENIED; directories report 0777
+//
+// Failures are reported with the WindowsError codes Windows uses. Volumes are
+// kept in the source as top-level directories: C: as /C: and \\server\share
+// as /UNC/SERVER/SHARE.
+type WindowsFs struct {
+	source Fs
+
+	mu      sync.Mutex
+	wd      winPath
+	volumes map[string]string // volume by upper-case key
+	open    map[string]int    // open handles by source path
+}
+
+// winPath is a Windows path, broken into its volume and path elements.
+type winPath struct {
+	volume string
+	elems  []string
+}
+
+func (p winPath) String() string {
+	return p.volume + `\` + strings.Join(p.elems, `\`)
+}
+
+// NewWindowsFs returns a WindowsFs on source with a single volume, C:. The
+// source is addressed with slash-separated paths, as a MemMapFs from
+// NewSlashMemMapFs is on every host.
+func NewWindowsFs(source Fs) *WindowsFs {
+	w := &WindowsFs{source: source, volumes: make(map[string]string), open: make(map[string]int)}
+	w.AddVolume("C:")
+	w.wd = winPath{volume: "C:"}
+	return w
+}
+
+// NewWindowsMemMapFs returns a WindowsFs on a new slash-path MemMapFs.
+func NewWindowsMemMapFs() *WindowsFs {
+	return NewWindowsFs(NewSlashMemMapFs())
+}
+
+// AddVolume adds a drive like D: or a UNC share like \\server\share.
+func (w *WindowsFs) AddVolume(volume string) error {
+	p, err := w.parse(volume + `\`)
+	if err != nil && err != ERROR_PATH_NOT_FOUND && err != ERROR_BAD_NETPATH {
+		return &os.PathError{Op: "mkdir", Path: volume, Err: err}
+	}
+	if len(p.elems) > 0 {
+		return &os.PathError{Op: "mkdir", Path: volume, Err: ERROR_INVALID_NAME}
+	}
+	if err := w.source.MkdirAll(w.volumeDir(p.volume), 0777); err != nil {
+		return err
+	}
+	w.mu.Lock()
+	w.volumes[strings.ToUpper(p.volume)] = p.volume
+	w.mu.Unlock()
+	return nil
+}
+
+// volumeDir returns the directory of the source holding volume.
+func (w *WindowsFs) volumeDir(volume string) string {
+	volume = strings.ToUpper(volume)
+	if strings.HasPrefix(volume, `\\`) {
+		share := strings.SplitN(volume[2:], `\`, 2)
+		return path.Join("/UNC", share[0], share[1])
+	}
+	return "/" + volume
+}
+
+// parse resolves name against the working directory. It returns an error if
+// name is malformed or on an unknown volume, along with the parsed path in
+// the latter case.
+func (w *WindowsFs) parse(name string) (winPath, error) {
+	p := strings.Replace(name, "/", `\`, -1)
+	if strings.HasPrefix(p, `\\?\`) {
+		// skips normalization on Windows, which is not emulated
+		p = p[4:]
+		if strings.HasPrefix(strings.ToUpper(p), `UNC\`) {
+			p = `\\` + p[4:]
+		}
+	}
+
+	w.mu.Lock()
+	wd := w.wd
+	w.mu.Unlock()
+	var path winPath
+	switch {
+	case strings.HasPrefix(p, `\\`):
+		share := strings.SplitN(p[2:], `\`, 3)
+		if len(share) < 2 || share[0] == "" || share[1] == "" {
+			return path, ERROR_BAD_NETPATH
+		}
+		path.volume = `\\` + share[0] + `\` + share[1]
+		p = ""
+		if len(share) == 3 {
+			p = share[2]
+		}
+	case len(p) >= 2 && p[1] == ':' && isDriveLetter(p[0]):
+		path.volume = strings.ToUpper(p[:2])
+		p = p[2:]
+		if !strings.HasPrefix(p, `\`) && strings.EqualFold(path.volume, wd.volume) {
+			// relative to the working directory on the drive
+			path.elems = append(path.elems, wd.elems...)
+		}
+	case strings.HasPrefix(p, `\`):
+		path.volume = wd.volume
+	default:
+		path = winPath{volume: wd.volume, elems: append([]string(nil), wd.elems...)}
+	}
+
+	for _, elem := range strings.Split(p, `\`) {
+		switch elem {
+		case "", ".":
+			continue
+		case "..":
+			if len(path.elems) > 0 {
+				path.elems = path.elems[:len(path.elems)-1]
+			}
+			continue
+		}
+		if elem = strings.TrimRight(elem, ". "); elem == "" {
+			continue
+		}
+		if !validWindowsName(elem) {
+			return path, ERROR_INVALID_NAME
+		}
+		path.elems = append(path.elems, elem)
+	}
+
+	w.mu.Lock()
+	volume, ok := w.volumes[strings.ToUpper(path.volume)]
+	w.mu.Unlock()
+	if !ok {
+		if strings.HasPrefix(path.volume, `\\`) {
+			return path, ERROR_BAD_NETPATH
+		}
+		return path, ERROR_PATH_NOT_FOUND
+	}
+	path.volume = volume
+	return path, nil
+}
+
+func isDriveLetter(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+var windowsDeviceNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true,
+	"COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true,
+	"LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// validWindowsName reports whether name can be used as a file name.
+func validWindowsName(name string) bool {
+	for _, r := range name {
+		if r < 32 || strings.ContainsRune(`<>:"|?*`, r) {
+			return false
+		}
+	}
+	base := name
+	if i := strings.IndexByte(base, '.'); i >= 0 {
+		base = base[:i]
+	}
+	return !windowsDeviceNames[strings.ToUpper(strings.TrimRight(base, " "))]
+}
+
+// sourcePath returns the path of p in the source, matching its elements to
+// existing entries regardless of case. Elements not matching any entry keep
+// their case.
+func (w *WindowsFs) sourcePath(p winPath) string {
+	dir := w.volumeDir(p.volume)
+	for _, elem := range p.elems {
+		next := path.Join(dir, elem)
+		if _, err := w.source.Stat(next); err != nil {
+			if names, err := readDirNames(w.source, dir); err == nil {
+				for _, name := range names {
+					if strings.EqualFold(name, elem) {
+						next = path.Join(dir, name)
+						break
+					}
+				}
+			}
+		}
+		dir = next
+	}
+	return dir
+}
+
+// resolve parses name and returns its path in the source.
+func (w *WindowsFs) resolve(op, name string) (winPath, string, error) {
+	p, err := w.parse(name)
+	if err != nil {
+		return p, "", &os.PathError{Op: op, Path: name, Err: err}
+	}
+	return p, w.sourcePath(p), nil
+}
+
+// windowsError returns the Windows error code for an error of the source
+// about src, or nil if there is none.
+func (w *WindowsFs) windowsError(op, src string, err error) error {
+	switch underlyingError(err) {
+	case ErrNotEmpty:
+		return ERROR_DIR_NOT_EMPTY
+	case ErrIsDir:
+		return ERROR_ACCESS_DENIED
+	case ErrNotDir:
+		return ERROR_PATH_NOT_FOUND
+	}
+	switch {
+	case os.IsNotExist(err):
+		if fi, err := w.source.Stat(path.Dir(src)); err == nil && fi.IsDir() {
+			return ERROR_FILE_NOT_FOUND
+		}
+		return ERROR_PATH_NOT_FOUND
+	case os.IsExist(err):
+		if op == "mkdir" {
+			return ERROR_ALREADY_EXISTS
+		}
+		return ERROR_FILE_EXISTS
+	case os.IsPermission(err):
+		return ERROR_ACCESS_DENIED
+	}
+	return nil
+}
+
+func (w *WindowsFs) pathError(op, name, src string, err error) error {
+	if err == nil {
+		return nil
+	}
+	if code := w.windowsError(op, src, err); code != nil {
+		return &os.PathError{Op: op, Path: name, Err: code}
+	}
+	return err
+}
+
+func underlyingError(err error) error {
+	switch err := err.(type) {
+	case *os.PathError:
+		return err.Err
+	case *os.LinkError:
+		return err.Err
+	}
+	return err
+}
+
+// busy reports whether a file at or below src is open.
+func (w *WindowsFs) busy(src string) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for name := range w.open {
+		if name == src || strings.HasPrefix(name, src+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// readOnly reports whether src is a file with the read-only attribute.
+func (w *WindowsFs) readOnly(src string) bool {
+	fi, err := w.source.Stat(src)
+	return err == nil && !fi.IsDir() && fi.Mode()&0200 == 0
+}
+
+func windowsPerm(perm os.FileMode) os.FileMode {
+	if perm&0200 == 0 {
+		return 0444
+	}
+	return 0666
+}
+
+func (w *WindowsFs) Create(name string) (File, error) {
+	return w.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (w *WindowsFs) Mkdir(name string, perm os.FileMode) error {
+	_, src, err := w.resolve("mkdir", name)
+	if err != nil {
+		return err
+	}
+	return w.pathError("mkdir", name, src, w.source.Mkdir(src, 0777))
+}
+
+func (w *WindowsFs) MkdirAll(path string, perm os.FileMode) error {
+	_, src, err := w.resolve("mkdir", path)
+	if err != nil {
+		return err
+	}
+	return w.pathError("mkdir", path, src, w.source.MkdirAll(src, 0777))
+}
+
+func (w *WindowsFs) Open(name string) (File, error) {
+	return w.OpenFile(name, os.O_RDONLY, 0)
+}
+
+func (w *WindowsFs) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	_, src, err := w.resolve("open", name)
+	if err != nil {
+		return nil, err
+	}
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_APPEND|os.O_TRUNC) != 0 && w.readOnly(src) {
+		return nil, &os.PathError{Op: "open", Path: name, Err: ERROR_ACCESS_DENIED}
+	}
+	f, err := w.source.OpenFile(src, flag, windowsPerm(perm))
+	if err != nil {
+		return nil, w.pathError("open", name, src, err)
+	}
+	w.mu.Lock()
+	w.open[src]++
+	w.mu.Unlock()
+	return &windowsFile{File: f, fs: w, name: name, src: src}, nil
+}
+
+func (w *WindowsFs) Remove(name string) error {
+	_, src, err := w.resolve("remove", name)
+	if err != nil {
+		return err
+	}
+	if w.busy(src) {
+		return &os.PathError{Op: "remove", Path: name, Err: ERROR_SHARING_VIOLATION}
+	}
+	if w.readOnly(src) {
+		return &os.PathError{Op: "remove", Path: name, Err: ERROR_ACCESS_DENIED}
+	}
+	return w.pathError("remove", name, src, w.source.Remove(src))
+}
+
+func (w *WindowsFs) RemoveAll(path string) error {
+	_, src, err := w.resolve("remove", path)
+	if err != nil {
+		return err
+	}
+	if w.busy(src) {
+		return &os.PathError{Op: "remove", Path: path, Err: ERROR_SHARING_VIOLATION}
+	}
+	return w.pathError("remove", path, src, w.source.RemoveAll(src))
+}
+
+// Rename moves oldname to newname, replacing a file there, but not a
+// directory. It can change the case of a name.
+func (w *WindowsFs) Rename(oldname, newname string) error {
+	linkError := func(err error) error {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: err}
+	}
+	_, oldSrc, err := w.resolve("rename", oldname)
+	if err != nil {
+		return linkError(underlyingError(err))
+	}
+	newPath, newSrc, err := w.resolve("rename", newname)
+	if err != nil {
+		return linkError(underlyingError(err))
+	}
+	if _, err := w.source.Stat(oldSrc); err != nil {
+		return linkError(w.windowsError("rename", oldSrc, err))
+	}
+	if w.busy(oldSrc) {
+		return linkError(ERROR_SHARING_VIOLATION)
+	}
+	if len(newPath.elems) > 0 {
+		// keep the case of the new name
+		target := path.Join(path.Dir(newSrc), newPath.elems[len(newPath.elems)-1])
+		if fi, err := w.source.Stat(newSrc); err == nil && !strings.EqualFold(oldSrc, newSrc) {
+			switch {
+			case fi.IsDir():
+				return linkError(ERROR_ACCESS_DENIED)
+			case w.busy(newSrc):
+				return linkError(ERROR_SHARING_VIOLATION)
+			case w.readOnly(newSrc):
+				return linkError(ERROR_ACCESS_DENIED)
+			}
+			if err := w.source.Remove(newSrc); err != nil {
+				return err
+			}
+		}
+		newSrc = target
+	}
+	if err := w.source.Rename(oldSrc, newSrc); err != nil {
+		if code := w.windowsError("rename", newSrc, err); code != nil {
+			return linkError(code)
+		}
+		return err
+	}
+	return nil
+}
+
+func (w *WindowsFs) Stat(name string) (os.FileInfo, error) {
+	_, src, err := w.resolve("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := w.source.Stat(src)
+	if err != nil {
+		return nil, w.pathError("stat", name, src, err)
+	}
+	return windowsFileInfo{fi}, nil
+}
+
+func (w *WindowsFs) Name() string {
+	return "WindowsFs"
+}
+
+// Chmod sets the read-only attribute of a file if mode lacks the 0200 bit,
+// and clears it otherwise. Directories do not change.
+func (w *WindowsFs) Chmod(name string, mode os.FileMode) error {
+	_, src, err := w.resolve("chmod", name)
+	if err != nil {
+		return err
+	}
+	fi, err := w.source.Stat(src)
+	if err != nil {
+		return w.pathError("chmod", name, src, err)
+	}
+	if fi.IsDir() {
+		return nil
+	}
+	return w.pathError("chmod", name, src, w.source.Chmod(src, windowsPerm(mode)))
+}
+
+func (w *WindowsFs) Chtimes(name string, atime, mtime time.Time) error {
+	_, src, err := w.resolve("chtimes", name)
+	if err != nil {
+		return err
+	}
+	return w.pathError("chtimes", name, src, w.source.Chtimes(src, atime, mtime))
+}
+
+func (w *WindowsFs) Getwd() (string, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.wd.String(), nil
+}
+
+func (w *WindowsFs) Chdir(dir string) error {
+	p, src, err := w.resolve("chdir", dir)
+	if err != nil {
+		return err
+	}
+	fi, err := w.source.Stat(src)
+	if err != nil {
+		return w.pathError("chdir", dir, src, err)
+	}
+	if !fi.IsDir() {
+		return &os.PathError{Op: "chdir", Path: dir, Err: ERROR_DIRECTORY}
+	}
+	w.mu.Lock()
+	w.wd = p
+	w.mu.Unlock()
+	return nil
+}
+
+type windowsFile struct {
+	File
+	fs     *WindowsFs
+	name   string
+	src    string
+	closed bool
+}
+
+// Name returns the name the file was opened with, like os.File.Name.
+func (f *windowsFile) Name() string {
+	return f.name
+}
+
+func (f *windowsFile) Stat() (os.FileInfo, error) {
+	fi, err := f.File.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return windowsFileInfo{fi}, nil
+}
+
+func (f *windowsFile) Readdir(count int) ([]os.FileInfo, error) {
+	fis, err := f.File.Readdir(count)
+	for i := range fis {
+		fis[i] = windowsFileInfo{fis[i]}
+	}
+	return fis, err
+}
+
+func (f *windowsFile) Close() error {
+	f.fs.mu.Lock()
+	if !f.closed {
+		f.closed = true
+		if f.fs.open[f.src]--; f.fs.open[f.src] == 0 {
+			delete(f.fs.open, f.src)
+		}
+	}
+	f.fs.mu.Unlock()
+	return f.File.Close()
+}
+
+// windowsFileInfo reports the modes of files on Windows.
+type windowsFileInfo struct {
+	os.FileInfo
+}
+
+func (fi windowsFileInfo) Mode() os.FileMode {
+	mode := fi.FileInfo.Mode()
+	if mode.IsDir() {
+		return os.ModeDir | 0777
+	}
+	return mode&os.ModeType | windowsPerm(mode)
+}
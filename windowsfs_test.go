@@ -0,0 +1,228 @@
+package afero
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func windowsErrorCode(err error) WindowsError {
+	switch err := err.(type) {
+	case *os.PathError:
+		code, _ := err.Err.(WindowsError)
+		return code
+	case *os.LinkError:
+		code, _ := err.Err.(WindowsError)
+		return code
+	}
+	return 0
+}
+
+func TestWindowsFsPaths(t *testing.T) {
+	fs := NewWindowsMemMapFs()
+	if err := fs.MkdirAll(`C:\Users\Gopher`, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteFile(fs, `C:/Users/Gopher/Notes.TXT`, []byte("notes"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{
+		`C:\Users\Gopher\Notes.TXT`,
+		`c:\users\gopher\notes.txt`,
+		`C:/USERS/gopher/notes.txt`,
+		`C:\Users\Gopher\notes.txt. . `,
+		`C:\Users\.\Other\..\Gopher\notes.txt`,
+		`\\?\C:\Users\Gopher\Notes.TXT`,
+		`\Users\Gopher\Notes.TXT`,
+	} {
+		if data, err := ReadFile(fs, name); err != nil || string(data) != "notes" {
+			t.Errorf("%s: expected to read the file, got %q, %v", name, data, err)
+		}
+	}
+	if names, err := readDirNames(fs, `c:\USERS\GOPHER`); err != nil || !reflect.DeepEqual(names, []string{"Notes.TXT"}) {
+		t.Errorf("expected the case to be kept, got %v, %v", names, err)
+	}
+
+	if err := fs.Chdir(`c:\users`); err != nil {
+		t.Fatal(err)
+	}
+	if wd, _ := fs.Getwd(); wd != `C:\users` {
+		t.Errorf(`expected C:\users, got %q`, wd)
+	}
+	for _, name := range []string{`Gopher\Notes.TXT`, `C:Gopher\Notes.TXT`, `..\Users\Gopher\Notes.TXT`} {
+		if _, err := fs.Stat(name); err != nil {
+			t.Errorf("%s: expected to resolve against the working directory, got %v", name, err)
+		}
+	}
+	if err := fs.Chdir(`Gopher\Notes.TXT`); windowsErrorCode(err) != ERROR_DIRECTORY {
+		t.Errorf("expected ERROR_DIRECTORY changing to a file, got %v", err)
+	}
+
+	if _, err := fs.Stat(`D:\`); windowsErrorCode(err) != ERROR_PATH_NOT_FOUND {
+		t.Errorf("expected ERROR_PATH_NOT_FOUND on an unknown drive, got %v", err)
+	}
+	if _, err := fs.Stat(`\\server\share\file`); windowsErrorCode(err) != ERROR_BAD_NETPATH {
+		t.Errorf("expected ERROR_BAD_NETPATH on an unknown share, got %v", err)
+	}
+	if err := fs.AddVolume(`\\Server\Share`); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteFile(fs, `//server/share/file`, []byte("unc"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := ReadFile(fs, `\\?\UNC\SERVER\SHARE\FILE`); err != nil || string(data) != "unc" {
+		t.Errorf("expected to read the file on the share, got %q, %v", data, err)
+	}
+
+	for _, src := range []string{"/C:/Users/Gopher/Notes.TXT", "/UNC/SERVER/SHARE/file"} {
+		if _, err := fs.source.Stat(src); err != nil {
+			t.Errorf("%s: expected the file in the source, got %v", src, err)
+		}
+	}
+}
+
+func TestWindowsFsInvalidNames(t *testing.T) {
+	fs := NewWindowsMemMapFs()
+	for _, name := range []string{
+		`C:\CON`, `C:\nul.txt`, `C:\Com1 .log`, `C:\dir\LPT9`, `C:\a<b`, `C:\a|b`,
+		`C:\what?`, `C:\star*`, "C:\\tab\t", `C:\a:b`,
+	} {
+		if _, err := fs.Create(name); windowsErrorCode(err) != ERROR_INVALID_NAME {
+			t.Errorf("%s: expected ERROR_INVALID_NAME, got %v", name, err)
+		}
+	}
+	for _, name := range []string{`C:\CONSOLE`, `C:\COM10`, `C:\nul-device`} {
+		if _, err := fs.Create(name); err != nil {
+			t.Errorf("%s: expected a valid name, got %v", name, err)
+		}
+	}
+}
+
+func TestWindowsFsErrors(t *testing.T) {
+	fs := NewWindowsMemMapFs()
+	if err := fs.MkdirAll(`C:\dir\sub`, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteFile(fs, `C:\dir\file`, []byte("file"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		err  error
+		code WindowsError
+		is   error
+	}{
+		{fs.Mkdir(`C:\dir`, 0755), ERROR_ALREADY_EXISTS, os.ErrExist},
+		{fs.Remove(`C:\dir`), ERROR_DIR_NOT_EMPTY, nil},
+		{fs.Remove(`C:\dir\missing`), ERROR_FILE_NOT_FOUND, os.ErrNotExist},
+		{fs.Remove(`C:\missing\file`), ERROR_PATH_NOT_FOUND, os.ErrNotExist},
+		{fs.Mkdir(`C:\dir\file\sub`, 0755), ERROR_PATH_NOT_FOUND, os.ErrNotExist},
+		{fs.Rename(`C:\dir\file`, `C:\dir\sub`), ERROR_ACCESS_DENIED, os.ErrPermission},
+		{fs.Rename(`C:\dir\missing`, `C:\dir\other`), ERROR_FILE_NOT_FOUND, os.ErrNotExist},
+	}
+	for i, test := range tests {
+		if code := windowsErrorCode(test.err); code != test.code {
+			t.Errorf("%d: expected %v, got %v", i, test.code, test.err)
+		}
+		if test.is != nil && !errors.Is(test.err, test.is) {
+			t.Errorf("%d: expected %v to match %v", i, test.err, test.is)
+		}
+	}
+	if _, err := fs.OpenFile(`C:\dir\FILE`, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644); windowsErrorCode(err) != ERROR_FILE_EXISTS {
+		t.Errorf("expected ERROR_FILE_EXISTS, got %v", err)
+	}
+	if _, err := fs.OpenFile(`C:\dir`, os.O_RDWR, 0); windowsErrorCode(err) != ERROR_ACCESS_DENIED {
+		t.Errorf("expected ERROR_ACCESS_DENIED opening a directory for writing, got %v", err)
+	}
+}
+
+func TestWindowsFsOpenFiles(t *testing.T) {
+	fs := NewWindowsMemMapFs()
+	if err := fs.MkdirAll(`C:\dir`, 0755); err != nil {
+		t.Fatal(err)
+	}
+	f, err := fs.Create(`C:\dir\File`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Name() != `C:\dir\File` {
+		t.Errorf("expected the name to be kept, got %q", f.Name())
+	}
+	if err := fs.Remove(`C:\DIR\FILE`); windowsErrorCode(err) != ERROR_SHARING_VIOLATION {
+		t.Errorf("expected ERROR_SHARING_VIOLATION removing an open file, got %v", err)
+	}
+	if err := fs.Rename(`C:\dir`, `C:\other`); windowsErrorCode(err) != ERROR_SHARING_VIOLATION {
+		t.Errorf("expected ERROR_SHARING_VIOLATION renaming a directory with open files, got %v", err)
+	}
+	if err := fs.RemoveAll(`C:\dir`); windowsErrorCode(err) != ERROR_SHARING_VIOLATION {
+		t.Errorf("expected ERROR_SHARING_VIOLATION removing a directory with open files, got %v", err)
+	}
+	f.Close()
+	f.Close()
+
+	if err := fs.Rename(`C:\dir\File`, `C:\dir\FILE`); err != nil {
+		t.Fatal(err)
+	}
+	if names, err := readDirNames(fs, `C:\dir`); err != nil || !reflect.DeepEqual(names, []string{"FILE"}) {
+		t.Errorf("expected a case-only rename, got %v, %v", names, err)
+	}
+	if err := WriteFile(fs, `C:\dir\other`, []byte("other"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Rename(`C:\dir\other`, `C:\dir\file`); err != nil {
+		t.Fatalf("expected rename to replace a file, got %v", err)
+	}
+	if names, err := readDirNames(fs, `C:\dir`); err != nil || !reflect.DeepEqual(names, []string{"file"}) {
+		t.Errorf("expected only the renamed file, got %v, %v", names, err)
+	}
+	if err := fs.RemoveAll(`C:\dir`); err != nil {
+		t.Errorf("expected to remove the directory once closed, got %v", err)
+	}
+}
+
+func TestWindowsFsReadOnly(t *testing.T) {
+	fs := NewWindowsMemMapFs()
+	if err := WriteFile(fs, `C:\file`, []byte("file"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := fs.Stat(`C:\file`); err != nil || fi.Mode() != 0666 {
+		t.Errorf("expected mode 0666, got %v, %v", fi.Mode(), err)
+	}
+	if fi, err := fs.Stat(`C:\`); err != nil || fi.Mode() != os.ModeDir|0777 {
+		t.Errorf("expected mode drwxrwxrwx, got %v, %v", fi.Mode(), err)
+	}
+
+	if err := fs.Chmod(`C:\file`, 0400); err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := fs.Stat(`C:\file`); err != nil || fi.Mode() != 0444 {
+		t.Errorf("expected mode 0444, got %v, %v", fi.Mode(), err)
+	}
+	if _, err := fs.OpenFile(`C:\file`, os.O_WRONLY, 0); windowsErrorCode(err) != ERROR_ACCESS_DENIED {
+		t.Errorf("expected ERROR_ACCESS_DENIED writing a read-only file, got %v", err)
+	}
+	if err := fs.Remove(`C:\file`); windowsErrorCode(err) != ERROR_ACCESS_DENIED {
+		t.Errorf("expected ERROR_ACCESS_DENIED removing a read-only file, got %v", err)
+	}
+	if data, err := ReadFile(fs, `C:\file`); err != nil || string(data) != "file" {
+		t.Errorf("expected to read a read-only file, got %q, %v", data, err)
+	}
+	if err := fs.Chmod(`C:\file`, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Remove(`C:\file`); err != nil {
+		t.Errorf("expected to remove the file once writable, got %v", err)
+	}
+}
+
+func TestWindowsFsExists(t *testing.T) {
+	fs := NewWindowsMemMapFs()
+	if ok, err := Exists(fs, `C:\missing`); ok || err != nil {
+		t.Errorf("expected a missing file not to exist, got %v, %v", ok, err)
+	}
+	if ok, err := DirExists(fs, `c:\`); !ok || err != nil {
+		t.Errorf("expected the drive to exist, got %v, %v", ok, err)
+	}
+}
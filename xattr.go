@@ -0,0 +1,70 @@
+package afero
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"syscall"
+)
+
+// XAttr is an optional interface in Afero. It is only implemented by the
+// filesystems saying so.
+// It gives access to the extended attributes of a file, following symlinks
+// like the corresponding Linux system calls. Reading an attribute that is
+// not set fails with an os.PathError wrapping ENOATTR.
+type XAttr interface {
+	GetXAttr(name, attr string) ([]byte, error)
+	SetXAttr(name, attr string, value []byte) error
+	ListXAttrs(name string) ([]string, error)
+	RemoveXAttr(name, attr string) error
+}
+
+// ErrNoXAttr is the error wrapped in an os.PathError if a file system does
+// not support extended attributes either directly or through its delegated
+// filesystem.
+var ErrNoXAttr = errors.New("extended attributes not supported")
+
+// copyXAttrs copies all extended attributes of name from src to dst. It is a
+// no-op if either file system, or the underlying disk, does not support them.
+// Only the user namespace is copied strictly: attributes in other namespaces,
+// such as security.selinux or system.posix_acl_access, are copied where
+// possible, as setting them usually takes privileges.
+func copyXAttrs(src, dst Fs, name string) error {
+	sx, ok := src.(XAttr)
+	if !ok {
+		return nil
+	}
+	dx, ok := dst.(XAttr)
+	if !ok {
+		return nil
+	}
+	attrs, err := sx.ListXAttrs(name)
+	if err != nil {
+		if isNoXAttr(err) {
+			return nil
+		}
+		return err
+	}
+	for _, attr := range attrs {
+		strict := strings.HasPrefix(attr, "user.")
+		value, err := sx.GetXAttr(name, attr)
+		if err == nil {
+			err = dx.SetXAttr(name, attr, value)
+		}
+		switch {
+		case err == nil || !strict:
+		case isNoXAttr(err):
+			return nil
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+func isNoXAttr(err error) bool {
+	if pathErr, ok := err.(*os.PathError); ok {
+		err = pathErr.Err
+	}
+	return err == ErrNoXAttr || err == syscall.ENOTSUP
+}
@@ -0,0 +1,63 @@
+package afero
+
+import (
+	"os"
+	"strings"
+	"syscall"
+)
+
+func getXAttr(name, attr string) ([]byte, error) {
+	for {
+		size, err := syscall.Getxattr(name, attr, nil)
+		if err != nil {
+			return nil, &os.PathError{Op: "getxattr", Path: name, Err: err}
+		}
+		buf := make([]byte, size)
+		n, err := syscall.Getxattr(name, attr, buf)
+		if err == syscall.ERANGE {
+			continue // the value grew in between
+		}
+		if err != nil {
+			return nil, &os.PathError{Op: "getxattr", Path: name, Err: err}
+		}
+		return buf[:n], nil
+	}
+}
+
+func setXAttr(name, attr string, value []byte) error {
+	if err := syscall.Setxattr(name, attr, value, 0); err != nil {
+		return &os.PathError{Op: "setxattr", Path: name, Err: err}
+	}
+	return nil
+}
+
+func listXAttrs(name string) ([]string, error) {
+	for {
+		size, err := syscall.Listxattr(name, nil)
+		if err != nil {
+			return nil, &os.PathError{Op: "listxattr", Path: name, Err: err}
+		}
+		buf := make([]byte, size)
+		n, err := syscall.Listxattr(name, buf)
+		if err == syscall.ERANGE {
+			continue
+		}
+		if err != nil {
+			return nil, &os.PathError{Op: "listxattr", Path: name, Err: err}
+		}
+		var attrs []string
+		for _, attr := range strings.Split(string(buf[:n]), "\x00") {
+			if attr != "" {
+				attrs = append(attrs, attr)
+			}
+		}
+		return attrs, nil
+	}
+}
+
+func removeXAttr(name, attr string) error {
+	if err := syscall.Removexattr(name, attr); err != nil {
+		return &os.PathError{Op: "removexattr", Path: name, Err: err}
+	}
+	return nil
+}
@@ -0,0 +1,23 @@
+// +build !linux
+
+package afero
+
+import (
+	"os"
+)
+
+func getXAttr(name, attr string) ([]byte, error) {
+	return nil, &os.PathError{Op: "getxattr", Path: name, Err: ErrNoXAttr}
+}
+
+func setXAttr(name, attr string, value []byte) error {
+	return &os.PathError{Op: "setxattr", Path: name, Err: ErrNoXAttr}
+}
+
+func listXAttrs(name string) ([]string, error) {
+	return nil, &os.PathError{Op: "listxattr", Path: name, Err: ErrNoXAttr}
+}
+
+func removeXAttr(name, attr string) error {
+	return &os.PathError{Op: "removexattr", Path: name, Err: ErrNoXAttr}
+}
@@ -0,0 +1,201 @@
+package afero
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func testXAttr(t *testing.T, fs Fs, name string) {
+	x := fs.(XAttr)
+	if err := x.SetXAttr(name, "user.hash", []byte("abc")); err != nil {
+		t.Fatal(err)
+	}
+	if err := x.SetXAttr(name, "user.origin", []byte("test")); err != nil {
+		t.Fatal(err)
+	}
+	value, err := x.GetXAttr(name, "user.hash")
+	if err != nil || string(value) != "abc" {
+		t.Errorf("expected abc, got %q, %v", value, err)
+	}
+	attrs, err := x.ListXAttrs(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(attrs, []string{"user.hash", "user.origin"}) {
+		t.Errorf("unexpected attributes %v", attrs)
+	}
+	if err := x.RemoveXAttr(name, "user.hash"); err != nil {
+		t.Fatal(err)
+	}
+	_, err = x.GetXAttr(name, "user.hash")
+	if pathErr, ok := err.(*os.PathError); !ok || pathErr.Err != ENOATTR {
+		t.Errorf("expected ENOATTR for a removed attribute, got %v", err)
+	}
+}
+
+func TestMemMapFsXAttr(t *testing.T) {
+	fs := NewMemMapFs()
+	if err := WriteFile(fs, "/file", []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	testXAttr(t, fs, "/file")
+
+	// attributes belong to the file and follow it
+	if err := fs.Rename("/file", "/moved"); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := fs.(XAttr).GetXAttr("/moved", "user.origin"); err != nil || string(value) != "test" {
+		t.Errorf("expected attribute to survive rename, got %q, %v", value, err)
+	}
+}
+
+func TestOsFsXAttr(t *testing.T) {
+	osfs := NewOsFs()
+	dir, err := TempDir(osfs, "", "afero-xattr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer osfs.RemoveAll(dir)
+	name := filepath.Join(dir, "file")
+	if err := WriteFile(osfs, name, []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := osfs.(XAttr).SetXAttr(name, "user.probe", nil); err != nil {
+		if isNoXAttr(err) {
+			t.Skip("extended attributes not supported here")
+		}
+		t.Fatal(err)
+	}
+	osfs.(XAttr).RemoveXAttr(name, "user.probe")
+	testXAttr(t, osfs, name)
+}
+
+func TestBasePathFsXAttr(t *testing.T) {
+	fs := NewMemMapFs()
+	if err := fs.MkdirAll("/base", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteFile(fs, "/base/file", []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	testXAttr(t, NewBasePathFs(fs, "/base"), "/file")
+	if value, _ := fs.(XAttr).GetXAttr("/base/file", "user.origin"); string(value) != "test" {
+		t.Errorf("expected attribute to be set in the source, got %q", value)
+	}
+}
+
+func TestReadOnlyFsXAttr(t *testing.T) {
+	fs := NewMemMapFs()
+	if err := WriteFile(fs, "/file", []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fs.(XAttr).SetXAttr("/file", "user.hash", []byte("abc"))
+	ro := NewReadOnlyFs(fs).(XAttr)
+	if value, err := ro.GetXAttr("/file", "user.hash"); err != nil || string(value) != "abc" {
+		t.Errorf("expected abc, got %q, %v", value, err)
+	}
+	if err := ro.SetXAttr("/file", "user.hash", nil); err != syscall.EPERM {
+		t.Errorf("expected EPERM, got %v", err)
+	}
+	if err := ro.RemoveXAttr("/file", "user.hash"); err != syscall.EPERM {
+		t.Errorf("expected EPERM, got %v", err)
+	}
+}
+
+func TestCopyOnWriteFsXAttr(t *testing.T) {
+	base, layer := NewMemMapFs(), NewMemMapFs()
+	for _, name := range []string{"/a", "/b"} {
+		if err := WriteFile(base, name, []byte("data"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		base.(XAttr).SetXAttr(name, "user.hash", []byte("base"))
+	}
+	cow := NewCopyOnWriteFs(NewReadOnlyFs(base), layer)
+	x := cow.(XAttr)
+
+	if value, err := x.GetXAttr("/a", "user.hash"); err != nil || string(value) != "base" {
+		t.Errorf("expected base attribute, got %q, %v", value, err)
+	}
+	if err := x.SetXAttr("/a", "user.hash", []byte("layer")); err != nil {
+		t.Fatal(err)
+	}
+	if value, _ := base.(XAttr).GetXAttr("/a", "user.hash"); string(value) != "base" {
+		t.Errorf("base was modified: %q", value)
+	}
+	if value, _ := x.GetXAttr("/a", "user.hash"); string(value) != "layer" {
+		t.Errorf("expected layer attribute, got %q", value)
+	}
+
+	// copying a file up for writing keeps its attributes
+	f, err := cow.OpenFile("/b", os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if value, err := layer.(XAttr).GetXAttr("/b", "user.hash"); err != nil || string(value) != "base" {
+		t.Errorf("expected attribute to be copied to the layer, got %q, %v", value, err)
+	}
+}
+
+// privilegedXAttrFs fails to set attributes outside the user namespace with
+// EPERM, like an unprivileged process on a host using SELinux or ACLs.
+type privilegedXAttrFs struct {
+	*MemMapFs
+}
+
+func (p privilegedXAttrFs) SetXAttr(name, attr string, value []byte) error {
+	if !strings.HasPrefix(attr, "user.") {
+		return &os.PathError{Op: "setxattr", Path: name, Err: syscall.EPERM}
+	}
+	return p.MemMapFs.SetXAttr(name, attr, value)
+}
+
+func TestCopyOnWriteFsPrivilegedXAttr(t *testing.T) {
+	base, layer := NewMemMapFs(), privilegedXAttrFs{&MemMapFs{}}
+	if err := WriteFile(base, "/a", []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	base.(XAttr).SetXAttr("/a", "security.selinux", []byte("system_u:object_r:user_home_t:s0"))
+	base.(XAttr).SetXAttr("/a", "user.hash", []byte("base"))
+	cow := NewCopyOnWriteFs(base, layer)
+
+	f, err := cow.OpenFile("/a", os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatalf("expected the copy up to succeed without the security attribute, got %v", err)
+	}
+	f.Close()
+	if value, err := layer.GetXAttr("/a", "user.hash"); err != nil || string(value) != "base" {
+		t.Errorf("expected the user attribute to be copied, got %q, %v", value, err)
+	}
+	if _, err := layer.GetXAttr("/a", "security.selinux"); err == nil {
+		t.Error("expected the security attribute to be left out")
+	}
+
+	if err := WriteFile(base, "/b", []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	base.(XAttr).SetXAttr("/b", "user.hash", []byte("base"))
+	strict := NewCopyOnWriteFs(base, struct {
+		Fs
+		XAttr
+	}{layer, failingXAttr{layer}})
+	if _, err := strict.OpenFile("/b", os.O_WRONLY, 0); underlyingError(err) != syscall.EIO {
+		t.Errorf("expected a user attribute failing to fail the copy up, got %v", err)
+	}
+	if ok, _ := Exists(layer, "/b"); ok {
+		t.Error("expected the failed copy up to be removed from the layer")
+	}
+}
+
+// failingXAttr fails to set any attribute with EIO.
+type failingXAttr struct {
+	XAttr
+}
+
+func (failingXAttr) SetXAttr(name, attr string, value []byte) error {
+	return &os.PathError{Op: "setxattr", Path: name, Err: syscall.EIO}
+}